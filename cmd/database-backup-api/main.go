@@ -1,22 +1,30 @@
 package main
 
 import (
-	"database/sql"
+	"context"
+	"errors"
 	"flag"
-	"fmt"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
+	_ "github.com/lib/pq"
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/jbaikge/database-backups/pkg/api"
 	"github.com/jbaikge/database-backups/pkg/app"
+	"github.com/jbaikge/database-backups/pkg/backup"
+	"github.com/jbaikge/database-backups/pkg/logging"
+	"github.com/jbaikge/database-backups/pkg/notify"
 	"github.com/jbaikge/database-backups/pkg/repository"
+	"github.com/jbaikge/database-backups/pkg/tracing"
 )
 
 func main() {
 	if err := run(os.Args); err != nil {
-		fmt.Fprintf(os.Stderr, "Start-up error: %s\n", err)
+		logging.Default().Error("Start-up error", "error", err)
 		os.Exit(1)
 	}
 }
@@ -24,46 +32,121 @@ func main() {
 func run(args []string) error {
 	databasePath := "/tmp/database-backups.sqlite3"
 	listenAddress := "0.0.0.0:3000"
+	dumpDir := "/tmp/dumps"
+	bucket := ""
+	staleLock := 12 * time.Hour
+	runScheduler := false
+	logFormat := logging.FormatText
+	logLevel := "info"
+	trace := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
 
 	flags := flag.NewFlagSet(args[0], flag.ExitOnError)
-	flags.StringVar(&databasePath, "db", databasePath, "Path to configuration and logging database")
+	flags.StringVar(&databasePath, "db", databasePath, "Configuration and logging database: a SQLite path or a postgres:// URL")
 	flags.StringVar(&listenAddress, "addr", listenAddress, "API listening address")
+	flags.StringVar(&dumpDir, "dir", dumpDir, "Directory to store dumps")
+	flags.StringVar(&bucket, "bucket", bucket, "AWS Bucket to store dumps")
+	flags.DurationVar(&staleLock, "stale-lock", staleLock, "Age after which a lock held on another host is taken over, if the database timeout is shorter")
+	flags.BoolVar(&runScheduler, "schedule", runScheduler, "Run scheduled backups from within the API")
+	flags.StringVar(&trace, "trace", trace, "Export spans of scheduled backups to this OTLP/HTTP collector URL, or to stdout")
+	flags.StringVar(&logFormat, "log-format", logFormat, "Log format: text or json")
+	flags.StringVar(&logLevel, "log-level", logLevel, "Lowest level logged: debug, info, warn or error")
 	if err := flags.Parse(args[1:]); err != nil {
 		return err
 	}
 
-	db, err := setupDatabase(databasePath)
+	level, err := logging.ParseLevel(logLevel)
 	if err != nil {
 		return err
 	}
+	logger, err := logging.New(os.Stderr, logFormat, level)
+	if err != nil {
+		return err
+	}
+	logging.SetDefault(logger)
+
+	if trace != "" {
+		exporter, err := tracing.NewExporter(trace, "database-backup-api")
+		if err != nil {
+			return err
+		}
+		provider := tracing.NewProvider(exporter, func(err error) {
+			logging.Default().Error("Exporting spans failed", "error", err)
+		})
+		tracing.SetProvider(provider)
+		defer provider.Shutdown(context.Background())
+	}
 
-	storage := repository.NewStorage(db)
+	storage, err := repository.Open(databasePath)
+	if err != nil {
+		return err
+	}
 
 	if err := storage.RunMigrations(); err != nil {
 		return err
 	}
 
-	router := gin.Default()
+	router := gin.New()
+	router.Use(gin.Recovery(), app.RequestLogger())
 	router.SetTrustedProxies(nil)
 	router.Use(cors.Default())
 
-	serverService := api.NewServerService(storage)
+	settingService := api.NewSettingService(storage)
+	webhookService := api.NewWebhookService(storage)
+	webhooks := notify.NewWebhooks(webhookService)
+	notifier := notify.Multi{notify.Log{}, webhooks}
+	serverService := api.NewServerService(storage, settingService, notifier)
 	databaseService := api.NewDatabaseService(storage)
+	logService := api.NewLogService(storage)
+	lockService := api.NewLockService(storage, staleLock)
 
-	server := app.NewServer(router, serverService, databaseService)
+	ctx, cancel := signalContext()
+	defer cancel()
 
-	return server.Run(listenAddress)
-}
+	var queue *backup.Queue
+	queueDone := make(chan struct{})
+	scheduler := backup.NewScheduler(serverService, databaseService, logService, nil)
+	if runScheduler {
+		if bucket == "" {
+			return errors.New("bucket name is empty")
+		}
 
-func setupDatabase(path string) (*sql.DB, error) {
-	db, err := sql.Open("sqlite3", path)
-	if err != nil {
-		return nil, err
+		runner := backup.NewRunner(logService, lockService, settingService, notifier, dumpDir, bucket)
+		queue = backup.NewQueue(runner, 1000)
+		scheduler = backup.NewScheduler(serverService, databaseService, logService, queue)
+
+		go func() {
+			queue.Run(ctx)
+			close(queueDone)
+		}()
+		go scheduler.Run(ctx)
+	}
+
+	server := app.NewServer(router, serverService, databaseService, logService, settingService, webhookService, webhooks, scheduler, queue)
+
+	if err := server.Run(ctx, listenAddress); err != nil {
+		return err
 	}
 
-	if err := db.Ping(); err != nil {
-		return nil, err
+	// Let the job in progress record its cancellation before exiting
+	if queue != nil {
+		<-queueDone
 	}
+	return nil
+}
 
-	return db, nil
+// signalContext returns a context cancelled on SIGINT or SIGTERM
+func signalContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		select {
+		case sig := <-signals:
+			logging.Default().Warn("Stopping", "signal", sig)
+			cancel()
+		case <-ctx.Done():
+		}
+		signal.Stop(signals)
+	}()
+	return ctx, cancel
 }
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"time"
+
+	"github.com/jbaikge/database-backups/pkg/api"
+	"github.com/jbaikge/database-backups/pkg/backup"
+	"github.com/jbaikge/database-backups/pkg/logging"
+)
+
+func runBinlogArchive(args []string) error {
+	databasePath := "/tmp/database-backups.sqlite3"
+	dumpDir := "/tmp/dumps"
+	bucket := ""
+	staleLock := 12 * time.Hour
+	interval := 5 * time.Minute
+	once := false
+	var selector backup.Selector
+
+	flags := flag.NewFlagSet(args[0], flag.ExitOnError)
+	flags.StringVar(&databasePath, "db", databasePath, "Configuration and logging database: a SQLite path or a postgres:// URL")
+	flags.StringVar(&dumpDir, "dir", dumpDir, "Directory to copy binlogs to before upload")
+	flags.StringVar(&bucket, "bucket", bucket, "AWS Bucket to send binlogs to")
+	flags.DurationVar(&staleLock, "stale-lock", staleLock, "Take over locks held on another host once older than this, left behind by a crashed run")
+	flags.DurationVar(&interval, "interval", interval, "Time between checks for closed binlogs")
+	flags.BoolVar(&once, "once", once, "Archive closed binlogs once and exit")
+	flags.Var((*stringList)(&selector.Servers), "server", "Only archive servers matching this glob (repeatable)")
+	flags.Var((*stringList)(&selector.Tags), "tag", "Only archive servers with a tag matching this glob (repeatable)")
+	logOptions := addLogFlags(flags)
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+	if err := logOptions.setup(); err != nil {
+		return err
+	}
+
+	if err := selector.Validate(); err != nil {
+		return err
+	}
+
+	if bucket == "" {
+		return errors.New("bucket name is empty")
+	}
+
+	if err := checkEnvironment(); err != nil {
+		return err
+	}
+
+	storage, err := openStorage(databasePath)
+	if err != nil {
+		return err
+	}
+
+	serverService := api.NewServerService(storage, api.NewSettingService(storage), newNotifier(storage))
+	archiver := backup.NewArchiver(
+		api.NewBinlogService(storage),
+		api.NewLockService(storage, staleLock),
+		dumpDir,
+		bucket,
+	)
+
+	ctx, cancel := signalContext()
+	defer cancel()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		// Servers are looked up on every pass to pick up configuration changes
+		servers, err := serverService.List()
+		if err != nil {
+			return err
+		}
+
+		for _, server := range servers {
+			if server.Binlog == "" || !selector.MatchServer(server, nil) {
+				continue
+			}
+
+			shipped, err := archiver.Archive(ctx, server)
+			if err != nil {
+				logging.Error(ctx, "Archiving binlogs failed", "server", server.Name, "error", err)
+			}
+			if shipped > 0 {
+				logging.Info(ctx, "Archived binlogs", "binlogs", shipped, "server", server.Name)
+			}
+		}
+
+		if once {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jbaikge/database-backups/pkg/api"
+	"github.com/jbaikge/database-backups/pkg/repository"
+)
+
+// Nagios plugin exit codes
+const (
+	checkOK       = 0
+	checkCritical = 2
+	checkUnknown  = 3
+)
+
+// runCheck prints a one-line Nagios status for backup staleness and exits
+// with the matching code
+func runCheck(args []string) error {
+	databasePath := "/tmp/database-backups.sqlite3"
+
+	flags := flag.NewFlagSet(args[0], flag.ExitOnError)
+	flags.StringVar(&databasePath, "db", databasePath, "Configuration and logging database: a SQLite path or a postgres:// URL")
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	health, err := checkBackups(databasePath)
+	if err != nil {
+		fmt.Printf("BACKUPS UNKNOWN - %s\n", err)
+		return exitStatus(checkUnknown)
+	}
+
+	// An empty or mistyped -db must not look like every backup being fine
+	if health.Checked == 0 {
+		fmt.Println("BACKUPS UNKNOWN - no databases are being backed up")
+		return exitStatus(checkUnknown)
+	}
+
+	perfdata := fmt.Sprintf("stale=%d;;;0;%d", len(health.Stale), health.Checked)
+	if health.OK() {
+		fmt.Printf("BACKUPS OK - %d databases backed up within their max age | %s\n", health.Checked, perfdata)
+		return nil
+	}
+
+	stale := make([]string, len(health.Stale))
+	for i, backup := range health.Stale {
+		last := "never backed up"
+		if backup.LastSuccess != nil {
+			last = backup.Age.Truncate(time.Minute).String() + " old"
+		}
+		stale[i] = fmt.Sprintf("%s/%s (%s, max %s)", backup.Server, backup.Database, last, backup.MaxAge)
+	}
+	fmt.Printf("BACKUPS CRITICAL - %d of %d stale: %s | %s\n", len(health.Stale), health.Checked, strings.Join(stale, ", "), perfdata)
+	return exitStatus(checkCritical)
+}
+
+// checkBackups only reads the database: it is neither created nor migrated
+func checkBackups(databasePath string) (api.BackupHealth, error) {
+	storage, err := repository.OpenReadOnly(databasePath)
+	if err != nil {
+		return api.BackupHealth{}, err
+	}
+
+	stats, err := api.NewLogService(storage).Stats()
+	if err != nil {
+		return api.BackupHealth{}, err
+	}
+	maxAge, err := api.NewSettingService(storage).Duration(api.SettingMaxAge)
+	if err != nil {
+		return api.BackupHealth{}, err
+	}
+	return api.CheckBackups(stats, maxAge, time.Now()), nil
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/jbaikge/database-backups/pkg/api"
+	"github.com/jbaikge/database-backups/pkg/config"
+	"github.com/jbaikge/database-backups/pkg/notify"
+)
+
+var configCommands = map[string]func([]string) error{
+	"diff":   runConfigDiff,
+	"export": runConfigExport,
+	"import": runConfigImport,
+}
+
+// runConfig dispatches to config export, diff and import
+func runConfig(args []string) error {
+	if len(args) > 1 {
+		if command, ok := configCommands[args[1]]; ok {
+			return command(args[1:])
+		}
+	}
+	return fmt.Errorf("usage: %s config export|diff|import [flags]", os.Args[0])
+}
+
+func runConfigExport(args []string) error {
+	databasePath := "/tmp/database-backups.sqlite3"
+	output := "-"
+	secrets := config.SecretsEncrypted
+
+	flags := flag.NewFlagSet(args[0], flag.ExitOnError)
+	flags.StringVar(&databasePath, "db", databasePath, "Configuration and logging database: a SQLite path or a postgres:// URL")
+	flags.StringVar(&output, "o", output, "File to write the configuration to, - for stdout")
+	flags.StringVar(&secrets, "secrets", secrets, "Write secrets encrypted with DATABASE_BACKUP_KEY, or omit them: encrypted or omit")
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	services, err := configServices(databasePath)
+	if err != nil {
+		return err
+	}
+
+	exported, err := services.Export(secrets)
+	if err != nil {
+		return err
+	}
+
+	if output == "-" {
+		return exported.Write(os.Stdout)
+	}
+	f, err := os.OpenFile(output, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if err := exported.Write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func runConfigDiff(args []string) error {
+	_, _, err := planConfig(args)
+	return err
+}
+
+func runConfigImport(args []string) error {
+	changes, dryRun, err := planConfig(args)
+	if err != nil || dryRun || len(changes) == 0 {
+		return err
+	}
+
+	if err := config.Apply(changes); err != nil {
+		return err
+	}
+	fmt.Printf("Applied %d changes\n", len(changes))
+	return nil
+}
+
+// planConfig prints the changes a file would make. Import and diff share
+// their flags; diff ignores -dry-run as it never applies anything.
+func planConfig(args []string) ([]config.Change, bool, error) {
+	databasePath := "/tmp/database-backups.sqlite3"
+	input := ""
+	prune := false
+	dryRun := false
+
+	flags := flag.NewFlagSet(args[0], flag.ExitOnError)
+	flags.StringVar(&databasePath, "db", databasePath, "Configuration and logging database: a SQLite path or a postgres:// URL")
+	flags.StringVar(&input, "f", input, "File to read the configuration from, - for stdin")
+	flags.BoolVar(&prune, "prune", prune, "Remove servers, databases and webhooks missing from the file and reset settings missing from it")
+	flags.BoolVar(&dryRun, "dry-run", dryRun, "Print the changes without making them")
+	if err := flags.Parse(args[1:]); err != nil {
+		return nil, false, err
+	}
+
+	if input == "" {
+		return nil, false, fmt.Errorf("-f is required")
+	}
+
+	var r io.Reader = os.Stdin
+	if input != "-" {
+		f, err := os.Open(input)
+		if err != nil {
+			return nil, false, err
+		}
+		defer f.Close()
+		r = f
+	}
+	desired, err := config.Load(r)
+	if err != nil {
+		return nil, false, fmt.Errorf("%s: %s", input, err)
+	}
+
+	services, err := configServices(databasePath)
+	if err != nil {
+		return nil, false, err
+	}
+
+	changes, err := services.Plan(desired, prune)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if len(changes) == 0 {
+		fmt.Println("No changes")
+	}
+	for _, change := range changes {
+		fmt.Println(change)
+	}
+	return changes, dryRun, nil
+}
+
+func configServices(databasePath string) (config.Services, error) {
+	storage, err := openStorage(databasePath)
+	if err != nil {
+		return config.Services{}, err
+	}
+
+	settingService := api.NewSettingService(storage)
+	return config.Services{
+		Servers:   api.NewServerService(storage, settingService, notify.Log{}),
+		Databases: api.NewDatabaseService(storage),
+		Settings:  settingService,
+		Webhooks:  api.NewWebhookService(storage),
+	}, nil
+}
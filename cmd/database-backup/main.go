@@ -1,83 +1,190 @@
 package main
 
 import (
-	"database/sql"
+	"context"
 	"errors"
 	"flag"
 	"fmt"
-	"log"
 	"os"
-	"os/exec"
-	"path/filepath"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 	"github.com/jbaikge/database-backups/pkg/api"
+	"github.com/jbaikge/database-backups/pkg/backup"
+	"github.com/jbaikge/database-backups/pkg/logging"
+	"github.com/jbaikge/database-backups/pkg/metrics"
+	"github.com/jbaikge/database-backups/pkg/notify"
 	"github.com/jbaikge/database-backups/pkg/repository"
+	"github.com/jbaikge/database-backups/pkg/tracing"
+	_ "github.com/lib/pq"
 	_ "github.com/mattn/go-sqlite3"
 )
 
 func main() {
 	if err := run(os.Args); err != nil {
-		fmt.Fprintf(os.Stderr, "Execution error: %s\n", err)
+		var status exitStatus
+		if errors.As(err, &status) {
+			os.Exit(int(status))
+		}
+		logging.Default().Error("Execution error", "error", err)
 		os.Exit(1)
 	}
 }
 
+// How long a dry run waits for the bucket's encryption and lifecycle
+const bucketTimeout = 10 * time.Second
+
+// Subcommands; anything else runs a backup
+var commands = map[string]func([]string) error{
+	"binlog-archive":   runBinlogArchive,
+	"check":            runCheck,
+	"config":           runConfig,
+	"migrate":          runMigrate,
+	"restore":          runRestore,
+	"verify-integrity": runVerifyIntegrity,
+}
+
+// exitStatus ends the program with that code once the command has already
+// reported its outcome
+type exitStatus int
+
+func (e exitStatus) Error() string {
+	return fmt.Sprintf("exit status %d", int(e))
+}
+
 func run(args []string) error {
+	if len(args) > 1 {
+		if command, ok := commands[args[1]]; ok {
+			return command(args[1:])
+		}
+	}
+	return runBackup(args)
+}
+
+func runBackup(args []string) error {
 	databasePath := "/tmp/database-backups.sqlite3"
 	dumpDir := "/tmp/dumps"
 	onlyUpdate := false
+	dryRun := false
+	format := "table"
 	bucket := ""
+	var selector backup.Selector
+	staleLock := 12 * time.Hour
+	pushGateway := ""
+	pushJob := "database-backup"
+	textfile := ""
+	trace := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
 
 	flags := flag.NewFlagSet(args[0], flag.ExitOnError)
-	flags.StringVar(&databasePath, "db", databasePath, "Path to configuration and logging database")
+	flags.StringVar(&databasePath, "db", databasePath, "Configuration and logging database: a SQLite path or a postgres:// URL")
 	flags.StringVar(&dumpDir, "dir", dumpDir, "Directory to store dumps")
 	flags.StringVar(&bucket, "bucket", bucket, "AWS Bucket to store dumps")
+	flags.DurationVar(&staleLock, "stale-lock", staleLock, "Age after which a lock held on another host is taken over, if the database timeout is shorter")
 	flags.BoolVar(&onlyUpdate, "update", onlyUpdate, "Only update database lists for servers")
+	flags.BoolVar(&dryRun, "dry-run", dryRun, "Print what would be done without executing anything")
+	flags.StringVar(&format, "format", format, "Dry run output format: table or json")
+	flags.StringVar(&pushGateway, "push-gateway", pushGateway, "Pushgateway URL to push metrics to after the run")
+	flags.StringVar(&pushJob, "push-job", pushJob, "Job name for metrics pushed to the Pushgateway")
+	flags.StringVar(&trace, "trace", trace, "Export spans to this OTLP/HTTP collector URL, or to stdout")
+	flags.StringVar(&textfile, "textfile", textfile, "Write metrics for the node_exporter textfile collector to this .prom file after the run")
+	flags.Var((*stringList)(&selector.Servers), "server", "Only process servers matching this glob (repeatable)")
+	flags.Var((*stringList)(&selector.Databases), "database", "Only process databases matching this glob, optionally as server/database (repeatable)")
+	flags.Var((*stringList)(&selector.Tags), "tag", "Only process servers or databases with a tag matching this glob (repeatable)")
+	logOptions := addLogFlags(flags)
 	if err := flags.Parse(args[1:]); err != nil {
 		return err
 	}
+	if err := logOptions.setup(); err != nil {
+		return err
+	}
+
+	if err := selector.Validate(); err != nil {
+		return err
+	}
 
 	// Ensure bucket is set as I keep forgetting to set this flag
 	if bucket == "" {
 		return errors.New("bucket name is empty")
 	}
 
-	// Check for required environment variables
-	envvars := []string{
-		"AWS_ACCESS_KEY_ID",
-		"AWS_SECRET_ACCESS_KEY",
-		"AWS_REGION",
+	if format != "table" && format != "json" {
+		return fmt.Errorf("unknown format: %s", format)
 	}
-	for _, envvar := range envvars {
-		if os.Getenv(envvar) == "" {
-			return errors.New("environment variable, " + envvar + " is required")
+
+	if !dryRun {
+		if err := checkEnvironment(); err != nil {
+			return err
 		}
 	}
 
-	db, err := setupDatabase(databasePath)
+	open := openStorage
+	if dryRun {
+		// A dry run changes nothing, not even the schema
+		open = repository.OpenReadOnly
+	}
+	storage, err := open(databasePath)
 	if err != nil {
 		return err
 	}
 
-	storage := repository.NewStorage(db)
-	if err := storage.RunMigrations(); err != nil {
+	settingService := api.NewSettingService(storage)
+	notifier := newNotifier(storage)
+	serverService := api.NewServerService(storage, settingService, notifier)
+	databaseService := api.NewDatabaseService(storage)
+	logService := api.NewLogService(storage)
+	lockService := api.NewLockService(storage, staleLock)
+
+	allServers, err := serverService.List()
+	if err != nil {
 		return err
 	}
 
-	serverService := api.NewServerService(storage)
-	databaseService := api.NewDatabaseService(storage)
+	servers := make([]api.Server, 0, len(allServers))
+	for _, server := range allServers {
+		databases, err := databaseService.List(server.Id)
+		if err != nil {
+			return err
+		}
+		if selector.MatchServer(server, databases) {
+			servers = append(servers, server)
+		}
+	}
+
+	runner := backup.NewRunner(logService, lockService, settingService, notifier, dumpDir, bucket)
 
-	servers, err := serverService.List()
+	if dryRun {
+		return printPlan(runner, databaseService, servers, selector, onlyUpdate, format, bucket)
+	}
+
+	ctx, cancel := signalContext()
+	defer cancel()
+
+	if trace != "" {
+		shutdown, err := startTracing(trace, "database-backup")
+		if err != nil {
+			return err
+		}
+		defer shutdown()
+	}
+
+	runTimeout, err := settingService.Duration(api.SettingRunTimeout)
 	if err != nil {
 		return err
 	}
+	if runTimeout > 0 {
+		var cancelRun context.CancelFunc
+		ctx, cancelRun = context.WithTimeout(ctx, runTimeout)
+		defer cancelRun()
+	}
+
+	ctx, span := tracing.Start(ctx, "run")
+	defer span.End()
 
 	for _, server := range servers {
-		log.Printf("Updating database list for %s", server.Name)
-		if err := serverService.UpdateDatabases(server.Id); err != nil {
+		logging.Info(ctx, "Updating database list", "server", server.Name)
+		if err := runner.Refresh(ctx, serverService, server); err != nil {
 			return err
 		}
 	}
@@ -87,107 +194,253 @@ func run(args []string) error {
 		return nil
 	}
 
-	if err := os.MkdirAll(dumpDir, 0755); err != nil {
-		return err
-	}
-
+	// A failed database does not stop the others; only cancellation of the
+	// whole run does
+	started := time.Now()
+	attempted, failed, skipped := 0, 0, 0
+	results := make([]api.BackupResult, 0, 100)
 	for _, server := range servers {
-		log.Printf("Dumping databases in %s", server.Name)
+		logging.Info(ctx, "Dumping databases", "server", server.Name)
 		databases, err := databaseService.List(server.Id)
 		if err != nil {
 			return err
 		}
 		for _, database := range databases {
-			if !database.Backup {
+			if !database.Backup || !selector.MatchDatabase(server, database) {
 				continue
 			}
 
-			filename := filepath.Join(dumpDir, server.Filename(database))
-			log.Printf("Dumping %s to %s", database.Name, filename)
-			if err := dumpDatabase(server, database, filename); err != nil {
-				return err
+			err := runner.Backup(ctx, server, database)
+			var lockErr *api.LockError
+			if errors.As(err, &lockErr) {
+				logging.Warn(ctx, "Skipping locked database", "server", server.Name, "database", database.Name, "error", lockErr)
+				skipped++
+				continue
 			}
 
-			key := server.S3Key(database)
-			log.Printf("Sending to S3 s3://%s/%s", bucket, key)
-			if err := sendToS3(bucket, filename, key); err != nil {
+			attempted++
+			if ctx.Err() != nil {
 				return err
 			}
-
-			log.Printf("Removing temporary file")
-			if err := os.Remove(filename); err != nil {
-				return err
+			// The log entry holds the sizes and status for the run summary
+			entry, lastErr := logService.Last(database.Id)
+			if lastErr != nil {
+				logging.Error(ctx, "Reading backup result failed", "server", server.Name, "database", database.Name, "error", lastErr)
+			} else if entry != nil {
+				results = append(results, api.NewBackupResult(server, database, *entry))
+			}
+			if err != nil {
+				logging.Error(ctx, "Backup failed", "server", server.Name, "database", database.Name, "error", err)
+				failed++
 			}
 		}
 	}
 
+	span.SetAttributes(
+		tracing.Int("attempted", attempted),
+		tracing.Int("failed", failed),
+		tracing.Int("skipped", skipped),
+	)
+	runner.NotifyRunSummary(ctx, results, skipped, started)
+	exportMetrics(ctx, logService, pushGateway, pushJob, textfile)
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d backups failed", failed, attempted)
+	}
+
 	return nil
 }
 
-func setupDatabase(path string) (*sql.DB, error) {
-	db, err := sql.Open("sqlite3", path)
-	if err != nil {
-		return nil, err
+// exportMetrics pushes the backup history to a Pushgateway and writes it to a
+// textfile, as requested. Failures are logged as the backups themselves are
+// done by now.
+func exportMetrics(ctx context.Context, logService api.LogService, pushGateway string, pushJob string, textfile string) {
+	if pushGateway == "" && textfile == "" {
+		return
 	}
 
-	if err := db.Ping(); err != nil {
-		return nil, err
+	stats, err := logService.Stats()
+	if err != nil {
+		logging.Error(ctx, "Collecting metrics failed", "error", err)
+		return
 	}
+	families := metrics.BackupFamilies(stats)
 
-	return db, nil
+	if pushGateway != "" {
+		if err := metrics.Push(ctx, pushGateway, pushJob, families); err != nil {
+			logging.Error(ctx, "Pushing metrics failed", "gateway", pushGateway, "error", err)
+		}
+	}
+	if textfile != "" {
+		if err := metrics.WriteFile(textfile, families); err != nil {
+			logging.Error(ctx, "Writing metrics failed", "file", textfile, "error", err)
+		}
+	}
 }
 
-func dumpDatabase(server api.Server, database api.Database, path string) error {
-	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0600)
+// printPlan resolves the run without touching any server. The plan reflects
+// the database lists as they currently stand, before any refresh. The
+// bucket's encryption and lifecycle are read when AWS credentials allow, and
+// shown as unknown otherwise.
+func printPlan(runner *backup.Runner, databaseService api.DatabaseService, servers []api.Server, selector backup.Selector, onlyUpdate bool, format string, bucketName string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), bucketTimeout)
+	defer cancel()
+	bucket, err := backup.LoadBucket(ctx, bucketName)
 	if err != nil {
-		return err
+		logging.Warn(ctx, "Reading the bucket's encryption and lifecycle failed", "bucket", bucketName, "error", err)
 	}
-	defer file.Close()
 
-	// In case this runs twice in the same day, empty the file before writing
-	if err := file.Truncate(0); err != nil {
-		return err
-	}
+	var plan backup.Plan
+	for _, server := range servers {
+		step, err := runner.PlanRefresh(server)
+		if err != nil {
+			return err
+		}
+		plan.Refresh = append(plan.Refresh, step)
 
-	args, err := server.DatabaseDumpCmd(database)
-	if err != nil {
-		return err
+		if onlyUpdate {
+			continue
+		}
+
+		databases, err := databaseService.List(server.Id)
+		if err != nil {
+			return err
+		}
+		for _, database := range databases {
+			if !database.Backup || !selector.MatchDatabase(server, database) {
+				continue
+			}
+
+			step, err := runner.PlanBackup(server, database, bucket)
+			if err != nil {
+				return err
+			}
+			plan.Backups = append(plan.Backups, step)
+		}
 	}
 
-	cmd := exec.Command(args[0], args[1:]...)
-	cmd.Stdout = file
-	if err := cmd.Run(); err != nil {
-		return err
+	if format == "json" {
+		return plan.WriteJSON(os.Stdout)
 	}
+	return plan.WriteTable(os.Stdout)
+}
 
+// stringList collects the values of a repeatable flag
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ", ")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
 	return nil
 }
 
-// Required environment variables:
-// AWS_ACCESS_KEY_ID
-// AWS_SECRET_ACCESS_KEY
-// AWS_REGION
-func sendToS3(bucket string, filename string, key string) error {
-	sess, err := session.NewSession()
+// logFlags holds the logging flags shared by the commands
+type logFlags struct {
+	format string
+	level  string
+}
+
+func addLogFlags(flags *flag.FlagSet) *logFlags {
+	l := &logFlags{format: logging.FormatText, level: "info"}
+	flags.StringVar(&l.format, "log-format", l.format, "Log format: text or json")
+	flags.StringVar(&l.level, "log-level", l.level, "Lowest level logged: debug, info, warn or error")
+	return l
+}
+
+// setup replaces the default logger with the configured one, tagging every
+// line with an ID unique to this run
+func (l *logFlags) setup() error {
+	level, err := logging.ParseLevel(l.level)
 	if err != nil {
 		return err
 	}
-
-	f, err := os.Open(filename)
+	logger, err := logging.New(os.Stderr, l.format, level)
 	if err != nil {
 		return err
 	}
-	defer f.Close()
+	logging.SetDefault(logger.With("run_id", logging.NewID()))
+	return nil
+}
 
-	input := &s3manager.UploadInput{
-		Bucket: aws.String(bucket),
-		Key:    aws.String(key),
-		Body:   f,
+// startTracing exports spans to target until the returned function is
+// called, which sends whatever is left
+func startTracing(target string, service string) (func(), error) {
+	exporter, err := tracing.NewExporter(target, service)
+	if err != nil {
+		return nil, err
 	}
+	provider := tracing.NewProvider(exporter, func(err error) {
+		logging.Default().Error("Exporting spans failed", "error", err)
+	})
+	tracing.SetProvider(provider)
 
-	uploader := s3manager.NewUploader(sess)
-	if _, err := uploader.Upload(input); err != nil {
-		return err
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := provider.Shutdown(ctx); err != nil {
+			logging.Default().Error("Exporting spans failed", "error", err)
+		}
+	}, nil
+}
+
+// Check for required environment variables
+func checkEnvironment() error {
+	envvars := []string{
+		"AWS_ACCESS_KEY_ID",
+		"AWS_SECRET_ACCESS_KEY",
+		"AWS_REGION",
+	}
+	for _, envvar := range envvars {
+		if os.Getenv(envvar) == "" {
+			return errors.New("environment variable, " + envvar + " is required")
+		}
 	}
 	return nil
 }
+
+// newNotifier sends events to the log, the configured webhooks and, for run
+// summaries, the servers' report recipients
+func newNotifier(storage repository.Storage) api.Notifier {
+	settingService := api.NewSettingService(storage)
+	// The mailer only lists servers, so its server service needs no notifier
+	// of its own
+	serverService := api.NewServerService(storage, settingService, notify.Log{})
+	return notify.Multi{
+		notify.Log{},
+		notify.NewWebhooks(api.NewWebhookService(storage)),
+		notify.NewMailer(settingService, serverService),
+	}
+}
+
+func openStorage(dsn string) (repository.Storage, error) {
+	storage, err := repository.Open(dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := storage.RunMigrations(); err != nil {
+		return nil, err
+	}
+
+	return storage, nil
+}
+
+// signalContext returns a context cancelled on SIGINT or SIGTERM
+func signalContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		select {
+		case sig := <-signals:
+			logging.Default().Warn("Stopping", "signal", sig)
+			cancel()
+		case <-ctx.Done():
+		}
+		signal.Stop(signals)
+	}()
+	return ctx, cancel
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jbaikge/database-backups/pkg/api"
+	"github.com/jbaikge/database-backups/pkg/repository"
+)
+
+var migrateCommands = map[string]func([]string) error{
+	"status": runMigrateStatus,
+	"up":     runMigrateUp,
+}
+
+// runMigrate dispatches to migrate status and up. Every other command
+// migrates the database when opening it; these open it as it is.
+func runMigrate(args []string) error {
+	if len(args) > 1 {
+		if command, ok := migrateCommands[args[1]]; ok {
+			return command(args[1:])
+		}
+	}
+	return fmt.Errorf("usage: %s migrate status|up [flags]", os.Args[0])
+}
+
+func runMigrateStatus(args []string) error {
+	storage, err := migrateStorage(args)
+	if err != nil {
+		return err
+	}
+
+	migrations, err := storage.ListMigrations()
+	if err != nil {
+		return err
+	}
+
+	pending := 0
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "VERSION\tDESCRIPTION\tSTATUS\tAPPLIED")
+	for _, migration := range migrations {
+		status, applied := migrationStatus(migration)
+		if migration.Applied == nil {
+			pending++
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\n", migration.Version, migration.Description, status, applied)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Printf("%d of %d migrations pending\n", pending, len(migrations))
+	return nil
+}
+
+func runMigrateUp(args []string) error {
+	storage, err := migrateStorage(args)
+	if err != nil {
+		return err
+	}
+
+	before, err := storage.ListMigrations()
+	if err != nil {
+		return err
+	}
+	if err := storage.RunMigrations(); err != nil {
+		return err
+	}
+	after, err := storage.ListMigrations()
+	if err != nil {
+		return err
+	}
+
+	for i, migration := range after {
+		if i < len(before) && before[i].Applied != nil {
+			continue
+		}
+		status, _ := migrationStatus(migration)
+		fmt.Printf("%d %s: %s\n", migration.Version, migration.Description, status)
+	}
+	if len(after) > 0 {
+		fmt.Printf("Schema at version %d\n", after[len(after)-1].Version)
+	}
+	return nil
+}
+
+// migrationStatus describes a migration for the status table. Baselined
+// migrations were found made on an install from before they were recorded.
+func migrationStatus(migration api.Migration) (status string, applied string) {
+	switch {
+	case migration.Applied == nil && migration.Baseline:
+		return "existing", ""
+	case migration.Applied == nil:
+		return "pending", ""
+	case migration.Baseline:
+		status = "baseline"
+	default:
+		status = "applied"
+	}
+	return status, migration.Applied.Local().Format(time.RFC3339)
+}
+
+func migrateStorage(args []string) (repository.Storage, error) {
+	databasePath := "/tmp/database-backups.sqlite3"
+
+	flags := flag.NewFlagSet(args[0], flag.ExitOnError)
+	flags.StringVar(&databasePath, "db", databasePath, "Configuration and logging database: a SQLite path or a postgres:// URL")
+	if err := flags.Parse(args[1:]); err != nil {
+		return nil, err
+	}
+
+	return repository.Open(databasePath)
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/jbaikge/database-backups/pkg/api"
+	"github.com/jbaikge/database-backups/pkg/backup"
+	"github.com/jbaikge/database-backups/pkg/logging"
+)
+
+func runRestore(args []string) error {
+	databasePath := "/tmp/database-backups.sqlite3"
+	dumpDir := "/tmp/dumps"
+	bucket := ""
+	serverName := ""
+	databaseName := ""
+	date := ""
+	key := ""
+	target := ""
+	table := ""
+	parallel := 4
+	until := ""
+	staleLock := 12 * time.Hour
+
+	flags := flag.NewFlagSet(args[0], flag.ExitOnError)
+	flags.StringVar(&databasePath, "db", databasePath, "Configuration and logging database: a SQLite path or a postgres:// URL")
+	flags.StringVar(&dumpDir, "dir", dumpDir, "Directory to download dumps to")
+	flags.StringVar(&bucket, "bucket", bucket, "AWS Bucket dumps are stored in")
+	flags.StringVar(&serverName, "server", serverName, "Server to restore to")
+	flags.StringVar(&databaseName, "database", databaseName, "Database to restore")
+	flags.StringVar(&date, "date", date, "Restore the dump taken on this date (YYYY-MM-DD) instead of the latest")
+	flags.StringVar(&key, "key", key, "Restore this S3 key instead of looking one up")
+	flags.StringVar(&target, "target", target, "Database to load the dump into, defaults to -database")
+	flags.StringVar(&table, "table", table, "Restore only this table from a per-table dump")
+	flags.IntVar(&parallel, "parallel", parallel, "Tables of a per-table dump to load at once")
+	flags.StringVar(&until, "until", until, "Replay archived binlogs up to this time (YYYY-MM-DD HH:MM:SS local, or RFC3339)")
+	flags.DurationVar(&staleLock, "stale-lock", staleLock, "Take over locks held on another host once older than this, left behind by a crashed run")
+	logOptions := addLogFlags(flags)
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+	if err := logOptions.setup(); err != nil {
+		return err
+	}
+
+	if bucket == "" {
+		return errors.New("bucket name is empty")
+	}
+	if serverName == "" || databaseName == "" {
+		return errors.New("-server and -database are required")
+	}
+	if target == "" {
+		target = databaseName
+	}
+
+	var untilTime time.Time
+	if until != "" {
+		var err error
+		if untilTime, err = parseTime(until); err != nil {
+			return err
+		}
+		// Start from that day's dump unless told otherwise
+		if date == "" && key == "" {
+			date = untilTime.Format("2006-01-02")
+		}
+	}
+
+	if err := checkEnvironment(); err != nil {
+		return err
+	}
+
+	storage, err := openStorage(databasePath)
+	if err != nil {
+		return err
+	}
+
+	server, database, err := findDatabase(api.NewServerService(storage, api.NewSettingService(storage), newNotifier(storage)), api.NewDatabaseService(storage), serverName, databaseName)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := signalContext()
+	defer cancel()
+
+	runner := backup.NewRunner(
+		api.NewLogService(storage),
+		api.NewLockService(storage, 0),
+		api.NewSettingService(storage),
+		newNotifier(storage),
+		dumpDir,
+		bucket,
+	)
+
+	if key == "" {
+		if key, err = runner.FindKey(ctx, *server, *database, date); err != nil {
+			return err
+		}
+	}
+
+	// Check the binlogs are all there before spending time on the dump
+	var replay *backup.Replay
+	archiver := backup.NewArchiver(
+		api.NewBinlogService(storage),
+		api.NewLockService(storage, staleLock),
+		dumpDir,
+		bucket,
+	)
+	if !untilTime.IsZero() {
+		if replay, err = archiver.PlanReplay(ctx, *server, key, untilTime); err != nil {
+			return err
+		}
+		if !replay.Complete {
+			logging.Warn(ctx, "No archived binlog starts after the target time, changes still in the current binlog will not be replayed", "until", untilTime.Format(time.RFC3339))
+		}
+	}
+
+	options := backup.RestoreOptions{
+		Target:   target,
+		Table:    table,
+		Parallel: parallel,
+	}
+	if err := runner.Restore(ctx, *server, key, options); err != nil {
+		return err
+	}
+	logging.Info(ctx, "Restored", "bucket", bucket, "key", key, "target", target, "server", server.Name)
+
+	if replay != nil {
+		if err := archiver.Replay(ctx, replay, target); err != nil {
+			return err
+		}
+		logging.Info(ctx, "Replayed binlogs", "binlogs", len(replay.Binlogs), "target", target, "until", untilTime.Format(time.RFC3339))
+	}
+	return nil
+}
+
+func parseTime(value string) (time.Time, error) {
+	if t, err := time.ParseInLocation("2006-01-02 15:04:05", value, time.Local); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+func findDatabase(serverService api.ServerService, databaseService api.DatabaseService, serverName string, databaseName string) (*api.Server, *api.Database, error) {
+	servers, err := serverService.List()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, server := range servers {
+		if server.Name != serverName {
+			continue
+		}
+
+		databases, err := databaseService.List(server.Id)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, database := range databases {
+			if database.Name == databaseName {
+				return &server, &database, nil
+			}
+		}
+		return nil, nil, fmt.Errorf("database %s not found on %s", databaseName, serverName)
+	}
+
+	return nil, nil, fmt.Errorf("server %s not found", serverName)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+
+	"github.com/jbaikge/database-backups/pkg/api"
+	"github.com/jbaikge/database-backups/pkg/backup"
+	"github.com/jbaikge/database-backups/pkg/logging"
+)
+
+func runVerifyIntegrity(args []string) error {
+	databasePath := "/tmp/database-backups.sqlite3"
+	dumpDir := "/tmp/dumps"
+	bucket := ""
+	prefix := ""
+	sample := 5
+
+	flags := flag.NewFlagSet(args[0], flag.ExitOnError)
+	flags.StringVar(&databasePath, "db", databasePath, "Configuration and logging database: a SQLite path or a postgres:// URL")
+	flags.StringVar(&dumpDir, "dir", dumpDir, "Directory to download dumps to")
+	flags.StringVar(&bucket, "bucket", bucket, "AWS Bucket dumps are stored in")
+	flags.StringVar(&prefix, "prefix", prefix, "Only verify dumps under this key prefix, e.g. server/database/")
+	flags.IntVar(&sample, "sample", sample, "Number of dumps to verify, picked at random")
+	logOptions := addLogFlags(flags)
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+	if err := logOptions.setup(); err != nil {
+		return err
+	}
+
+	if bucket == "" {
+		return errors.New("bucket name is empty")
+	}
+
+	if err := checkEnvironment(); err != nil {
+		return err
+	}
+
+	storage, err := openStorage(databasePath)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := signalContext()
+	defer cancel()
+
+	runner := backup.NewRunner(
+		api.NewLogService(storage),
+		api.NewLockService(storage, 0),
+		api.NewSettingService(storage),
+		newNotifier(storage),
+		dumpDir,
+		bucket,
+	)
+
+	results, err := runner.VerifySample(ctx, prefix, sample)
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, result := range results {
+		if result.Err != nil {
+			logging.Error(ctx, "Verification failed", "key", result.Key, "error", result.Err)
+			failed++
+			continue
+		}
+		logging.Info(ctx, "Verified", "key", result.Key)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d dumps failed verification", failed, len(results))
+	}
+	logging.Info(ctx, "Verified dumps", "dumps", len(results))
+	return nil
+}
@@ -6,7 +6,9 @@ require (
 	github.com/aws/aws-sdk-go v1.42.23
 	github.com/gin-contrib/cors v1.3.1
 	github.com/gin-gonic/gin v1.7.7
+	github.com/lib/pq v1.10.9
 	github.com/mattn/go-sqlite3 v1.14.9
 	github.com/zeebo/assert v1.3.0
 	golang.org/x/crypto v0.0.0-20220112180741-5e0467b6c7ce
+	gopkg.in/yaml.v2 v2.2.8
 )
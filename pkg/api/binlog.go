@@ -0,0 +1,30 @@
+package api
+
+type BinlogService interface {
+	List(int) ([]Binlog, error)
+	New(Binlog) error
+}
+
+type BinlogRepository interface {
+	CreateBinlog(Binlog) error
+	ListBinlogs(int) ([]Binlog, error)
+}
+
+type binlogService struct {
+	storage BinlogRepository
+}
+
+func NewBinlogService(repo BinlogRepository) BinlogService {
+	return &binlogService{
+		storage: repo,
+	}
+}
+
+// List returns the server's archived binlogs in order
+func (s *binlogService) List(serverId int) ([]Binlog, error) {
+	return s.storage.ListBinlogs(serverId)
+}
+
+func (s *binlogService) New(binlog Binlog) error {
+	return s.storage.CreateBinlog(binlog)
+}
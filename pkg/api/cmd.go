@@ -5,55 +5,159 @@ import (
 	"strings"
 )
 
-func (s Server) DatabaseDumpCmd(d Database) ([]string, error) {
-	cmd := make([]string, 0, 32)
-	cmd = append(cmd, "mysqldump", "--single-transaction")
+// BinlogDumpCmd copies a binlog file from the server into dir unchanged.
+// mysqlbinlog writes the file itself rather than to standard output, so this
+// cannot run through the ssh proxy.
+func (s Server) BinlogDumpCmd(name string, dir string) ([]string, error) {
+	if s.ProxyHost != "" {
+		return nil, fmt.Errorf("%s: binlogs cannot be copied through an ssh proxy", s.Name)
+	}
+
+	cmd := []string{
+		"mysqlbinlog",
+		"--read-from-remote-server",
+		"--raw",
+		"--result-file=" + strings.TrimSuffix(dir, "/") + "/",
+		name,
+	}
+	return s.addAuth(cmd)
+}
+
+// BinlogListCmd lists the server's binlog files, oldest first. The last one
+// is still being written to.
+func (s Server) BinlogListCmd() ([]string, error) {
+	return s.wrapCmd([]string{"mysql", "--skip-column-names", "--batch", "--execute", "SHOW BINARY LOGS"})
+}
 
-	if d.ExcludeTables != "" {
-		for _, table := range strings.Fields(d.ExcludeTables) {
-			cmd = append(cmd, "--ignore-table", d.Name+"."+table)
+// DatabaseDumpCmds returns the commands whose output makes up a single dump:
+// a full dump of the database, one dump per table limited by a WHERE
+// predicate, then a --no-data dump of its schema-only tables. The full and
+// schema dumps are left out when they would have no tables.
+func (s Server) DatabaseDumpCmds(d Database) ([][]string, error) {
+	cmds := make([][]string, 0, 2)
+
+	if tables, ok := d.dumpTables(func(rule TableRule) bool {
+		return rule.Mode == TableModeFull && rule.Where == ""
+	}); ok {
+		cmd := []string{"mysqldump", "--single-transaction"}
+
+		// Commented out CHANGE MASTER / CHANGE REPLICATION SOURCE statement at
+		// the top of the dump gives the position binlog replay starts from
+		if s.Binlog != "" {
+			cmd = append(cmd, "--"+s.Binlog+"=2")
+		}
+
+		cmd, err := s.wrapCmd(append(cmd, tables...))
+		if err != nil {
+			return nil, err
 		}
+		cmds = append(cmds, cmd)
 	}
 
-	cmd = append(cmd, d.Name)
+	for _, rule := range d.filteredTables() {
+		cmd, err := s.TableDumpCmd(d, rule.Table)
+		if err != nil {
+			return nil, err
+		}
+		cmds = append(cmds, cmd)
+	}
 
-	if d.OnlyTables != "" {
-		cmd = append(cmd, strings.Fields(d.OnlyTables)...)
+	if tables, ok := d.dumpTables(func(rule TableRule) bool {
+		return rule.Mode == TableModeSchema
+	}); ok {
+		cmd, err := s.wrapCmd(append([]string{"mysqldump", "--single-transaction", "--no-data"}, tables...))
+		if err != nil {
+			return nil, err
+		}
+		cmds = append(cmds, cmd)
 	}
 
-	return s.wrapCmd(cmd)
+	if len(cmds) == 0 {
+		return nil, fmt.Errorf("%s: every table is skipped", d.Name)
+	}
+	return cmds, nil
 }
 
 func (s Server) DatabaseListCmd() ([]string, error) {
-	ignoreTables := []string{
-		"innodb",
+	cmd := []string{
 		"mysql",
-		"information_schema",
-		"performance_schema",
-		"sys",
-		"tmp",
-	}
-	for i := range ignoreTables {
-		ignoreTables[i] = "'" + ignoreTables[i] + "'"
+		"--skip-column-names",
+		"--batch",
+		"--execute",
+		"SHOW DATABASES",
 	}
+	return s.wrapCmd(cmd)
+}
 
+// DatabaseTablesCmd lists the database's tables, one per line with the
+// table name and estimated row count separated by a tab
+func (s Server) DatabaseTablesCmd(d Database) ([]string, error) {
 	cmd := []string{
 		"mysql",
 		"--skip-column-names",
 		"--batch",
 		"--execute",
 		fmt.Sprintf(
-			"SHOW DATABASES WHERE `Database` NOT IN(%s)",
-			strings.Join(ignoreTables, ", "),
+			"SELECT TABLE_NAME, IFNULL(TABLE_ROWS, 0) FROM information_schema.TABLES WHERE TABLE_SCHEMA = %s AND TABLE_TYPE = 'BASE TABLE' ORDER BY TABLE_NAME",
+			quoteLiteral(d.Name),
 		),
 	}
 	return s.wrapCmd(cmd)
 }
 
+// DumpVersionCmd prints the version of mysqldump used for dumps
+func (s Server) DumpVersionCmd() ([]string, error) {
+	return s.addProxy([]string{"mysqldump", "--version"})
+}
+
+// Redacted returns a copy of the server whose commands carry a placeholder in
+// place of the password. The password is not decrypted, so commands can be
+// shown without DATABASE_BACKUP_KEY.
+func (s Server) Redacted() Server {
+	s.redact = true
+	return s
+}
+
+// RestoreCmd loads a dump from standard input into the named database
+func (s Server) RestoreCmd(name string) ([]string, error) {
+	return s.wrapCmd([]string{"mysql", name})
+}
+
+// SchemaDumpCmd dumps the definitions of every table not skipped without any
+// rows, for per-table dumps
+func (s Server) SchemaDumpCmd(d Database) ([]string, error) {
+	tables, ok := d.dumpTables(func(rule TableRule) bool {
+		return rule.Mode != TableModeSkip
+	})
+	if !ok {
+		return nil, fmt.Errorf("%s: every table is skipped", d.Name)
+	}
+	cmd := []string{"mysqldump", "--single-transaction", "--no-data"}
+	return s.wrapCmd(append(cmd, tables...))
+}
+
+// ServerVersionCmd prints the MySQL server version
+func (s Server) ServerVersionCmd() ([]string, error) {
+	return s.wrapCmd([]string{"mysql", "--skip-column-names", "--batch", "--execute", "SELECT VERSION()"})
+}
+
+// TableDumpCmd dumps one table, including its definition so it can be
+// restored on its own, limited to the rows matching its WHERE predicate if
+// it has one
+func (s Server) TableDumpCmd(d Database, table string) ([]string, error) {
+	cmd := []string{"mysqldump", "--single-transaction"}
+	if rule, ok := d.TableRuleFor(table); ok && rule.Where != "" {
+		cmd = append(cmd, "--where="+rule.Where)
+	}
+	return s.wrapCmd(append(cmd, d.Name, table))
+}
+
 func (s Server) addAuth(cmd []string) ([]string, error) {
 	parts := make([]string, 0, len(cmd)+8) // 8 is arbitrary, could be 5
 	parts = append(parts, cmd[0], "--host", s.Host, "--port", fmt.Sprint(s.Port), "--user", s.Username)
-	if s.Password != "" {
+	if s.Password != "" && s.redact {
+		parts = append(parts, "--password=****")
+	} else if s.Password != "" {
 		password, err := s.DecryptPassword()
 		if err != nil {
 			return nil, err
@@ -72,9 +176,13 @@ func (s Server) addProxy(cmd []string) ([]string, error) {
 	userHost := fmt.Sprintf("%s@%s", s.ProxyUsername, s.ProxyHost)
 	proxy := []string{"ssh", "-i", s.ProxyIdentity, userHost}
 
+	// ssh hands the command to a shell on the far side, so anything the
+	// shell would interpret needs quoting
 	parts := make([]string, 0, len(proxy)+len(cmd))
 	parts = append(parts, proxy...)
-	parts = append(parts, cmd...)
+	for _, arg := range cmd {
+		parts = append(parts, ShellQuote(arg))
+	}
 	return parts, nil
 }
 
@@ -91,3 +199,18 @@ func (s Server) wrapCmd(cmd []string) ([]string, error) {
 
 	return cmd, nil
 }
+
+// ShellQuote wraps arg in single quotes if a POSIX shell would otherwise
+// interpret any part of it
+func ShellQuote(arg string) string {
+	if arg != "" && !strings.ContainsAny(arg, " \t\n'\"`$\\*?;&|<>()[]{}~#!") {
+		return arg
+	}
+	return "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+}
+
+func quoteLiteral(value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, "'", "''")
+	return "'" + value + "'"
+}
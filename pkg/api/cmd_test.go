@@ -0,0 +1,48 @@
+package api_test
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/jbaikge/database-backups/pkg/api"
+	"github.com/zeebo/assert"
+)
+
+// remoteArgs splits a proxied command's remote part the way the shell ssh
+// hands it to on the far side does
+func remoteArgs(t *testing.T, args []string) []string {
+	out, err := exec.Command("sh", "-c", "printf '%s\\0' "+strings.Join(args, " ")).Output()
+	assert.NoError(t, err)
+	return strings.Split(strings.TrimSuffix(string(out), "\x00"), "\x00")
+}
+
+// Unquoted, the SQL and WHERE predicates below are split on spaces and their
+// quotes and parentheses taken by the remote shell, which either fails or
+// runs something else
+func TestProxyQuoting(t *testing.T) {
+	direct := api.Server{Host: "db1", Port: 3306, Username: "backup"}
+	proxied := direct
+	proxied.ProxyHost, proxied.ProxyUsername, proxied.ProxyIdentity = "bastion", "ops", "/keys/id"
+	database := api.Database{
+		Name:   "shop's",
+		Tables: []api.TableRule{{Table: "events", Mode: api.TableModeFull, Where: "created_at >= '2022-01-01' AND (id & 1) = 0"}},
+	}
+
+	builds := []func(api.Server) ([]string, error){
+		func(s api.Server) ([]string, error) { return s.DatabaseListCmd() },
+		func(s api.Server) ([]string, error) { return s.DatabaseTablesCmd(database) },
+		func(s api.Server) ([]string, error) { return s.TableDumpCmd(database, "events") },
+		func(s api.Server) ([]string, error) { return s.RestoreCmd("shop's") },
+	}
+
+	for _, build := range builds {
+		local, err := build(direct)
+		assert.NoError(t, err)
+		remote, err := build(proxied)
+		assert.NoError(t, err)
+
+		assert.DeepEqual(t, remote[:4], []string{"ssh", "-i", "/keys/id", "ops@bastion"})
+		assert.DeepEqual(t, remoteArgs(t, remote[4:]), local)
+	}
+}
@@ -1,9 +1,16 @@
 package api
 
+import (
+	"fmt"
+
+	"github.com/jbaikge/database-backups/pkg/cron"
+)
+
 type DatabaseService interface {
 	Delete(int) error
 	Get(int) (*Database, error)
 	List(int) ([]Database, error)
+	ListPending() ([]Database, error)
 	New(NewDatabaseRequest) error
 	Update(int, UpdateDatabaseRequest) error
 }
@@ -13,6 +20,7 @@ type DatabaseRepository interface {
 	DeleteDatabase(int) error
 	GetDatabase(int) (*Database, error)
 	ListDatabases(int) ([]Database, error)
+	ListPendingDatabases() ([]Database, error)
 	UpdateDatabase(int, UpdateDatabaseRequest) error
 }
 
@@ -38,10 +46,49 @@ func (s *databaseService) List(serverId int) ([]Database, error) {
 	return s.storage.ListDatabases(serverId)
 }
 
+// ListPending returns the databases found by a refresh that are waiting for
+// someone to decide whether to back them up
+func (s *databaseService) ListPending() ([]Database, error) {
+	return s.storage.ListPendingDatabases()
+}
+
 func (s *databaseService) New(database NewDatabaseRequest) error {
 	return s.storage.CreateDatabase(database)
 }
 
 func (s *databaseService) Update(id int, database UpdateDatabaseRequest) error {
+	if database.Schedule != "" {
+		if _, err := cron.Parse(database.Schedule); err != nil {
+			return err
+		}
+	}
+
+	if database.Timeout != "" {
+		if err := validateDuration(database.Timeout); err != nil {
+			return fmt.Errorf("timeout: %s", err)
+		}
+	}
+
+	if database.MaxAge != "" {
+		if err := validateDuration(database.MaxAge); err != nil {
+			return fmt.Errorf("max_age: %s", err)
+		}
+	}
+
+	switch database.DumpMode {
+	case "":
+		database.DumpMode = DumpModeSingle
+	case DumpModeSingle, DumpModePerTable:
+	default:
+		return fmt.Errorf("dump_mode must be %s or %s", DumpModeSingle, DumpModePerTable)
+	}
+
+	if database.TableMode == "" {
+		database.TableMode = TableModeFull
+	}
+	if err := validateTableRules(database.TableMode, database.Tables); err != nil {
+		return err
+	}
+
 	return s.storage.UpdateDatabase(id, database)
 }
@@ -2,22 +2,137 @@ package api
 
 import "time"
 
+type Attempt struct {
+	Id       int       `json:"id"`
+	LogId    *int      `json:"log_id"`
+	ServerId int       `json:"server_id"`
+	Step     string    `json:"step"`
+	Number   int       `json:"number"`
+	Started  time.Time `json:"started"`
+	Ended    time.Time `json:"ended"`
+	Error    string    `json:"error"`
+}
+
+const (
+	AttemptStepRefresh = "refresh"
+	AttemptStepDump    = "dump"
+	AttemptStepUpload  = "upload"
+)
+
+// Binlog is a closed binary log file shipped to S3 by the archiver
+type Binlog struct {
+	Id       int       `json:"id"`
+	ServerId int       `json:"server_id"`
+	Name     string    `json:"name"`
+	Key      string    `json:"key"`
+	Size     int64     `json:"size"`
+	SHA256   string    `json:"sha256"`
+	Started  time.Time `json:"started"`
+	Added    time.Time `json:"added"`
+}
+
+// mysqldump options recording the binlog position in a dump. MySQL 8.0.26
+// renamed master-data to source-data.
+const (
+	BinlogOptionMasterData = "master-data"
+	BinlogOptionSourceData = "source-data"
+)
+
+// BackupStats sums up a database's backup history for monitoring
+type BackupStats struct {
+	Server   string `json:"server"`
+	Database string `json:"database"`
+	// Nil until the first successful backup
+	LastSuccess  *time.Time    `json:"last_success"`
+	LastDuration time.Duration `json:"last_duration"`
+	LastSize     int64         `json:"last_size"`
+	// Backups that failed or timed out
+	Failures int `json:"failures"`
+	// The database's own max_age, empty for the setting
+	MaxAge string    `json:"max_age"`
+	Added  time.Time `json:"added"`
+}
+
 type Database struct {
-	Id            int        `json:"id"`
-	ServerId      int        `json:"server_id"`
-	Backup        bool       `json:"backup"`
-	Name          string     `json:"name"`
-	OnlyTables    string     `json:"only_tables"`
-	ExcludeTables string     `json:"exclude_tables"`
-	Added         time.Time  `json:"added"`
-	Removed       *time.Time `json:"removed"`
+	Id        int         `json:"id"`
+	ServerId  int         `json:"server_id"`
+	Backup    bool        `json:"backup"`
+	Name      string      `json:"name"`
+	Schedule  string      `json:"schedule"`
+	Tags      string      `json:"tags"`
+	Timeout   string      `json:"timeout"`
+	MaxAge    string      `json:"max_age"`
+	DumpMode  string      `json:"dump_mode"`
+	TableMode string      `json:"table_mode"`
+	Tables    []TableRule `json:"tables"`
+	// Found by a refresh and waiting for someone to decide on backups
+	Pending bool       `json:"pending"`
+	Added   time.Time  `json:"added"`
+	Removed *time.Time `json:"removed"`
+}
+
+// A single dump holds the whole database. Per-table dumps store a schema-only
+// dump and one dump per table so tables can be restored on their own.
+const (
+	DumpModeSingle   = "single"
+	DumpModePerTable = "per-table"
+)
+
+type Lock struct {
+	Name     string    `json:"name"`
+	Pid      int       `json:"pid"`
+	Hostname string    `json:"hostname"`
+	Acquired time.Time `json:"acquired"`
+}
+
+type Log struct {
+	Id           int        `json:"id"`
+	DatabaseId   int        `json:"database_id"`
+	BackupStart  *time.Time `json:"backup_start"`
+	BackupEnd    *time.Time `json:"backup_end"`
+	SizePrevious int64      `json:"size_previous"`
+	SizeCurrent  int64      `json:"size_current"`
+	Status       string     `json:"status"`
+	Error        string     `json:"error"`
+	Warning      string     `json:"warning"`
+	Added        time.Time  `json:"added"`
+}
+
+const (
+	LogStatusRunning   = "running"
+	LogStatusSuccess   = "success"
+	LogStatusFailed    = "failed"
+	LogStatusTimeout   = "timeout"
+	LogStatusCancelled = "cancelled"
+)
+
+// Migration is one numbered step of the storage schema. Applied is nil while
+// it is pending; Baseline marks steps found already made on an install from
+// before migrations were recorded, rather than run.
+type Migration struct {
+	Version     int        `json:"version"`
+	Description string     `json:"description"`
+	Applied     *time.Time `json:"applied"`
+	Baseline    bool       `json:"baseline"`
 }
 
 type NewDatabaseRequest struct {
 	ServerId int    `json:"server_id"`
 	Name     string `json:"name"`
+	Backup   bool   `json:"backup"`
+	Pending  bool   `json:"pending"`
 }
 
+// Policies deciding whether a newly found database is backed up. Review adds
+// every new database with backups off for someone to look at; pattern backs
+// up those matching the server's include patterns and holds back the rest.
+// Excluded databases are always added with backups off.
+const (
+	NewDatabasePolicyEnable  = "enable"
+	NewDatabasePolicyReview  = "review"
+	NewDatabasePolicyPattern = "pattern"
+)
+
 type NewServerRequest struct {
 	Name          string `json:"name"`
 	Host          string `json:"host"`
@@ -27,6 +142,24 @@ type NewServerRequest struct {
 	ProxyHost     string `json:"proxy_host"`
 	ProxyUsername string `json:"proxy_username"`
 	ProxyIdentity string `json:"proxy_identity"`
+	Schedule      string `json:"schedule"`
+	Tags          string `json:"tags"`
+	Binlog        string `json:"binlog"`
+	// Space-separated database patterns, see Server.ExcludesDatabase
+	DatabaseInclude   string `json:"database_include"`
+	DatabaseExclude   string `json:"database_exclude"`
+	NewDatabasePolicy string `json:"new_database_policy"`
+	ReportRecipients  string `json:"report_recipients"`
+}
+
+type ScheduleEntry struct {
+	ServerId   int        `json:"server_id"`
+	DatabaseId int        `json:"database_id"`
+	Server     string     `json:"server"`
+	Database   string     `json:"database"`
+	Schedule   string     `json:"schedule"`
+	LastRun    *time.Time `json:"last_run"`
+	NextRun    time.Time  `json:"next_run"`
 }
 
 type Server struct {
@@ -39,17 +172,89 @@ type Server struct {
 	ProxyHost     string `json:"proxy_host"`
 	ProxyUsername string `json:"proxy_username"`
 	ProxyIdentity string `json:"proxy_identity"`
+	Schedule      string `json:"schedule"`
+	Tags          string `json:"tags"`
+	// mysqldump option used to record the binlog position, empty when binlogs
+	// are not archived
+	Binlog string `json:"binlog"`
+	// Space-separated patterns overriding the database_exclude setting and
+	// adding to it for this server
+	DatabaseInclude string `json:"database_include"`
+	DatabaseExclude string `json:"database_exclude"`
+	// How newly found databases are treated, see NewDatabasePolicyEnable
+	NewDatabasePolicy string `json:"new_database_policy"`
+	// Comma-separated addresses emailed a report after each run
+	ReportRecipients string `json:"report_recipients"`
+
+	// Set on copies returned by Redacted
+	redact bool
+}
+
+type Setting struct {
+	Key     string `json:"key"`
+	Value   string `json:"value"`
+	Default string `json:"default"`
+}
+
+// TableRule sets how the tables matching a pattern are backed up. The first
+// matching rule applies; tables without one use the database's TableMode.
+type TableRule struct {
+	Table string `json:"table"`
+	Mode  string `json:"mode"`
+	// Predicate limiting the rows of full tables, see ExpandWhere
+	Where string `json:"where"`
 }
 
+const (
+	TableModeFull   = "full"
+	TableModeSchema = "schema"
+	TableModeSkip   = "skip"
+)
+
 type Tree struct {
 	Server    Server     `json:"server"`
 	Databases []Database `json:"databases"`
+	// Databases waiting for review
+	Pending int `json:"pending"`
 }
 
 type UpdateDatabaseRequest struct {
-	ServerId      int    `json:"server_id"`
-	Name          string `json:"name"`
-	Backup        bool   `json:"backup"`
-	OnlyTables    string `json:"only_tables"`
-	ExcludeTables string `json:"exclude_tables"`
+	ServerId  int         `json:"server_id"`
+	Name      string      `json:"name"`
+	Backup    bool        `json:"backup"`
+	Schedule  string      `json:"schedule"`
+	Tags      string      `json:"tags"`
+	Timeout   string      `json:"timeout"`
+	MaxAge    string      `json:"max_age"`
+	DumpMode  string      `json:"dump_mode"`
+	TableMode string      `json:"table_mode"`
+	Tables    []TableRule `json:"tables"`
+}
+
+// Webhook is posted to when an event it wants is raised
+type Webhook struct {
+	Id     int    `json:"id"`
+	Name   string `json:"name"`
+	URL    string `json:"url"`
+	Format string `json:"format"`
+	// Space-separated event types, empty for every event
+	Events string `json:"events"`
+	// Space-separated server name patterns, empty for every server
+	Servers string    `json:"servers"`
+	Added   time.Time `json:"added"`
+}
+
+// Payloads a webhook can be sent
+const (
+	WebhookFormatJSON  = "json"
+	WebhookFormatSlack = "slack"
+	WebhookFormatTeams = "teams"
+)
+
+type NewWebhookRequest struct {
+	Name    string `json:"name"`
+	URL     string `json:"url"`
+	Format  string `json:"format"`
+	Events  string `json:"events"`
+	Servers string `json:"servers"`
 }
@@ -0,0 +1,44 @@
+package api
+
+import (
+	"context"
+	"time"
+)
+
+const (
+	EventBackupFailed    = "backup_failed"
+	EventDatabaseAdded   = "database_added"
+	EventDatabaseRemoved = "database_removed"
+	EventRunSummary      = "run_summary"
+	EventSizeDrop        = "size_drop"
+	EventTest            = "test"
+)
+
+var eventTypes = []string{
+	EventBackupFailed,
+	EventDatabaseAdded,
+	EventDatabaseRemoved,
+	EventRunSummary,
+	EventSizeDrop,
+	EventTest,
+}
+
+// Event is something worth telling people about. Server and Database are
+// empty for events covering a whole run.
+type Event struct {
+	Type     string    `json:"type"`
+	Server   string    `json:"server"`
+	Database string    `json:"database"`
+	Message  string    `json:"message"`
+	Time     time.Time `json:"time"`
+	// Extra details, such as counts in a run summary
+	Fields map[string]string `json:"fields,omitempty"`
+	// How each database fared, for run summaries
+	Results []BackupResult `json:"results,omitempty"`
+}
+
+// Notifier delivers events. Failures are logged by the caller rather than
+// failing whatever raised the event.
+type Notifier interface {
+	Notify(context.Context, Event) error
+}
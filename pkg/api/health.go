@@ -0,0 +1,64 @@
+package api
+
+import (
+	"time"
+)
+
+// BackupHealth lists the databases whose last successful backup is older
+// than their max_age
+type BackupHealth struct {
+	Checked int           `json:"checked"`
+	Stale   []StaleBackup `json:"stale"`
+}
+
+type StaleBackup struct {
+	Server   string `json:"server"`
+	Database string `json:"database"`
+	// Nil when the database has never been backed up
+	LastSuccess *time.Time    `json:"last_success"`
+	Age         time.Duration `json:"age"`
+	MaxAge      time.Duration `json:"max_age"`
+}
+
+func (h BackupHealth) OK() bool {
+	return len(h.Stale) == 0
+}
+
+// CheckBackups compares every database's last successful backup with its
+// max_age, falling back to defaultMaxAge. Databases never backed up are aged
+// from when they were added, so new ones get a chance to run first. A max
+// age of zero disables the check for that database.
+func CheckBackups(stats []BackupStats, defaultMaxAge time.Duration, now time.Time) BackupHealth {
+	health := BackupHealth{
+		Checked: len(stats),
+		Stale:   make([]StaleBackup, 0, len(stats)),
+	}
+	for _, stat := range stats {
+		maxAge := defaultMaxAge
+		if stat.MaxAge != "" {
+			// Validated when saved
+			maxAge, _ = time.ParseDuration(stat.MaxAge)
+		}
+		if maxAge == 0 {
+			continue
+		}
+
+		since := stat.Added
+		if stat.LastSuccess != nil {
+			since = *stat.LastSuccess
+		}
+		age := now.Sub(since)
+		if age <= maxAge {
+			continue
+		}
+
+		health.Stale = append(health.Stale, StaleBackup{
+			Server:      stat.Server,
+			Database:    stat.Database,
+			LastSuccess: stat.LastSuccess,
+			Age:         age,
+			MaxAge:      maxAge,
+		})
+	}
+	return health
+}
@@ -0,0 +1,42 @@
+package api_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jbaikge/database-backups/pkg/api"
+	"github.com/zeebo/assert"
+)
+
+func TestCheckBackups(t *testing.T) {
+	now := time.Date(2021, 6, 10, 12, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) *time.Time {
+		t := now.Add(-d)
+		return &t
+	}
+	added := now.Add(-30 * 24 * time.Hour)
+
+	stats := []api.BackupStats{
+		{Server: "db1", Database: "fresh", LastSuccess: at(2 * time.Hour), Added: added},
+		{Server: "db1", Database: "old", LastSuccess: at(48 * time.Hour), Added: added},
+		{Server: "db1", Database: "weekly", LastSuccess: at(48 * time.Hour), MaxAge: "192h", Added: added},
+		{Server: "db2", Database: "never", Added: added},
+		{Server: "db2", Database: "new", Added: now.Add(-time.Hour)},
+		{Server: "db2", Database: "unchecked", MaxAge: "0s", Added: added},
+	}
+
+	health := api.CheckBackups(stats, 36*time.Hour, now)
+	assert.Equal(t, health.Checked, 6)
+	assert.False(t, health.OK())
+	assert.Equal(t, len(health.Stale), 2)
+
+	assert.Equal(t, health.Stale[0].Database, "old")
+	assert.Equal(t, health.Stale[0].Age, 48*time.Hour)
+	assert.Equal(t, health.Stale[0].MaxAge, 36*time.Hour)
+
+	assert.Equal(t, health.Stale[1].Database, "never")
+	assert.Nil(t, health.Stale[1].LastSuccess)
+	assert.Equal(t, health.Stale[1].Age, 30*24*time.Hour)
+
+	assert.That(t, api.CheckBackups(stats[:1], 36*time.Hour, now).OK())
+}
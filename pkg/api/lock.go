@@ -0,0 +1,118 @@
+package api
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+)
+
+// LockError is returned when another process already holds a lock
+type LockError struct {
+	Name     string
+	Pid      int
+	Hostname string
+	Since    time.Time
+}
+
+func (e *LockError) Error() string {
+	return fmt.Sprintf(
+		"%s is locked by PID %d on %s since %s",
+		e.Name,
+		e.Pid,
+		e.Hostname,
+		e.Since.Format(time.RFC3339),
+	)
+}
+
+type LockService interface {
+	// Acquire takes the named lock. hold is the longest the caller can keep
+	// it, zero when unbounded.
+	Acquire(name string, hold time.Duration) (*Lock, error)
+	Release(*Lock) error
+}
+
+type LockRepository interface {
+	CreateLock(Lock) error
+	DeleteLock(Lock) error
+	DeleteStaleLock(string, time.Time) error
+	GetLock(string) (*Lock, error)
+}
+
+type lockService struct {
+	storage    LockRepository
+	staleAfter time.Duration
+}
+
+// NewLockService returns a service handing out named locks stored in the
+// repository. A lock held on this host is taken over once its process is
+// gone. One held on another host, where that cannot be checked, is assumed to
+// belong to a process that died without cleaning up once it is older than
+// staleAfter and the hold it was acquired with.
+func NewLockService(repo LockRepository, staleAfter time.Duration) LockService {
+	return &lockService{
+		storage:    repo,
+		staleAfter: staleAfter,
+	}
+}
+
+func (s *lockService) Acquire(name string, hold time.Duration) (*Lock, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+
+	holder, err := s.storage.GetLock(name)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case holder == nil:
+	case holder.Hostname == hostname:
+		// Whether the holder is still running can be checked here, so its age
+		// does not matter
+		if !processAlive(holder.Pid) {
+			if err := s.storage.DeleteLock(*holder); err != nil {
+				return nil, err
+			}
+		}
+	default:
+		staleAfter := s.staleAfter
+		if hold > staleAfter {
+			staleAfter = hold
+		}
+		if err := s.storage.DeleteStaleLock(name, time.Now().Add(-staleAfter)); err != nil {
+			return nil, err
+		}
+	}
+
+	lock := &Lock{
+		Name:     name,
+		Pid:      os.Getpid(),
+		Hostname: hostname,
+		Acquired: time.Now(),
+	}
+	if err := s.storage.CreateLock(*lock); err != nil {
+		holder, getErr := s.storage.GetLock(name)
+		if getErr != nil || holder == nil {
+			return nil, err
+		}
+		return nil, &LockError{
+			Name:     name,
+			Pid:      holder.Pid,
+			Hostname: holder.Hostname,
+			Since:    holder.Acquired,
+		}
+	}
+
+	return lock, nil
+}
+
+func (s *lockService) Release(lock *Lock) error {
+	return s.storage.DeleteLock(*lock)
+}
+
+func processAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
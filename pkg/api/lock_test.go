@@ -0,0 +1,75 @@
+package api_test
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jbaikge/database-backups/pkg/api"
+	"github.com/zeebo/assert"
+)
+
+// lockRepository keeps locks in memory
+type lockRepository map[string]api.Lock
+
+func (r lockRepository) CreateLock(lock api.Lock) error {
+	if _, ok := r[lock.Name]; ok {
+		return os.ErrExist
+	}
+	r[lock.Name] = lock
+	return nil
+}
+
+func (r lockRepository) DeleteLock(lock api.Lock) error {
+	delete(r, lock.Name)
+	return nil
+}
+
+func (r lockRepository) DeleteStaleLock(name string, before time.Time) error {
+	if lock, ok := r[name]; ok && lock.Acquired.Before(before) {
+		delete(r, name)
+	}
+	return nil
+}
+
+func (r lockRepository) GetLock(name string) (*api.Lock, error) {
+	lock, ok := r[name]
+	if !ok {
+		return nil, nil
+	}
+	return &lock, nil
+}
+
+func TestLockTakeover(t *testing.T) {
+	hostname, err := os.Hostname()
+	assert.NoError(t, err)
+	old := time.Now().Add(-24 * time.Hour)
+
+	tests := []struct {
+		holder   api.Lock
+		hold     time.Duration
+		acquired bool
+	}{
+		// Still running here, however old
+		{api.Lock{Hostname: hostname, Pid: os.Getpid(), Acquired: old}, 0, false},
+		// Gone from here, however recent
+		{api.Lock{Hostname: hostname, Pid: 1 << 30, Acquired: time.Now()}, 0, true},
+		{api.Lock{Hostname: "elsewhere", Pid: 1, Acquired: time.Now()}, 0, false},
+		{api.Lock{Hostname: "elsewhere", Pid: 1, Acquired: old}, 0, true},
+		// The dump may still be running within its timeout
+		{api.Lock{Hostname: "elsewhere", Pid: 1, Acquired: old}, 48 * time.Hour, false},
+	}
+
+	for _, test := range tests {
+		test.holder.Name = "db1/shop"
+		repo := lockRepository{test.holder.Name: test.holder}
+		lock, err := api.NewLockService(repo, 12*time.Hour).Acquire("db1/shop", test.hold)
+		if test.acquired {
+			assert.NoError(t, err)
+			assert.Equal(t, lock.Pid, os.Getpid())
+		} else {
+			_, ok := err.(*api.LockError)
+			assert.That(t, ok)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package api
+
+type LogService interface {
+	Last(int) (*Log, error)
+	LastSuccessful(int) (*Log, error)
+	List(int) ([]Log, error)
+	ListAttempts(int) ([]Attempt, error)
+	New(Log) (int, error)
+	NewAttempt(Attempt) error
+	Stats() ([]BackupStats, error)
+	Update(int, Log) error
+}
+
+type LogRepository interface {
+	CreateAttempt(Attempt) error
+	CreateLog(Log) (int, error)
+	GetLastLog(int) (*Log, error)
+	GetLastSuccessfulLog(int) (*Log, error)
+	ListAttempts(int) ([]Attempt, error)
+	ListBackupStats() ([]BackupStats, error)
+	ListLogs(int) ([]Log, error)
+	UpdateLog(int, Log) error
+}
+
+type logService struct {
+	storage LogRepository
+}
+
+func NewLogService(repo LogRepository) LogService {
+	return &logService{
+		storage: repo,
+	}
+}
+
+func (s *logService) Last(databaseId int) (*Log, error) {
+	return s.storage.GetLastLog(databaseId)
+}
+
+func (s *logService) LastSuccessful(databaseId int) (*Log, error) {
+	return s.storage.GetLastSuccessfulLog(databaseId)
+}
+
+func (s *logService) List(databaseId int) ([]Log, error) {
+	return s.storage.ListLogs(databaseId)
+}
+
+func (s *logService) ListAttempts(logId int) ([]Attempt, error) {
+	return s.storage.ListAttempts(logId)
+}
+
+func (s *logService) New(log Log) (int, error) {
+	return s.storage.CreateLog(log)
+}
+
+func (s *logService) NewAttempt(attempt Attempt) error {
+	return s.storage.CreateAttempt(attempt)
+}
+
+// Stats returns the backup history of every database being backed up
+func (s *logService) Stats() ([]BackupStats, error) {
+	return s.storage.ListBackupStats()
+}
+
+func (s *logService) Update(id int, log Log) error {
+	return s.storage.UpdateLog(id, log)
+}
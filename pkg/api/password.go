@@ -1,6 +1,7 @@
 package api
 
 import (
+	"crypto/rand"
 	"encoding/hex"
 	"fmt"
 	"os"
@@ -9,19 +10,46 @@ import (
 )
 
 func (s Server) DecryptPassword() (decrypted string, err error) {
-	var key [32]byte
+	decrypted, err = DecryptSecret(s.Password)
+	if err != nil {
+		err = fmt.Errorf("password: %s", err)
+	}
+	return
+}
+
+// EncryptSecret seals value with DATABASE_BACKUP_KEY the way server
+// passwords are stored: hex of the nonce followed by the sealed box
+func EncryptSecret(value string) (string, error) {
+	key, err := secretKey()
+	if err != nil {
+		return "", err
+	}
+
 	var nonce [24]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return "", err
+	}
 
-	keyRaw, err := hex.DecodeString(os.Getenv("DATABASE_BACKUP_KEY"))
+	sealed := secretbox.Seal(nonce[:], []byte(value), &nonce, &key)
+	return hex.EncodeToString(sealed), nil
+}
+
+// DecryptSecret opens a value sealed by EncryptSecret
+func DecryptSecret(value string) (decrypted string, err error) {
+	var nonce [24]byte
+
+	key, err := secretKey()
 	if err != nil {
-		err = fmt.Errorf("decoding DATABASE_BACKUP_KEY: %s", err)
 		return
 	}
-	copy(key[:], keyRaw)
 
-	encrypted, err := hex.DecodeString(s.Password)
+	encrypted, err := hex.DecodeString(value)
 	if err != nil {
-		err = fmt.Errorf("decoding password: %s", err)
+		err = fmt.Errorf("decoding: %s", err)
+		return
+	}
+	if len(encrypted) < len(nonce) {
+		err = fmt.Errorf("decryption error")
 		return
 	}
 	copy(nonce[:], encrypted[:24])
@@ -35,3 +63,13 @@ func (s Server) DecryptPassword() (decrypted string, err error) {
 
 	return
 }
+
+func secretKey() (key [32]byte, err error) {
+	keyRaw, err := hex.DecodeString(os.Getenv("DATABASE_BACKUP_KEY"))
+	if err != nil {
+		err = fmt.Errorf("decoding DATABASE_BACKUP_KEY: %s", err)
+		return
+	}
+	copy(key[:], keyRaw)
+	return
+}
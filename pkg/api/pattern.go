@@ -0,0 +1,55 @@
+package api
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// matchPattern reports whether name matches a glob such as cache_*, or a
+// regular expression when the pattern is wrapped in slashes
+func matchPattern(pattern string, name string) bool {
+	if expr, ok := patternRegexp(pattern); ok {
+		re, err := regexp.Compile(expr)
+		return err == nil && re.MatchString(name)
+	}
+	ok, _ := path.Match(pattern, name)
+	return ok
+}
+
+// matchPatterns reports whether name matches any of the space-separated
+// patterns
+func matchPatterns(patterns string, name string) bool {
+	for _, pattern := range strings.Fields(patterns) {
+		if matchPattern(pattern, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func patternRegexp(pattern string) (string, bool) {
+	if len(pattern) > 2 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/") {
+		return pattern[1 : len(pattern)-1], true
+	}
+	return "", false
+}
+
+func validatePattern(pattern string) error {
+	if expr, ok := patternRegexp(pattern); ok {
+		_, err := regexp.Compile(expr)
+		return err
+	}
+	_, err := path.Match(pattern, "")
+	return err
+}
+
+func validatePatterns(value string) error {
+	for _, pattern := range strings.Fields(value) {
+		if err := validatePattern(pattern); err != nil {
+			return fmt.Errorf("%s: %s", pattern, err)
+		}
+	}
+	return nil
+}
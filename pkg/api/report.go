@@ -0,0 +1,84 @@
+package api
+
+import (
+	"fmt"
+	htmltemplate "html/template"
+	"text/template"
+	"time"
+)
+
+// BackupResult is how one database fared in a run
+type BackupResult struct {
+	Server       string        `json:"server"`
+	Database     string        `json:"database"`
+	Status       string        `json:"status"`
+	Size         int64         `json:"size"`
+	SizePrevious int64         `json:"size_previous"`
+	Elapsed      time.Duration `json:"elapsed"`
+	Error        string        `json:"error,omitempty"`
+	Warning      string        `json:"warning,omitempty"`
+}
+
+// NewBackupResult summarises the log entry of a finished backup
+func NewBackupResult(server Server, database Database, entry Log) BackupResult {
+	result := BackupResult{
+		Server:       server.Name,
+		Database:     database.Name,
+		Status:       entry.Status,
+		Size:         entry.SizeCurrent,
+		SizePrevious: entry.SizePrevious,
+		Error:        entry.Error,
+		Warning:      entry.Warning,
+	}
+	if entry.BackupStart != nil && entry.BackupEnd != nil {
+		result.Elapsed = entry.BackupEnd.Sub(*entry.BackupStart)
+	}
+	return result
+}
+
+// Report is what report templates are executed with: one server's results
+// from a run
+type Report struct {
+	Server  string
+	Start   time.Time
+	End     time.Time
+	Results []BackupResult
+	Failed  int
+}
+
+// ReportFuncs are available to report templates
+var ReportFuncs = map[string]interface{}{
+	"size":  formatSize,
+	"delta": formatDelta,
+}
+
+func formatSize(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	div, exp := int64(unit), 0
+	for n := bytes / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(bytes)/float64(div), "KMGTPE"[exp])
+}
+
+// formatDelta describes the change in size from the previous backup
+func formatDelta(previous int64, current int64) string {
+	if previous == 0 {
+		return "new"
+	}
+	return fmt.Sprintf("%+.1f%%", float64(current-previous)/float64(previous)*100)
+}
+
+func validateTextTemplate(value string) error {
+	_, err := template.New("report").Funcs(ReportFuncs).Parse(value)
+	return err
+}
+
+func validateHTMLTemplate(value string) error {
+	_, err := htmltemplate.New("report").Funcs(ReportFuncs).Parse(value)
+	return err
+}
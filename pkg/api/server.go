@@ -1,10 +1,17 @@
 package api
 
 import (
+	"context"
 	"errors"
-	"os"
+	"fmt"
+	"net/mail"
 	"os/exec"
 	"strings"
+	"time"
+
+	"github.com/jbaikge/database-backups/pkg/cron"
+	"github.com/jbaikge/database-backups/pkg/logging"
+	"github.com/jbaikge/database-backups/pkg/process"
 )
 
 type ServerService interface {
@@ -14,7 +21,7 @@ type ServerService interface {
 	New(NewServerRequest) (*Server, error)
 	Tree() ([]Tree, error)
 	Update(int, NewServerRequest) error
-	UpdateDatabases(int) error
+	UpdateDatabases(context.Context, int) error
 }
 
 type ServerRepository interface {
@@ -24,16 +31,21 @@ type ServerRepository interface {
 	ListServers() ([]Server, error)
 	ServerTree() ([]Tree, error)
 	UpdateServer(int, NewServerRequest) error
-	UpdateServerDatabases(int, []string) error
+	// Returns the names of the databases added and those newly marked removed
+	UpdateServerDatabases(int, []NewDatabaseRequest) ([]string, []string, error)
 }
 
 type serverService struct {
-	storage ServerRepository
+	storage  ServerRepository
+	settings SettingService
+	notifier Notifier
 }
 
-func NewServerService(repo ServerRepository) ServerService {
+func NewServerService(repo ServerRepository, settings SettingService, notifier Notifier) ServerService {
 	return &serverService{
-		storage: repo,
+		storage:  repo,
+		settings: settings,
+		notifier: notifier,
 	}
 }
 
@@ -50,6 +62,9 @@ func (s *serverService) List() ([]Server, error) {
 }
 
 func (s *serverService) New(server NewServerRequest) (*Server, error) {
+	if server.NewDatabasePolicy == "" {
+		server.NewDatabasePolicy = NewDatabasePolicyEnable
+	}
 	if err := s.newServerRequestValidation(server); err != nil {
 		return nil, err
 	}
@@ -67,6 +82,9 @@ func (s *serverService) Tree() ([]Tree, error) {
 }
 
 func (s *serverService) Update(id int, server NewServerRequest) error {
+	if server.NewDatabasePolicy == "" {
+		server.NewDatabasePolicy = NewDatabasePolicyEnable
+	}
 	if err := s.newServerRequestValidation(server); err != nil {
 		return err
 	}
@@ -78,26 +96,75 @@ func (s *serverService) Update(id int, server NewServerRequest) error {
 	return s.storage.UpdateServer(id, server)
 }
 
-func (s *serverService) UpdateDatabases(id int) error {
+// UpdateDatabases adds the databases found on the server and marks those gone
+// as removed, raising an event for each. The server's new database policy
+// decides whether the new ones are backed up. When listing fails, the
+// process.ExitError holds the tail of the command's error output.
+func (s *serverService) UpdateDatabases(ctx context.Context, id int) error {
 	server, err := s.storage.GetServer(id)
 	if err != nil {
 		return err
 	}
 
+	defaults, err := s.settings.Get(SettingDatabaseExclude)
+	if err != nil {
+		return err
+	}
+
 	args, err := server.DatabaseListCmd()
 	if err != nil {
 		return err
 	}
 
-	cmd := exec.Command(args[0], args[1:]...)
-	cmd.Stderr = os.Stderr
-	output, err := cmd.Output()
+	output, err := process.Output(ctx, exec.Command(args[0], args[1:]...))
 	if err != nil {
 		return err
 	}
 
-	databases := strings.Fields(string(output))
-	return s.storage.UpdateServerDatabases(id, databases)
+	names := strings.Fields(string(output))
+	databases := make([]NewDatabaseRequest, len(names))
+	requests := make(map[string]NewDatabaseRequest, len(names))
+	for i, name := range names {
+		databases[i] = NewDatabaseRequest{ServerId: id, Name: name}
+		databases[i].Backup, databases[i].Pending = server.NewDatabase(name, defaults)
+		requests[name] = databases[i]
+	}
+	added, removed, err := s.storage.UpdateServerDatabases(id, databases)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range added {
+		database := requests[name]
+		state := "backups on"
+		if database.Pending {
+			state = "pending review"
+		} else if !database.Backup {
+			state = "excluded"
+		}
+		s.notify(ctx, Event{
+			Type:     EventDatabaseAdded,
+			Server:   server.Name,
+			Database: database.Name,
+			Message:  fmt.Sprintf("New database %s on %s, %s", database.Name, server.Name, state),
+		})
+	}
+	for _, name := range removed {
+		s.notify(ctx, Event{
+			Type:     EventDatabaseRemoved,
+			Server:   server.Name,
+			Database: name,
+			Message:  fmt.Sprintf("Database %s is no longer on %s", name, server.Name),
+		})
+	}
+	return nil
+}
+
+func (s *serverService) notify(ctx context.Context, event Event) {
+	event.Time = time.Now()
+	if err := s.notifier.Notify(ctx, event); err != nil {
+		logging.Error(ctx, "Notifying failed", "event", event.Type, "error", err)
+	}
 }
 
 func (s *serverService) newServerRequestValidation(server NewServerRequest) error {
@@ -129,5 +196,65 @@ func (s *serverService) newServerRequestValidation(server NewServerRequest) erro
 		return errors.New("proxy_identity is required")
 	}
 
+	if server.Schedule != "" {
+		if _, err := cron.Parse(server.Schedule); err != nil {
+			return err
+		}
+	}
+
+	if err := validatePatterns(server.DatabaseInclude); err != nil {
+		return fmt.Errorf("database_include: %s", err)
+	}
+
+	if err := validatePatterns(server.DatabaseExclude); err != nil {
+		return fmt.Errorf("database_exclude: %s", err)
+	}
+
+	if server.ReportRecipients != "" {
+		if _, err := mail.ParseAddressList(server.ReportRecipients); err != nil {
+			return fmt.Errorf("report_recipients: %s", err)
+		}
+	}
+
+	switch server.NewDatabasePolicy {
+	case NewDatabasePolicyEnable, NewDatabasePolicyReview, NewDatabasePolicyPattern:
+	default:
+		return fmt.Errorf("new_database_policy must be %s, %s or %s", NewDatabasePolicyEnable, NewDatabasePolicyReview, NewDatabasePolicyPattern)
+	}
+
+	switch server.Binlog {
+	case "", BinlogOptionMasterData, BinlogOptionSourceData:
+	default:
+		return fmt.Errorf("binlog must be empty, %s or %s", BinlogOptionMasterData, BinlogOptionSourceData)
+	}
+
 	return nil
 }
+
+// ExcludesDatabase reports whether a newly found database starts with backups
+// off. The server's exclude patterns always apply, and its include patterns
+// take databases back out of the defaults from the database_exclude setting.
+func (s Server) ExcludesDatabase(name string, defaults string) bool {
+	if matchPatterns(s.DatabaseExclude, name) {
+		return true
+	}
+	return matchPatterns(defaults, name) && !matchPatterns(s.DatabaseInclude, name)
+}
+
+// NewDatabase decides whether a newly found database is backed up, or held
+// back for review, under the server's policy
+func (s Server) NewDatabase(name string, defaults string) (backup bool, pending bool) {
+	if s.ExcludesDatabase(name, defaults) {
+		return false, false
+	}
+	switch s.NewDatabasePolicy {
+	case NewDatabasePolicyReview:
+		return false, true
+	case NewDatabasePolicyPattern:
+		if matchPatterns(s.DatabaseInclude, name) {
+			return true, false
+		}
+		return false, true
+	}
+	return true, false
+}
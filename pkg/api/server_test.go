@@ -0,0 +1,47 @@
+package api_test
+
+import (
+	"testing"
+
+	"github.com/jbaikge/database-backups/pkg/api"
+	"github.com/zeebo/assert"
+)
+
+func TestServerExcludesDatabase(t *testing.T) {
+	defaults := "information_schema mysql performance_schema sys"
+	server := api.Server{
+		DatabaseInclude: "mysql",
+		DatabaseExclude: "scratch_* /^tmp[0-9]+$/",
+	}
+
+	assert.That(t, server.ExcludesDatabase("information_schema", defaults))
+	assert.False(t, server.ExcludesDatabase("mysql", defaults))
+	assert.That(t, server.ExcludesDatabase("scratch_reports", defaults))
+	assert.That(t, server.ExcludesDatabase("tmp42", defaults))
+	assert.False(t, server.ExcludesDatabase("shop", defaults))
+
+	assert.That(t, api.Server{}.ExcludesDatabase("mysql", defaults))
+}
+
+func TestServerNewDatabase(t *testing.T) {
+	defaults := "information_schema mysql"
+	tests := []struct {
+		policy  string
+		name    string
+		backup  bool
+		pending bool
+	}{
+		{api.NewDatabasePolicyEnable, "shop", true, false},
+		{api.NewDatabasePolicyEnable, "mysql", false, false},
+		{api.NewDatabasePolicyReview, "shop", false, true},
+		{api.NewDatabasePolicyReview, "mysql", false, false},
+		{api.NewDatabasePolicyPattern, "shop_eu", true, false},
+		{api.NewDatabasePolicyPattern, "scratch", false, true},
+	}
+	for _, test := range tests {
+		server := api.Server{NewDatabasePolicy: test.policy, DatabaseInclude: "shop_*"}
+		backup, pending := server.NewDatabase(test.name, defaults)
+		assert.Equal(t, backup, test.backup)
+		assert.Equal(t, pending, test.pending)
+	}
+}
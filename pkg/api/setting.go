@@ -0,0 +1,258 @@
+package api
+
+import (
+	"fmt"
+	"net/mail"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	SettingDatabaseExclude      = "database_exclude"
+	SettingDatabaseTimeout      = "database_timeout"
+	SettingMaxAge               = "max_age"
+	SettingReportSubject        = "report_subject"
+	SettingReportTemplateHTML   = "report_template_html"
+	SettingReportTemplateText   = "report_template_text"
+	SettingRetryAttemptsDump    = "retry_attempts_dump"
+	SettingRetryAttemptsRefresh = "retry_attempts_refresh"
+	SettingRetryAttemptsUpload  = "retry_attempts_upload"
+	SettingRetryBackoff         = "retry_backoff"
+	SettingRetryBackoffMax      = "retry_backoff_max"
+	SettingRetryJitter          = "retry_jitter"
+	SettingRunTimeout           = "run_timeout"
+	SettingSizeDropAction       = "size_drop_action"
+	SettingSizeDropThreshold    = "size_drop_threshold"
+	SettingSMTPFrom             = "smtp_from"
+	SettingSMTPHost             = "smtp_host"
+	SettingSMTPPassword         = "smtp_password"
+	SettingSMTPPort             = "smtp_port"
+	SettingSMTPStartTLS         = "smtp_starttls"
+	SettingSMTPUsername         = "smtp_username"
+	SettingTableExclude         = "table_exclude"
+)
+
+const (
+	SizeDropActionFail = "fail"
+	SizeDropActionFlag = "flag"
+)
+
+// SecretPlaceholder stands in for a secret setting's value when settings are
+// listed. Setting a secret to it leaves the stored value alone, so a listing
+// can be sent back unchanged.
+const SecretPlaceholder = "********"
+
+type settingDefinition struct {
+	value    string
+	validate func(string) error
+	// Stored encrypted with DATABASE_BACKUP_KEY and never listed
+	secret bool
+}
+
+// Every setting must be listed here with its default; unknown keys are
+// rejected
+var settingDefinitions = map[string]settingDefinition{
+	SettingDatabaseExclude:      {value: "information_schema innodb mysql performance_schema sys tmp", validate: validatePatterns},
+	SettingDatabaseTimeout:      {value: "6h", validate: validateDuration},
+	SettingMaxAge:               {value: "36h", validate: validateDuration},
+	SettingReportSubject:        {value: "Backup report for {{.Server}}: {{len .Results}} databases, {{.Failed}} failed", validate: validateTextTemplate},
+	SettingReportTemplateHTML:   {value: "", validate: validateHTMLTemplate},
+	SettingReportTemplateText:   {value: "", validate: validateTextTemplate},
+	SettingRetryAttemptsDump:    {value: "3", validate: validatePositiveInt},
+	SettingRetryAttemptsRefresh: {value: "3", validate: validatePositiveInt},
+	SettingRetryAttemptsUpload:  {value: "5", validate: validatePositiveInt},
+	SettingRetryBackoff:         {value: "30s", validate: validateDuration},
+	SettingRetryBackoffMax:      {value: "10m", validate: validateDuration},
+	SettingRetryJitter:          {value: "0.2", validate: validateFraction},
+	SettingRunTimeout:           {value: "20h", validate: validateDuration},
+	SettingSizeDropAction:       {value: SizeDropActionFlag, validate: validateChoice(SizeDropActionFail, SizeDropActionFlag)},
+	SettingSizeDropThreshold:    {value: "0.5", validate: validateFraction},
+	SettingSMTPFrom:             {value: "", validate: validateAddress},
+	SettingSMTPHost:             {value: "", validate: validateAny},
+	SettingSMTPPassword:         {value: "", validate: validateAny, secret: true},
+	SettingSMTPPort:             {value: "587", validate: validatePositiveInt},
+	SettingSMTPStartTLS:         {value: "true", validate: validateChoice("true", "false")},
+	SettingSMTPUsername:         {value: "", validate: validateAny},
+	SettingTableExclude:         {value: "", validate: validatePatterns},
+}
+
+type SettingService interface {
+	Duration(string) (time.Duration, error)
+	Float(string) (float64, error)
+	Get(string) (string, error)
+	Int(string) (int, error)
+	List() ([]Setting, error)
+	Set(string, string) error
+}
+
+type SettingRepository interface {
+	GetSetting(string) (*Setting, error)
+	ListSettings() ([]Setting, error)
+	SetSetting(Setting) error
+}
+
+type settingService struct {
+	storage SettingRepository
+}
+
+func NewSettingService(repo SettingRepository) SettingService {
+	return &settingService{
+		storage: repo,
+	}
+}
+
+// Duration returns the setting parsed as a duration. Zero means no limit.
+func (s *settingService) Duration(key string) (time.Duration, error) {
+	value, err := s.Get(key)
+	if err != nil {
+		return 0, err
+	}
+	return time.ParseDuration(value)
+}
+
+func (s *settingService) Float(key string) (float64, error) {
+	value, err := s.Get(key)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseFloat(value, 64)
+}
+
+// Get returns the setting's value. Secrets are returned encrypted, as they
+// are stored; see DecryptSecret.
+func (s *settingService) Get(key string) (string, error) {
+	definition, ok := settingDefinitions[key]
+	if !ok {
+		return "", fmt.Errorf("unknown setting: %s", key)
+	}
+
+	setting, err := s.storage.GetSetting(key)
+	if err != nil {
+		return "", err
+	}
+	if setting == nil {
+		return definition.value, nil
+	}
+	return setting.Value, nil
+}
+
+func (s *settingService) Int(key string) (int, error) {
+	value, err := s.Get(key)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(value)
+}
+
+// List returns every known setting, filling in defaults for those not stored.
+// Secrets that are set are shown as SecretPlaceholder.
+func (s *settingService) List() ([]Setting, error) {
+	stored, err := s.storage.ListSettings()
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]string, len(stored))
+	for _, setting := range stored {
+		values[setting.Key] = setting.Value
+	}
+
+	settings := make([]Setting, 0, len(settingDefinitions))
+	for key, definition := range settingDefinitions {
+		value, ok := values[key]
+		if !ok {
+			value = definition.value
+		}
+		if definition.secret && value != "" {
+			value = SecretPlaceholder
+		}
+		settings = append(settings, Setting{Key: key, Value: value, Default: definition.value})
+	}
+	sort.Slice(settings, func(i, j int) bool {
+		return settings[i].Key < settings[j].Key
+	})
+
+	return settings, nil
+}
+
+func (s *settingService) Set(key string, value string) error {
+	definition, ok := settingDefinitions[key]
+	if !ok {
+		return fmt.Errorf("unknown setting: %s", key)
+	}
+
+	if err := definition.validate(value); err != nil {
+		return fmt.Errorf("%s: %s", key, err)
+	}
+
+	if definition.secret && value == SecretPlaceholder {
+		return nil
+	}
+	if definition.secret && value != "" {
+		encrypted, err := EncryptSecret(value)
+		if err != nil {
+			return fmt.Errorf("%s: %s", key, err)
+		}
+		value = encrypted
+	}
+
+	return s.storage.SetSetting(Setting{Key: key, Value: value})
+}
+
+func validateAddress(value string) error {
+	if value == "" {
+		return nil
+	}
+	_, err := mail.ParseAddress(value)
+	return err
+}
+
+func validateAny(value string) error {
+	return nil
+}
+
+func validateChoice(choices ...string) func(string) error {
+	return func(value string) error {
+		for _, choice := range choices {
+			if value == choice {
+				return nil
+			}
+		}
+		return fmt.Errorf("must be one of %s", strings.Join(choices, ", "))
+	}
+}
+
+func validateDuration(value string) error {
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	if d < 0 {
+		return fmt.Errorf("duration cannot be negative")
+	}
+	return nil
+}
+
+func validateFraction(value string) error {
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return err
+	}
+	if f < 0 || f > 1 {
+		return fmt.Errorf("must be between 0 and 1")
+	}
+	return nil
+}
+
+func validatePositiveInt(value string) error {
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		return err
+	}
+	if i < 1 {
+		return fmt.Errorf("must be at least 1")
+	}
+	return nil
+}
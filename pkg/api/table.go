@@ -0,0 +1,207 @@
+package api
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Matches reports whether the rule's pattern matches the table name. Patterns
+// are globs, or regular expressions when wrapped in slashes (/^cache_\d+$/).
+func (r TableRule) Matches(table string) bool {
+	return matchPattern(r.Table, table)
+}
+
+// Functions available to WHERE predicates, all in local time
+func whereFuncs(now time.Time) template.FuncMap {
+	return template.FuncMap{
+		"now": func() string {
+			return now.Format("2006-01-02 15:04:05")
+		},
+		"today": func() string {
+			return now.Format("2006-01-02")
+		},
+		"daysAgo": func(days int) string {
+			return now.AddDate(0, 0, -days).Format("2006-01-02")
+		},
+		"hoursAgo": func(hours int) string {
+			return now.Add(-time.Duration(hours) * time.Hour).Format("2006-01-02 15:04:05")
+		},
+	}
+}
+
+// ExpandWhere fills in the relative dates of a WHERE predicate, for example
+// "created_at >= '{{daysAgo 90}}'"
+func ExpandWhere(where string, now time.Time) (string, error) {
+	tmpl, err := template.New("where").Funcs(whereFuncs(now)).Option("missingkey=error").Parse(where)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	if err := tmpl.Execute(&b, nil); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// ExpandWhere returns a copy of the database with the relative dates in its
+// rules' WHERE predicates filled in
+func (d Database) ExpandWhere(now time.Time) (Database, error) {
+	expanded := d
+	expanded.Tables = make([]TableRule, len(d.Tables))
+	for i, rule := range d.Tables {
+		if rule.Where != "" {
+			var err error
+			if rule.Where, err = ExpandWhere(rule.Where, now); err != nil {
+				return d, fmt.Errorf("%s: %s", rule.Table, err)
+			}
+		}
+		expanded.Tables[i] = rule
+	}
+	return expanded, nil
+}
+
+// DefaultTableRules returns the skip rules from the table_exclude setting,
+// which apply to every database after its own rules
+func DefaultTableRules(settings SettingService) ([]TableRule, error) {
+	value, err := settings.Get(SettingTableExclude)
+	if err != nil {
+		return nil, err
+	}
+	patterns := strings.Fields(value)
+	rules := make([]TableRule, len(patterns))
+	for i, pattern := range patterns {
+		rules[i] = TableRule{Table: pattern, Mode: TableModeSkip}
+	}
+	return rules, nil
+}
+
+// Resolve matches the rules against the database's actual tables and returns
+// a copy with a rule naming each table whose mode differs from the database's
+// own. defaults are tried after the database's rules.
+func (d Database) Resolve(tables []string, defaults []TableRule) Database {
+	resolved := d
+	resolved.TableMode = d.defaultTableMode()
+	resolved.Tables = make([]TableRule, 0, len(tables))
+
+	rules := make([]TableRule, 0, len(d.Tables)+len(defaults))
+	rules = append(append(rules, d.Tables...), defaults...)
+	for _, table := range tables {
+		for _, rule := range rules {
+			if !rule.Matches(table) {
+				continue
+			}
+			if rule.Mode != resolved.TableMode || rule.Where != "" {
+				resolved.Tables = append(resolved.Tables, TableRule{Table: table, Mode: rule.Mode, Where: rule.Where})
+			}
+			break
+		}
+	}
+	return resolved
+}
+
+// TableRuleFor returns the first rule matching the table, if any
+func (d Database) TableRuleFor(table string) (TableRule, bool) {
+	for _, rule := range d.Tables {
+		if rule.Matches(table) {
+			return rule, true
+		}
+	}
+	return TableRule{}, false
+}
+
+// TableModeFor returns how the table is backed up
+func (d Database) TableModeFor(table string) string {
+	if rule, ok := d.TableRuleFor(table); ok {
+		return rule.Mode
+	}
+	return d.defaultTableMode()
+}
+
+// dumpTables returns the mysqldump arguments selecting the tables include
+// accepts the rule of. Tables without a rule are judged by the database's
+// own mode: when that is accepted every table is dumped apart from those
+// ruled otherwise, else only the tables named in rules are. Patterns must
+// already be resolved to table names. ok is false when no table would be
+// selected.
+func (d Database) dumpTables(include func(TableRule) bool) (args []string, ok bool) {
+	if include(TableRule{Mode: d.defaultTableMode()}) {
+		args = make([]string, 0, len(d.Tables)*2+1)
+		for _, rule := range d.Tables {
+			if !include(rule) {
+				args = append(args, "--ignore-table", d.Name+"."+rule.Table)
+			}
+		}
+		return append(args, d.Name), true
+	}
+
+	args = append(make([]string, 0, len(d.Tables)+1), d.Name)
+	for _, rule := range d.Tables {
+		if include(rule) {
+			args = append(args, rule.Table)
+		}
+	}
+	return args, len(args) > 1
+}
+
+// filteredTables returns the rules of full tables dumped with a WHERE
+// predicate, each of which needs its own mysqldump
+func (d Database) filteredTables() []TableRule {
+	rules := make([]TableRule, 0, len(d.Tables))
+	for _, rule := range d.Tables {
+		if rule.Mode == TableModeFull && rule.Where != "" {
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
+
+func (d Database) defaultTableMode() string {
+	if d.TableMode == "" {
+		return TableModeFull
+	}
+	return d.TableMode
+}
+
+// ValidateTables checks the table mode and rules
+func (d Database) ValidateTables() error {
+	return validateTableRules(d.defaultTableMode(), d.Tables)
+}
+
+func validateTableRules(mode string, rules []TableRule) error {
+	if err := validateTableMode(mode); err != nil {
+		return fmt.Errorf("table_mode: %s", err)
+	}
+
+	seen := make(map[string]bool, len(rules))
+	for _, rule := range rules {
+		if rule.Table == "" {
+			return fmt.Errorf("tables: table pattern is required")
+		}
+		if seen[rule.Table] {
+			return fmt.Errorf("tables: %s is listed more than once", rule.Table)
+		}
+		seen[rule.Table] = true
+		if err := validatePattern(rule.Table); err != nil {
+			return fmt.Errorf("tables: %s: %s", rule.Table, err)
+		}
+		if err := validateTableMode(rule.Mode); err != nil {
+			return fmt.Errorf("tables: %s: %s", rule.Table, err)
+		}
+		if rule.Where == "" {
+			continue
+		}
+		if rule.Mode != TableModeFull {
+			return fmt.Errorf("tables: %s: where only applies to full tables", rule.Table)
+		}
+		if _, err := ExpandWhere(rule.Where, time.Now()); err != nil {
+			return fmt.Errorf("tables: %s: %s", rule.Table, err)
+		}
+	}
+	return nil
+}
+
+func validateTableMode(mode string) error {
+	return validateChoice(TableModeFull, TableModeSchema, TableModeSkip)(mode)
+}
@@ -0,0 +1,144 @@
+package api_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jbaikge/database-backups/pkg/api"
+	"github.com/zeebo/assert"
+)
+
+func TestDatabaseDumpCmds(t *testing.T) {
+	server := api.Server{Host: "db1", Port: 3306, Username: "backup"}
+	auth := []string{"--host", "db1", "--port", "3306", "--user", "backup"}
+	cmd := func(args ...string) []string {
+		return append(append([]string{"mysqldump"}, auth...), args...)
+	}
+
+	tests := []struct {
+		database api.Database
+		expected [][]string
+	}{
+		{
+			database: api.Database{Name: "shop"},
+			expected: [][]string{
+				cmd("--single-transaction", "shop"),
+			},
+		},
+		{
+			database: api.Database{
+				Name: "shop",
+				Tables: []api.TableRule{
+					{Table: "audit", Mode: api.TableModeSchema},
+					{Table: "sessions", Mode: api.TableModeSkip},
+				},
+			},
+			expected: [][]string{
+				cmd("--single-transaction", "--ignore-table", "shop.audit", "--ignore-table", "shop.sessions", "shop"),
+				cmd("--single-transaction", "--no-data", "shop", "audit"),
+			},
+		},
+		{
+			database: api.Database{
+				Name:      "shop",
+				TableMode: api.TableModeSchema,
+				Tables: []api.TableRule{
+					{Table: "orders", Mode: api.TableModeFull},
+					{Table: "sessions", Mode: api.TableModeSkip},
+				},
+			},
+			expected: [][]string{
+				cmd("--single-transaction", "shop", "orders"),
+				cmd("--single-transaction", "--no-data", "--ignore-table", "shop.orders", "--ignore-table", "shop.sessions", "shop"),
+			},
+		},
+		{
+			database: api.Database{
+				Name: "shop",
+				Tables: []api.TableRule{
+					{Table: "events", Mode: api.TableModeFull, Where: "created_at >= '2022-01-01'"},
+					{Table: "sessions", Mode: api.TableModeSkip},
+				},
+			},
+			expected: [][]string{
+				cmd("--single-transaction", "--ignore-table", "shop.events", "--ignore-table", "shop.sessions", "shop"),
+				cmd("--single-transaction", "--where=created_at >= '2022-01-01'", "shop", "events"),
+			},
+		},
+	}
+
+	for _, test := range tests {
+		cmds, err := server.DatabaseDumpCmds(test.database)
+		assert.Nil(t, err)
+		assert.DeepEqual(t, cmds, test.expected)
+	}
+
+	_, err := server.DatabaseDumpCmds(api.Database{Name: "shop", TableMode: api.TableModeSkip})
+	assert.Error(t, err)
+}
+
+func TestTableRuleMatches(t *testing.T) {
+	glob := api.TableRule{Table: "cache_*"}
+	assert.That(t, glob.Matches("cache_pages"))
+	assert.False(t, glob.Matches("pages_cache"))
+
+	regexp := api.TableRule{Table: "/^log_[0-9]{6}$/"}
+	assert.That(t, regexp.Matches("log_202201"))
+	assert.False(t, regexp.Matches("log_current"))
+
+	literal := api.TableRule{Table: "orders"}
+	assert.That(t, literal.Matches("orders"))
+	assert.False(t, literal.Matches("orders_archive"))
+}
+
+func TestDatabaseResolve(t *testing.T) {
+	database := api.Database{
+		Name: "shop",
+		Tables: []api.TableRule{
+			{Table: "cache_keep", Mode: api.TableModeFull},
+			{Table: "cache_*", Mode: api.TableModeSkip},
+			{Table: "/^audit_/", Mode: api.TableModeSchema},
+		},
+	}
+	defaults := []api.TableRule{
+		{Table: "*_tmp", Mode: api.TableModeSkip},
+	}
+	tables := []string{"audit_logins", "cache_keep", "cache_pages", "orders", "orders_tmp"}
+
+	resolved := database.Resolve(tables, defaults)
+	assert.Equal(t, resolved.TableMode, api.TableModeFull)
+	assert.DeepEqual(t, resolved.Tables, []api.TableRule{
+		{Table: "audit_logins", Mode: api.TableModeSchema},
+		{Table: "cache_pages", Mode: api.TableModeSkip},
+		{Table: "orders_tmp", Mode: api.TableModeSkip},
+	})
+
+	assert.Error(t, api.Database{Tables: []api.TableRule{{Table: "/[/", Mode: api.TableModeSkip}}}.ValidateTables())
+	assert.Error(t, api.Database{Tables: []api.TableRule{{Table: "[", Mode: api.TableModeSkip}}}.ValidateTables())
+	assert.Nil(t, database.ValidateTables())
+}
+
+func TestExpandWhere(t *testing.T) {
+	now := time.Date(2022, 3, 1, 8, 30, 0, 0, time.Local)
+
+	tests := []struct {
+		where    string
+		expected string
+	}{
+		{"id > 100", "id > 100"},
+		{"created_at >= '{{daysAgo 90}}'", "created_at >= '2021-12-01'"},
+		{"updated_at > '{{hoursAgo 12}}'", "updated_at > '2022-02-28 20:30:00'"},
+		{"day = '{{today}}' AND at < '{{now}}'", "day = '2022-03-01' AND at < '2022-03-01 08:30:00'"},
+	}
+	for _, test := range tests {
+		where, err := api.ExpandWhere(test.where, now)
+		assert.Nil(t, err)
+		assert.Equal(t, where, test.expected)
+	}
+
+	_, err := api.ExpandWhere("created_at >= '{{weeksAgo 2}}'", now)
+	assert.Error(t, err)
+
+	assert.Error(t, api.Database{Tables: []api.TableRule{{Table: "events", Mode: api.TableModeSchema, Where: "id > 1"}}}.ValidateTables())
+	assert.Nil(t, api.Database{Tables: []api.TableRule{{Table: "events", Mode: api.TableModeFull, Where: "id > {{daysAgo 1}}"}}}.ValidateTables())
+}
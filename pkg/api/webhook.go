@@ -0,0 +1,128 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+type WebhookService interface {
+	Delete(int) error
+	Get(int) (*Webhook, error)
+	List() ([]Webhook, error)
+	New(NewWebhookRequest) (*Webhook, error)
+	Update(int, NewWebhookRequest) error
+}
+
+type WebhookRepository interface {
+	CreateWebhook(NewWebhookRequest) (int, error)
+	DeleteWebhook(int) error
+	GetWebhook(int) (*Webhook, error)
+	ListWebhooks() ([]Webhook, error)
+	UpdateWebhook(int, NewWebhookRequest) error
+}
+
+type webhookService struct {
+	storage WebhookRepository
+}
+
+func NewWebhookService(repo WebhookRepository) WebhookService {
+	return &webhookService{
+		storage: repo,
+	}
+}
+
+func (s *webhookService) Delete(id int) error {
+	return s.storage.DeleteWebhook(id)
+}
+
+func (s *webhookService) Get(id int) (*Webhook, error) {
+	return s.storage.GetWebhook(id)
+}
+
+func (s *webhookService) List() ([]Webhook, error) {
+	return s.storage.ListWebhooks()
+}
+
+func (s *webhookService) New(webhook NewWebhookRequest) (*Webhook, error) {
+	if webhook.Format == "" {
+		webhook.Format = WebhookFormatJSON
+	}
+	if err := s.newWebhookRequestValidation(webhook); err != nil {
+		return nil, err
+	}
+
+	id, err := s.storage.CreateWebhook(webhook)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.Get(id)
+}
+
+func (s *webhookService) Update(id int, webhook NewWebhookRequest) error {
+	if webhook.Format == "" {
+		webhook.Format = WebhookFormatJSON
+	}
+	if err := s.newWebhookRequestValidation(webhook); err != nil {
+		return err
+	}
+
+	if id == 0 {
+		return errors.New("id cannot be zero")
+	}
+
+	return s.storage.UpdateWebhook(id, webhook)
+}
+
+func (s *webhookService) newWebhookRequestValidation(webhook NewWebhookRequest) error {
+	if webhook.Name == "" {
+		return errors.New("name is required")
+	}
+
+	u, err := url.Parse(webhook.URL)
+	if err != nil {
+		return fmt.Errorf("url: %s", err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("url must be an http or https URL")
+	}
+
+	if err := validateChoice(WebhookFormatJSON, WebhookFormatSlack, WebhookFormatTeams)(webhook.Format); err != nil {
+		return fmt.Errorf("format: %s", err)
+	}
+
+	for _, event := range strings.Fields(webhook.Events) {
+		if err := validateChoice(eventTypes...)(event); err != nil {
+			return fmt.Errorf("events: %s", err)
+		}
+	}
+
+	if err := validatePatterns(webhook.Servers); err != nil {
+		return fmt.Errorf("servers: %s", err)
+	}
+
+	return nil
+}
+
+// Wants reports whether the event is routed to the webhook. Events without a
+// server, such as run summaries, go to every webhook taking their type.
+func (w Webhook) Wants(event Event) bool {
+	if w.Events != "" && !containsField(w.Events, event.Type) {
+		return false
+	}
+	if w.Servers == "" || event.Server == "" {
+		return true
+	}
+	return matchPatterns(w.Servers, event.Server)
+}
+
+func containsField(fields string, value string) bool {
+	for _, field := range strings.Fields(fields) {
+		if field == value {
+			return true
+		}
+	}
+	return false
+}
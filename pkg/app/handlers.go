@@ -1,13 +1,77 @@
 package app
 
 import (
+	"bytes"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/jbaikge/database-backups/pkg/api"
+	"github.com/jbaikge/database-backups/pkg/backup"
+	"github.com/jbaikge/database-backups/pkg/metrics"
 )
 
+func (s *Server) BackupDatabase() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if s.queue == nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"success": false, "error": "backups are not enabled"})
+			return
+		}
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
+			return
+		}
+		database, err := s.databaseService.Get(id)
+		if err != nil {
+			c.JSON(http.StatusExpectationFailed, gin.H{"success": false, "error": err.Error()})
+			return
+		}
+		if database == nil {
+			c.JSON(http.StatusNotFound, gin.H{"success": false, "error": "not found"})
+			return
+		}
+		server, err := s.serverService.Get(database.ServerId)
+		if err != nil {
+			c.JSON(http.StatusExpectationFailed, gin.H{"success": false, "error": err.Error()})
+			return
+		}
+		if server == nil {
+			c.JSON(http.StatusNotFound, gin.H{"success": false, "error": "not found"})
+			return
+		}
+		if !s.queue.Enqueue(backup.Job{Server: *server, Database: *database}) {
+			c.JSON(http.StatusConflict, gin.H{"success": false, "error": "already queued"})
+			return
+		}
+		c.JSON(http.StatusAccepted, gin.H{"success": true})
+	}
+}
+
+// BackupHealth lists databases whose last successful backup is older than
+// their max_age. It answers 503 when any are, so it can be probed directly.
+func (s *Server) BackupHealth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		stats, err := s.logService.Stats()
+		if err != nil {
+			c.JSON(http.StatusExpectationFailed, gin.H{"success": false, "error": err.Error()})
+			return
+		}
+		maxAge, err := s.settingService.Duration(api.SettingMaxAge)
+		if err != nil {
+			c.JSON(http.StatusExpectationFailed, gin.H{"success": false, "error": err.Error()})
+			return
+		}
+		health := api.CheckBackups(stats, maxAge, time.Now())
+		if !health.OK() {
+			c.JSON(http.StatusServiceUnavailable, health)
+			return
+		}
+		c.JSON(http.StatusOK, health)
+	}
+}
+
 func (s *Server) CreateServer() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var newServer api.NewServerRequest
@@ -27,6 +91,22 @@ func (s *Server) CreateServer() gin.HandlerFunc {
 	}
 }
 
+func (s *Server) CreateWebhook() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var newWebhook api.NewWebhookRequest
+		if err := c.BindJSON(&newWebhook); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
+			return
+		}
+		webhook, err := s.webhookService.New(newWebhook)
+		if err != nil {
+			c.JSON(http.StatusPreconditionFailed, gin.H{"success": false, "error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusCreated, webhook)
+	}
+}
+
 func (s *Server) DeleteDatabase() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		id, err := strconv.Atoi(c.Param("id"))
@@ -55,6 +135,21 @@ func (s *Server) DeleteServer() gin.HandlerFunc {
 	}
 }
 
+func (s *Server) DeleteWebhook() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
+			return
+		}
+		if err := s.webhookService.Delete(id); err != nil {
+			c.JSON(http.StatusExpectationFailed, gin.H{"success": false, "error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"success": true})
+	}
+}
+
 func (s *Server) GetDatabase() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		id, err := strconv.Atoi(c.Param("id"))
@@ -95,6 +190,69 @@ func (s *Server) GetServer() gin.HandlerFunc {
 	}
 }
 
+func (s *Server) GetWebhook() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
+			return
+		}
+		webhook, err := s.webhookService.Get(id)
+		if err != nil {
+			c.JSON(http.StatusExpectationFailed, gin.H{"success": false, "error": err.Error()})
+			return
+		}
+		if webhook == nil {
+			c.JSON(http.StatusNotFound, gin.H{"success": false, "error": "not found"})
+			return
+		}
+		c.JSON(http.StatusOK, webhook)
+	}
+}
+
+func (s *Server) ListAttempts() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
+			return
+		}
+		attempts, err := s.logService.ListAttempts(id)
+		if err != nil {
+			c.JSON(http.StatusExpectationFailed, gin.H{"success": false, "error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, attempts)
+	}
+}
+
+func (s *Server) ListLogs() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
+			return
+		}
+		logs, err := s.logService.List(id)
+		if err != nil {
+			c.JSON(http.StatusExpectationFailed, gin.H{"success": false, "error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, logs)
+	}
+}
+
+func (s *Server) ListPendingDatabases() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		databases, err := s.databaseService.ListPending()
+		if err != nil {
+			c.JSON(http.StatusExpectationFailed, gin.H{"success": false, "error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, databases)
+	}
+}
+
 func (s *Server) ListServers() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		servers, err := s.serverService.List()
@@ -106,6 +264,138 @@ func (s *Server) ListServers() gin.HandlerFunc {
 	}
 }
 
+func (s *Server) ListSettings() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		settings, err := s.settingService.List()
+		if err != nil {
+			c.JSON(http.StatusExpectationFailed, gin.H{"success": false, "error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, settings)
+	}
+}
+
+func (s *Server) ListWebhooks() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		webhooks, err := s.webhookService.List()
+		if err != nil {
+			c.JSON(http.StatusExpectationFailed, gin.H{"success": false, "error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, webhooks)
+	}
+}
+
+// Metrics serves the backup history for Prometheus, along with the queue
+// depth when the API is running backups itself
+func (s *Server) Metrics() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		stats, err := s.logService.Stats()
+		if err != nil {
+			c.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+		families := metrics.BackupFamilies(stats)
+		if s.queue != nil {
+			families = append(families, metrics.QueueFamily(s.queue.Len()))
+		}
+
+		var b bytes.Buffer
+		if err := metrics.Write(&b, families); err != nil {
+			c.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+		c.Data(http.StatusOK, metrics.ContentType, b.Bytes())
+	}
+}
+
+// PreviewTables lists the database's tables on its server with the mode each
+// would be backed up in. A POST previews the table_mode and tables rules in
+// the body instead of the stored ones, without saving them.
+func (s *Server) PreviewTables() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
+			return
+		}
+		database, err := s.databaseService.Get(id)
+		if err != nil {
+			c.JSON(http.StatusExpectationFailed, gin.H{"success": false, "error": err.Error()})
+			return
+		}
+		if database == nil {
+			c.JSON(http.StatusNotFound, gin.H{"success": false, "error": "not found"})
+			return
+		}
+		if c.Request.Method == http.MethodPost {
+			var rules api.UpdateDatabaseRequest
+			if err := c.BindJSON(&rules); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
+				return
+			}
+			database.TableMode = rules.TableMode
+			database.Tables = rules.Tables
+			if err := database.ValidateTables(); err != nil {
+				c.JSON(http.StatusPreconditionFailed, gin.H{"success": false, "error": err.Error()})
+				return
+			}
+		}
+		server, err := s.serverService.Get(database.ServerId)
+		if err != nil {
+			c.JSON(http.StatusExpectationFailed, gin.H{"success": false, "error": err.Error()})
+			return
+		}
+		if server == nil {
+			c.JSON(http.StatusNotFound, gin.H{"success": false, "error": "not found"})
+			return
+		}
+		_, tables, err := backup.ResolveTables(c.Request.Context(), *server, *database, s.settingService)
+		if err != nil {
+			c.JSON(http.StatusBadGateway, gin.H{"success": false, "error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, tables)
+	}
+}
+
+func (s *Server) Schedule() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		entries, err := s.scheduler.Entries()
+		if err != nil {
+			c.JSON(http.StatusExpectationFailed, gin.H{"success": false, "error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, entries)
+	}
+}
+
+// TestWebhook sends a test event straight to the webhook, reporting what the
+// endpoint made of it
+func (s *Server) TestWebhook() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
+			return
+		}
+		webhook, err := s.webhookService.Get(id)
+		if err != nil {
+			c.JSON(http.StatusExpectationFailed, gin.H{"success": false, "error": err.Error()})
+			return
+		}
+		if webhook == nil {
+			c.JSON(http.StatusNotFound, gin.H{"success": false, "error": "not found"})
+			return
+		}
+		if err := s.webhooks.Test(c.Request.Context(), *webhook); err != nil {
+			c.JSON(http.StatusBadGateway, gin.H{"success": false, "error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"success": true})
+	}
+}
+
 func (s *Server) Tree() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		tree, err := s.serverService.Tree()
@@ -138,6 +428,21 @@ func (s *Server) UpdateDatabase() gin.HandlerFunc {
 	}
 }
 
+func (s *Server) UpdateSetting() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var setting api.Setting
+		if err := c.BindJSON(&setting); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
+			return
+		}
+		if err := s.settingService.Set(c.Param("key"), setting.Value); err != nil {
+			c.JSON(http.StatusPreconditionFailed, gin.H{"success": false, "error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"success": true})
+	}
+}
+
 func (s *Server) UpdateServer() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var server api.NewServerRequest
@@ -166,10 +471,31 @@ func (s *Server) UpdateServerDatabases() gin.HandlerFunc {
 			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
 			return
 		}
-		if err := s.serverService.UpdateDatabases(id); err != nil {
+		if err := s.serverService.UpdateDatabases(c.Request.Context(), id); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"success": true})
+	}
+}
+
+func (s *Server) UpdateWebhook() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var webhook api.NewWebhookRequest
+
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
+			return
+		}
+		if err := c.BindJSON(&webhook); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
 			return
 		}
+		if err := s.webhookService.Update(id, webhook); err != nil {
+			c.JSON(http.StatusPreconditionFailed, gin.H{"success": false, "error": err.Error()})
+			return
+		}
 		c.JSON(http.StatusOK, gin.H{"success": true})
 	}
 }
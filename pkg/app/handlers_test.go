@@ -0,0 +1,74 @@
+package app_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jbaikge/database-backups/pkg/api"
+	"github.com/jbaikge/database-backups/pkg/app"
+	"github.com/zeebo/assert"
+)
+
+const testKey = "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"
+
+// settingRepository keeps settings in memory
+type settingRepository map[string]string
+
+func (r settingRepository) GetSetting(key string) (*api.Setting, error) {
+	value, ok := r[key]
+	if !ok {
+		return nil, nil
+	}
+	return &api.Setting{Key: key, Value: value}, nil
+}
+
+func (r settingRepository) ListSettings() ([]api.Setting, error) {
+	settings := make([]api.Setting, 0, len(r))
+	for key, value := range r {
+		settings = append(settings, api.Setting{Key: key, Value: value})
+	}
+	return settings, nil
+}
+
+func (r settingRepository) SetSetting(setting api.Setting) error {
+	r[setting.Key] = setting.Value
+	return nil
+}
+
+func TestSettingsHideSecrets(t *testing.T) {
+	os.Setenv("DATABASE_BACKUP_KEY", testKey)
+	gin.SetMode(gin.TestMode)
+
+	repo := settingRepository{}
+	settings := api.NewSettingService(repo)
+	assert.NoError(t, settings.Set(api.SettingSMTPPassword, "hunter2"))
+	stored := repo[api.SettingSMTPPassword]
+	assert.That(t, stored != "hunter2")
+
+	router := app.NewServer(gin.New(), nil, nil, nil, settings, nil, nil, nil, nil).Routes()
+	serve := func(method string, path string, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, r)
+		return w
+	}
+
+	w := serve(http.MethodGet, "/v1/settings", "")
+	assert.Equal(t, w.Code, http.StatusOK)
+	assert.False(t, strings.Contains(w.Body.String(), "hunter2"))
+	assert.False(t, strings.Contains(w.Body.String(), stored))
+	assert.That(t, strings.Contains(w.Body.String(), api.SecretPlaceholder))
+
+	// Sending the placeholder back keeps the password
+	w = serve(http.MethodPut, "/v1/settings/"+api.SettingSMTPPassword, `{"value":"`+api.SecretPlaceholder+`"}`)
+	assert.Equal(t, w.Code, http.StatusOK)
+	assert.Equal(t, repo[api.SettingSMTPPassword], stored)
+	password, err := api.DecryptSecret(repo[api.SettingSMTPPassword])
+	assert.NoError(t, err)
+	assert.Equal(t, password, "hunter2")
+}
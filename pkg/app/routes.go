@@ -9,18 +9,31 @@ import (
 func (s *Server) Routes() *gin.Engine {
 	router := s.router
 
+	router.GET("/metrics", s.Metrics())
+
 	v1 := router.Group("/v1")
 	{
 		v1.GET("/ping", func(c *gin.Context) {
 			c.JSON(http.StatusOK, gin.H{"message": "pong"})
 		})
+		v1.GET("/health/backups", s.BackupHealth())
+		v1.GET("/pending", s.ListPendingDatabases())
+		v1.GET("/schedule", s.Schedule())
+		v1.GET("/settings", s.ListSettings())
+		v1.PUT("/settings/:key", s.UpdateSetting())
 		v1.GET("/tree", s.Tree())
 		databases := v1.Group("/databases")
 		{
 			databases.GET("/:id", s.GetDatabase())
 			databases.PUT("/:id", s.UpdateDatabase())
 			databases.DELETE("/:id", s.DeleteDatabase())
+
+			databases.POST("/:id/backup", s.BackupDatabase())
+			databases.GET("/:id/logs", s.ListLogs())
+			databases.GET("/:id/tables", s.PreviewTables())
+			databases.POST("/:id/tables", s.PreviewTables())
 		}
+		v1.GET("/logs/:id/attempts", s.ListAttempts())
 		servers := v1.Group("/servers")
 		{
 			servers.GET("", s.ListServers())
@@ -32,6 +45,17 @@ func (s *Server) Routes() *gin.Engine {
 
 			servers.POST("/:id/dbs", s.UpdateServerDatabases())
 		}
+		webhooks := v1.Group("/webhooks")
+		{
+			webhooks.GET("", s.ListWebhooks())
+			webhooks.POST("", s.CreateWebhook())
+
+			webhooks.GET("/:id", s.GetWebhook())
+			webhooks.PUT("/:id", s.UpdateWebhook())
+			webhooks.DELETE("/:id", s.DeleteWebhook())
+
+			webhooks.POST("/:id/test", s.TestWebhook())
+		}
 	}
 
 	return router
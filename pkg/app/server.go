@@ -1,25 +1,94 @@
 package app
 
 import (
+	"context"
+	"net/http"
+	"time"
+
 	"github.com/gin-gonic/gin"
 	"github.com/jbaikge/database-backups/pkg/api"
+	"github.com/jbaikge/database-backups/pkg/backup"
+	"github.com/jbaikge/database-backups/pkg/logging"
+	"github.com/jbaikge/database-backups/pkg/notify"
 )
 
 type Server struct {
 	router          *gin.Engine
 	serverService   api.ServerService
 	databaseService api.DatabaseService
+	logService      api.LogService
+	settingService  api.SettingService
+	webhookService  api.WebhookService
+	webhooks        *notify.Webhooks
+	scheduler       *backup.Scheduler
+	queue           *backup.Queue
 }
 
-func NewServer(router *gin.Engine, serverService api.ServerService, databaseService api.DatabaseService) *Server {
+// NewServer sets up the API. The queue may be nil when the API is not
+// running backups itself, in which case manual backup requests are refused.
+func NewServer(router *gin.Engine, serverService api.ServerService, databaseService api.DatabaseService, logService api.LogService, settingService api.SettingService, webhookService api.WebhookService, webhooks *notify.Webhooks, scheduler *backup.Scheduler, queue *backup.Queue) *Server {
 	return &Server{
 		router:          router,
 		serverService:   serverService,
 		databaseService: databaseService,
+		logService:      logService,
+		settingService:  settingService,
+		webhookService:  webhookService,
+		webhooks:        webhooks,
+		scheduler:       scheduler,
+		queue:           queue,
+	}
+}
+
+// Run serves the API until ctx is cancelled, then waits for in-flight
+// requests to finish
+func (s *Server) Run(ctx context.Context, listenAddress string) error {
+	server := &http.Server{
+		Addr:    listenAddress,
+		Handler: s.Routes(),
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		return err
 	}
+	return nil
 }
 
-func (s *Server) Run(listenAddress string) error {
-	r := s.Routes()
-	return r.Run(listenAddress)
+// RequestLogger logs every request once it has been handled. Each request
+// gets a request_id, which is also carried by anything the handlers log
+// through the request's context.
+func RequestLogger() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		ctx := logging.With(c.Request.Context(), "request_id", logging.NewID())
+		c.Request = c.Request.WithContext(ctx)
+
+		c.Next()
+
+		level := logging.LevelInfo
+		switch status := c.Writer.Status(); {
+		case status >= 500:
+			level = logging.LevelError
+		case status >= 400:
+			level = logging.LevelWarn
+		}
+		keyvals := []interface{}{
+			"method", c.Request.Method,
+			"path", c.Request.URL.Path,
+			"status", c.Writer.Status(),
+			"elapsed", time.Since(start),
+			"client_ip", c.ClientIP(),
+		}
+		if len(c.Errors) > 0 {
+			keyvals = append(keyvals, "error", c.Errors.String())
+		}
+		logging.FromContext(ctx).Log(level, "Request", keyvals...)
+	}
 }
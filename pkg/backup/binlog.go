@@ -0,0 +1,388 @@
+package backup
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jbaikge/database-backups/pkg/api"
+	"github.com/jbaikge/database-backups/pkg/logging"
+	"github.com/jbaikge/database-backups/pkg/process"
+)
+
+// BinlogPosition is where a dump sits in the server's binlogs
+type BinlogPosition struct {
+	File     string `json:"file"`
+	Position int64  `json:"position"`
+}
+
+// Replay is the set of binlogs to apply after restoring a dump
+type Replay struct {
+	Server   api.Server
+	Database string
+	Position BinlogPosition
+	Binlogs  []api.Binlog
+	Until    time.Time
+	// False when no archived binlog starts after Until, meaning the binlog
+	// being written to when the archiver last ran may hold events that are
+	// not replayed
+	Complete bool
+}
+
+// Archiver ships closed binlogs to S3 and replays them on restore
+type Archiver struct {
+	binlogService api.BinlogService
+	lockService   api.LockService
+	dumpDir       string
+	bucket        string
+}
+
+func NewArchiver(binlogService api.BinlogService, lockService api.LockService, dumpDir string, bucket string) *Archiver {
+	return &Archiver{
+		binlogService: binlogService,
+		lockService:   lockService,
+		dumpDir:       dumpDir,
+		bucket:        bucket,
+	}
+}
+
+// Archive uploads every closed binlog on the server not already archived and
+// returns how many were shipped. The binlog currently being written to is left
+// for a later pass.
+func (a *Archiver) Archive(ctx context.Context, server api.Server) (int, error) {
+	lock, err := a.lockService.Acquire("binlog:"+server.Name, 0)
+	if err != nil {
+		return 0, err
+	}
+	defer a.lockService.Release(lock)
+
+	args, err := server.BinlogListCmd()
+	if err != nil {
+		return 0, err
+	}
+	output, err := runOutput(ctx, args)
+	if err != nil {
+		return 0, err
+	}
+	names := parseBinlogNames(output)
+	if len(names) == 0 {
+		return 0, nil
+	}
+
+	archived, err := a.binlogService.List(server.Id)
+	if err != nil {
+		return 0, err
+	}
+	seen := make(map[string]bool, len(archived))
+	for _, binlog := range archived {
+		seen[binlog.Name] = true
+	}
+
+	dir := filepath.Join(a.dumpDir, server.Name+"_binlog")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return 0, err
+	}
+
+	shipped := 0
+	for _, name := range names[:len(names)-1] {
+		if seen[name] {
+			continue
+		}
+		if err := a.ship(ctx, server, name, dir); err != nil {
+			return shipped, err
+		}
+		shipped++
+	}
+	return shipped, nil
+}
+
+// PlanReplay works out which archived binlogs take the dump at key up to
+// until, so a restore can give up before loading the dump if they are not
+// available
+func (a *Archiver) PlanReplay(ctx context.Context, server api.Server, key string, until time.Time) (*Replay, error) {
+	manifest := new(Manifest)
+	if err := getJSON(ctx, a.bucket, ManifestKey(key), manifest); err != nil {
+		return nil, fmt.Errorf("reading manifest for %s: %w", key, err)
+	}
+	if manifest.Binlog == nil {
+		return nil, fmt.Errorf("%s has no binlog position, binlog must be set on the server when the dump is taken", key)
+	}
+	if until.Before(manifest.Start) {
+		return nil, fmt.Errorf("%s was taken at %s, after %s", key, manifest.Start.Format(time.RFC3339), until.Format(time.RFC3339))
+	}
+
+	archived, err := a.binlogService.List(server.Id)
+	if err != nil {
+		return nil, err
+	}
+	binlogs, complete, err := replaySet(archived, manifest.Binlog.File, until)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Replay{
+		Server:   server,
+		Database: manifest.Database,
+		Position: *manifest.Binlog,
+		Binlogs:  binlogs,
+		Until:    until,
+		Complete: complete,
+	}, nil
+}
+
+// Replay downloads the binlogs, verifies their checksums and applies the
+// events for the dumped database into target
+func (a *Archiver) Replay(ctx context.Context, replay *Replay, target string) error {
+	dir := filepath.Join(a.dumpDir, replay.Server.Name+"_replay")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	files := make([]string, len(replay.Binlogs))
+	for i, binlog := range replay.Binlogs {
+		files[i] = filepath.Join(dir, binlog.Name)
+		logging.Info(ctx, "Downloading from S3", "bucket", a.bucket, "key", binlog.Key)
+		checksum, _, err := downloadFromS3(ctx, a.bucket, binlog.Key, files[i])
+		if err != nil {
+			return err
+		}
+		if checksum != binlog.SHA256 {
+			return &ChecksumError{Key: binlog.Key, Expected: binlog.SHA256, Actual: checksum}
+		}
+	}
+
+	filename := filepath.Join(dir, "replay.sql")
+	args := replayCmd(replay, target, files)
+	logging.Info(ctx, "Decoding binlogs", "binlogs", len(files), "file", replay.Position.File, "position", replay.Position.Position)
+	if err := runToFile(ctx, args, filename); err != nil {
+		return err
+	}
+
+	logging.Info(ctx, "Replaying binlogs", "target", target, "server", replay.Server.Name, "until", replay.Until.Format(time.RFC3339))
+	return loadDump(ctx, replay.Server, target, filename)
+}
+
+func (a *Archiver) ship(ctx context.Context, server api.Server, name string, dir string) error {
+	filename := filepath.Join(dir, name)
+	defer os.Remove(filename)
+
+	args, err := server.BinlogDumpCmd(name, dir)
+	if err != nil {
+		return err
+	}
+	logging.Info(ctx, "Copying binlog", "binlog", name, "server", server.Name)
+	if _, err := runOutput(ctx, args); err != nil {
+		return err
+	}
+
+	binlog := api.Binlog{
+		ServerId: server.Id,
+		Name:     name,
+		Key:      binlogKey(server, name),
+	}
+	if binlog.SHA256, binlog.Size, binlog.Started, err = describeBinlog(filename); err != nil {
+		return err
+	}
+
+	logging.Info(ctx, "Sending to S3", "bucket", a.bucket, "key", binlog.Key)
+	metadata := map[string]string{checksumMetadata: binlog.SHA256}
+	if err := sendToS3(ctx, a.bucket, filename, binlog.Key, metadata); err != nil {
+		return err
+	}
+
+	return a.binlogService.New(binlog)
+}
+
+// Binlogs are stored beside the server's database directories
+func binlogKey(server api.Server, name string) string {
+	return path.Join(server.Name, "_binlog", name)
+}
+
+var binlogPositionPattern = regexp.MustCompile(`CHANGE (?:MASTER|REPLICATION SOURCE) TO (?:MASTER|SOURCE)_LOG_FILE='([^']+)', (?:MASTER|SOURCE)_LOG_POS=(\d+)`)
+
+// binlogPosition finds the position mysqldump recorded near the top of the
+// dump, returning nil if there is none
+func binlogPosition(filename string) (*BinlogPosition, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	// The statement comes before any table, within the first few dozen lines
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for i := 0; i < 100 && scanner.Scan(); i++ {
+		match := binlogPositionPattern.FindStringSubmatch(scanner.Text())
+		if match == nil {
+			continue
+		}
+		position, err := strconv.ParseInt(match[2], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		return &BinlogPosition{File: match[1], Position: position}, nil
+	}
+	if err := scanner.Err(); err != nil && err != bufio.ErrTooLong {
+		return nil, err
+	}
+	return nil, nil
+}
+
+// describeBinlog returns the hex SHA-256, size and time of the first event
+// of a raw binlog file
+func describeBinlog(filename string) (checksum string, size int64, started time.Time, err error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	if started, err = binlogStarted(file); err != nil {
+		return
+	}
+	if _, err = file.Seek(0, io.SeekStart); err != nil {
+		return
+	}
+
+	hash := sha256.New()
+	if size, err = io.Copy(hash, file); err != nil {
+		return
+	}
+	checksum = hex.EncodeToString(hash.Sum(nil))
+	return
+}
+
+var binlogMagic = []byte{0xfe, 'b', 'i', 'n'}
+
+// binlogStarted reads event headers from the start of a binlog until one
+// carries a timestamp. Artificial events such as the rotate event sent ahead
+// of the file by the server have none.
+func binlogStarted(r io.Reader) (time.Time, error) {
+	magic := make([]byte, len(binlogMagic))
+	if _, err := io.ReadFull(r, magic); err != nil {
+		return time.Time{}, err
+	}
+	if string(magic) != string(binlogMagic) {
+		return time.Time{}, errors.New("not a binlog file")
+	}
+
+	// timestamp(4) type(1) server_id(4) event_size(4) log_pos(4) flags(2)
+	header := make([]byte, 19)
+	for i := 0; i < 10; i++ {
+		if _, err := io.ReadFull(r, header); err != nil {
+			return time.Time{}, err
+		}
+		if timestamp := binary.LittleEndian.Uint32(header[0:4]); timestamp != 0 {
+			return time.Unix(int64(timestamp), 0), nil
+		}
+		size := int64(binary.LittleEndian.Uint32(header[9:13]))
+		if size < int64(len(header)) {
+			return time.Time{}, errors.New("corrupt binlog event header")
+		}
+		if _, err := io.CopyN(ioutil.Discard, r, size-int64(len(header))); err != nil {
+			return time.Time{}, err
+		}
+	}
+	return time.Time{}, errors.New("no timestamped event at the start of the binlog")
+}
+
+// parseBinlogNames takes the file names from SHOW BINARY LOGS output
+func parseBinlogNames(output string) []string {
+	names := make([]string, 0, 100)
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) > 0 {
+			names = append(names, fields[0])
+		}
+	}
+	return names
+}
+
+// replaySet picks the binlogs from start up to the last one beginning before
+// until. A gap in the sequence, from a binlog purged before it was archived,
+// makes recovery impossible.
+func replaySet(binlogs []api.Binlog, start string, until time.Time) (set []api.Binlog, complete bool, err error) {
+	first := -1
+	for i, binlog := range binlogs {
+		if binlog.Name == start {
+			first = i
+			break
+		}
+	}
+	if first < 0 {
+		return nil, false, fmt.Errorf("binlog %s has not been archived", start)
+	}
+
+	set = append(set, binlogs[first])
+	for _, binlog := range binlogs[first+1:] {
+		if binlog.Started.After(until) {
+			return set, true, nil
+		}
+		previous := set[len(set)-1]
+		if binlogSequence(binlog.Name) != binlogSequence(previous.Name)+1 {
+			return nil, false, fmt.Errorf("binlogs between %s and %s are missing from the archive", previous.Name, binlog.Name)
+		}
+		set = append(set, binlog)
+	}
+	return set, false, nil
+}
+
+// binlogSequence returns the number on the end of a binlog name, e.g. 123 for
+// mysql-bin.000123
+func binlogSequence(name string) int {
+	n, _ := strconv.Atoi(name[strings.LastIndex(name, ".")+1:])
+	return n
+}
+
+// replayCmd decodes the binlogs with mysqlbinlog, keeping only events for the
+// dumped database and renaming it when restoring under another name
+func replayCmd(replay *Replay, target string, files []string) []string {
+	cmd := []string{
+		"mysqlbinlog",
+		"--start-position=" + strconv.FormatInt(replay.Position.Position, 10),
+		// mysqlbinlog reads this in the local time zone
+		"--stop-datetime=" + replay.Until.Local().Format("2006-01-02 15:04:05"),
+	}
+	// The rename applies first, so the filter names the target
+	if target != replay.Database {
+		cmd = append(cmd, "--rewrite-db="+replay.Database+"->"+target)
+	}
+	cmd = append(cmd, "--database="+target)
+	return append(cmd, files...)
+}
+
+func runToFile(ctx context.Context, args []string, filename string) error {
+	file, err := os.OpenFile(filename, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	stderr := process.NewTailBuffer(process.StderrLimit)
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdout = file
+	cmd.Stderr = stderr
+	if err := process.Run(ctx, cmd); err != nil {
+		if message := lastLine(stderr.String()); message != "" {
+			return fmt.Errorf("%s: %s: %s", args[0], err, message)
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package backup
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jbaikge/database-backups/pkg/api"
+	"github.com/zeebo/assert"
+)
+
+func TestBinlogPosition(t *testing.T) {
+	dir, err := ioutil.TempDir("", "binlog")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		head     string
+		expected *BinlogPosition
+	}{
+		{
+			head:     "--\n-- Position to start replication or point-in-time recovery from\n--\n\n-- CHANGE MASTER TO MASTER_LOG_FILE='mysql-bin.000123', MASTER_LOG_POS=4567;\n",
+			expected: &BinlogPosition{File: "mysql-bin.000123", Position: 4567},
+		},
+		{
+			head:     "-- CHANGE REPLICATION SOURCE TO SOURCE_LOG_FILE='binlog.000007', SOURCE_LOG_POS=157;\n",
+			expected: &BinlogPosition{File: "binlog.000007", Position: 157},
+		},
+		{
+			head: "CREATE TABLE a (id INT);\n",
+		},
+	}
+	for _, test := range tests {
+		path := filepath.Join(dir, "dump.sql")
+		assert.Nil(t, ioutil.WriteFile(path, []byte(test.head), 0600))
+		position, err := binlogPosition(path)
+		assert.Nil(t, err)
+		assert.DeepEqual(t, position, test.expected)
+	}
+}
+
+func TestBinlogStarted(t *testing.T) {
+	event := func(timestamp uint32, size uint32) []byte {
+		header := make([]byte, 19)
+		binary.LittleEndian.PutUint32(header[0:4], timestamp)
+		binary.LittleEndian.PutUint32(header[9:13], size)
+		return append(header, make([]byte, size-19)...)
+	}
+
+	var buf bytes.Buffer
+	buf.Write(binlogMagic)
+	buf.Write(event(0, 44))
+	buf.Write(event(1642122902, 120))
+
+	started, err := binlogStarted(&buf)
+	assert.Nil(t, err)
+	assert.Equal(t, started.Unix(), int64(1642122902))
+
+	_, err = binlogStarted(bytes.NewReader([]byte("-- MySQL dump")))
+	assert.Error(t, err)
+}
+
+func TestReplaySet(t *testing.T) {
+	day := time.Date(2022, 1, 14, 0, 0, 0, 0, time.UTC)
+	binlogs := []api.Binlog{
+		{Name: "mysql-bin.000008", Started: day.Add(-time.Hour)},
+		{Name: "mysql-bin.000009", Started: day.Add(1 * time.Hour)},
+		{Name: "mysql-bin.000010", Started: day.Add(2 * time.Hour)},
+		{Name: "mysql-bin.000011", Started: day.Add(3 * time.Hour)},
+	}
+
+	set, complete, err := replaySet(binlogs, "mysql-bin.000009", day.Add(150*time.Minute))
+	assert.Nil(t, err)
+	assert.That(t, complete)
+	assert.Equal(t, len(set), 2)
+	assert.Equal(t, set[1].Name, "mysql-bin.000010")
+
+	set, complete, err = replaySet(binlogs, "mysql-bin.000009", day.Add(4*time.Hour))
+	assert.Nil(t, err)
+	assert.False(t, complete)
+	assert.Equal(t, len(set), 3)
+
+	_, _, err = replaySet(binlogs, "mysql-bin.000012", day)
+	assert.Error(t, err)
+
+	gap := append(binlogs[:2:2], binlogs[3])
+	_, _, err = replaySet(gap, "mysql-bin.000008", day.Add(4*time.Hour))
+	assert.Error(t, err)
+}
+
+func TestReplayCmd(t *testing.T) {
+	replay := &Replay{
+		Database: "orders",
+		Position: BinlogPosition{File: "mysql-bin.000009", Position: 4567},
+		Until:    time.Date(2022, 1, 14, 15, 4, 5, 0, time.Local),
+	}
+
+	args := replayCmd(replay, "orders_copy", []string{"a", "b"})
+	assert.DeepEqual(t, args, []string{
+		"mysqlbinlog",
+		"--start-position=4567",
+		"--stop-datetime=2022-01-14 15:04:05",
+		"--rewrite-db=orders->orders_copy",
+		"--database=orders_copy",
+		"a",
+		"b",
+	})
+
+	args = replayCmd(replay, "orders", nil)
+	assert.Equal(t, args[len(args)-1], "--database=orders")
+}
@@ -0,0 +1,109 @@
+package backup
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/jbaikge/database-backups/pkg/process"
+)
+
+// DumpError describes a dump that exited unsuccessfully, or the database list
+// taken before it when Database is empty. Command has the password redacted
+// and Stderr holds the tail of the error output.
+type DumpError struct {
+	Server   string
+	Database string
+	Command  []string
+	ExitCode int
+	Stderr   string
+	// MySQL error code found in Stderr, zero when none was recognised
+	Code int
+	Err  error
+}
+
+func (e *DumpError) Error() string {
+	var b strings.Builder
+	if e.Database == "" {
+		fmt.Fprintf(&b, "listing databases on %s", e.Server)
+	} else {
+		fmt.Fprintf(&b, "dumping %s/%s", e.Server, e.Database)
+	}
+	if e.ExitCode != 0 {
+		fmt.Fprintf(&b, ": exit status %d", e.ExitCode)
+	} else {
+		fmt.Fprintf(&b, ": %s", e.Err)
+	}
+	if info, ok := mysqlErrors[e.Code]; ok {
+		fmt.Fprintf(&b, ": MySQL error %d (%s)", e.Code, info.name)
+	}
+	if message := lastLine(e.Stderr); message != "" {
+		fmt.Fprintf(&b, ": %s", message)
+	}
+	return b.String()
+}
+
+func (e *DumpError) Unwrap() error {
+	return e.Err
+}
+
+// Temporary reports whether retrying may help, based on the MySQL error code
+// when one was found and the underlying process error otherwise
+func (e *DumpError) Temporary() bool {
+	if info, ok := mysqlErrors[e.Code]; ok {
+		return info.temporary
+	}
+	var exitErr *process.ExitError
+	return errors.As(e.Err, &exitErr) && exitErr.Temporary()
+}
+
+type mysqlError struct {
+	name      string
+	temporary bool
+}
+
+var mysqlErrors = map[int]mysqlError{
+	1040: {"too many connections", true},
+	1044: {"access denied to database", false},
+	1045: {"access denied", false},
+	1049: {"unknown database", false},
+	1146: {"table does not exist", false},
+	1205: {"lock wait timeout", true},
+	1213: {"deadlock", true},
+	1227: {"missing privilege", false},
+	2002: {"cannot connect through socket", true},
+	2003: {"cannot connect", true},
+	2005: {"unknown host", false},
+	2006: {"server has gone away", true},
+	2013: {"lost connection", true},
+}
+
+// Matches "Got error: 1045:", "Error 2013:", "ERROR 1049 (42000):" and
+// "Couldn't execute '...': message (1146)"
+var (
+	mysqlErrorPrefix = regexp.MustCompile(`(?i)error:? (\d{4,5})\b`)
+	mysqlErrorSuffix = regexp.MustCompile(`\((\d{4,5})\)\s*$`)
+)
+
+// parseMySQLError returns the first MySQL error code found in stderr
+func parseMySQLError(stderr string) int {
+	for _, line := range strings.Split(stderr, "\n") {
+		line = strings.TrimSpace(line)
+		if m := mysqlErrorPrefix.FindStringSubmatch(line); m != nil {
+			code, _ := strconv.Atoi(m[1])
+			return code
+		}
+		if m := mysqlErrorSuffix.FindStringSubmatch(line); m != nil {
+			code, _ := strconv.Atoi(m[1])
+			return code
+		}
+	}
+	return 0
+}
+
+func lastLine(s string) string {
+	lines := strings.Split(strings.TrimSpace(s), "\n")
+	return strings.TrimSpace(lines[len(lines)-1])
+}
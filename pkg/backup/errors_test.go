@@ -0,0 +1,79 @@
+package backup
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/jbaikge/database-backups/pkg/api"
+	"github.com/jbaikge/database-backups/pkg/process"
+	"github.com/zeebo/assert"
+)
+
+func TestParseMySQLError(t *testing.T) {
+	tests := []struct {
+		stderr string
+		code   int
+	}{
+		{"mysqldump: [Warning] Using a password on the command line interface can be insecure.\nmysqldump: Got error: 1045: Access denied for user 'backup'@'10.0.0.2' (using password: YES) when trying to connect\n", 1045},
+		{"mysqldump: Got error: 1049: Unknown database 'orders' when selecting the database", 1049},
+		{"mysqldump: Error 2013: Lost connection to MySQL server during query when dumping table `events` at row: 5063", 2013},
+		{"ERROR 2003 (HY000): Can't connect to MySQL server on '10.0.0.1:3306' (111)", 2003},
+		{"mysqldump: Couldn't execute 'SHOW FIELDS FROM `v`': View 'db.v' references invalid table(s) (1356)", 1356},
+		{"ssh: connect to host bastion port 22: Connection refused", 0},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, parseMySQLError(test.stderr), test.code)
+	}
+}
+
+func TestDumpErrorTemporary(t *testing.T) {
+	exitErr := &process.ExitError{Name: "mysqldump", ExitCode: 2, Err: errors.New("exit status 2")}
+
+	auth := &DumpError{Server: "db1", Database: "orders", ExitCode: 2, Code: 1045, Stderr: "mysqldump: Got error: 1045: Access denied", Err: exitErr}
+	assert.False(t, auth.Temporary())
+	assert.That(t, strings.Contains(auth.Error(), "MySQL error 1045"))
+
+	lost := &DumpError{Code: 2013, Err: exitErr}
+	assert.That(t, lost.Temporary())
+
+	ssh := &DumpError{Err: &process.ExitError{Name: "ssh", ExitCode: 255, Err: errors.New("exit status 255")}}
+	assert.That(t, ssh.Temporary())
+}
+
+func TestTailBuffer(t *testing.T) {
+	buf := process.NewTailBuffer(8)
+	buf.Write([]byte("abcdef"))
+	assert.False(t, buf.Truncated())
+	buf.Write([]byte("ghij"))
+	assert.Equal(t, buf.String(), "cdefghij")
+	assert.That(t, buf.Truncated())
+	buf.Write([]byte("0123456789"))
+	assert.Equal(t, buf.String(), "23456789")
+}
+
+func TestRefreshError(t *testing.T) {
+	server := api.Server{Name: "db1", Host: "10.0.0.1", Username: "backup", Password: "secret"}
+
+	refused := refreshError(server, &process.ExitError{
+		Name:     "mysql",
+		ExitCode: 1,
+		Stderr:   "ERROR 2003 (HY000): Can't connect to MySQL server on '10.0.0.1:3306' (111)\n",
+		Err:      errors.New("exit status 1"),
+	})
+	assert.That(t, Retryable(refused))
+	assert.That(t, strings.HasPrefix(refused.Error(), "listing databases on db1"))
+	assert.False(t, strings.Contains(strings.Join(refused.(*DumpError).Command, " "), "secret"))
+
+	denied := refreshError(server, &process.ExitError{
+		Name:     "mysql",
+		ExitCode: 1,
+		Stderr:   "ERROR 1045 (28000): Access denied for user 'backup'@'10.0.0.2' (using password: YES)\n",
+		Err:      errors.New("exit status 1"),
+	})
+	assert.False(t, Retryable(denied))
+
+	other := errors.New("no such server")
+	assert.Equal(t, refreshError(server, other), other)
+}
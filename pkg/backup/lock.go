@@ -0,0 +1,76 @@
+package backup
+
+import (
+	"bufio"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/jbaikge/database-backups/pkg/api"
+)
+
+// fileLock is an exclusive flock on a file in the dump directory. The kernel
+// drops the lock when the holding process exits, so it never goes stale; the
+// PID and start time written inside are only there for the error message.
+type fileLock struct {
+	file *os.File
+}
+
+// lockFilename names the flock file for a lock on the given names. They are
+// escaped, underscores included, so none can leave the dump directory and no
+// two lists of names share a file.
+func lockFilename(names ...string) string {
+	escaped := make([]string, len(names))
+	for i, name := range names {
+		escaped[i] = strings.ReplaceAll(url.PathEscape(name), "_", "%5F")
+	}
+	return strings.Join(escaped, "_") + ".lock"
+}
+
+func lockFile(path string, name string) (*fileLock, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		defer file.Close()
+		if err != syscall.EWOULDBLOCK {
+			return nil, err
+		}
+
+		hostname, _ := os.Hostname()
+		lockErr := &api.LockError{Name: name, Hostname: hostname}
+		scanner := bufio.NewScanner(file)
+		if scanner.Scan() {
+			lockErr.Pid, _ = strconv.Atoi(scanner.Text())
+		}
+		if scanner.Scan() {
+			lockErr.Since, _ = time.Parse(time.RFC3339, scanner.Text())
+		}
+		return nil, lockErr
+	}
+
+	if err := file.Truncate(0); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if _, err := fmt.Fprintf(file, "%d\n%s\n", os.Getpid(), time.Now().Format(time.RFC3339)); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return &fileLock{file: file}, nil
+}
+
+func (l *fileLock) Unlock() error {
+	if err := l.file.Truncate(0); err != nil {
+		l.file.Close()
+		return err
+	}
+	return l.file.Close()
+}
@@ -0,0 +1,14 @@
+package backup
+
+import (
+	"testing"
+
+	"github.com/zeebo/assert"
+)
+
+func TestLockFilename(t *testing.T) {
+	assert.Equal(t, lockFilename("db1", "shop"), "db1_shop.lock")
+	assert.Equal(t, lockFilename("../etc", "passwd"), "..%2Fetc_passwd.lock")
+	assert.That(t, lockFilename("a_b", "c") != lockFilename("a", "b_c"))
+	assert.That(t, lockFilename("a%5Fb", "c") != lockFilename("a_b", "c"))
+}
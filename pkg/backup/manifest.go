@@ -0,0 +1,145 @@
+package backup
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jbaikge/database-backups/pkg/api"
+	"github.com/jbaikge/database-backups/pkg/process"
+)
+
+const manifestSuffix = ".manifest.json"
+
+// Manifest is stored next to every dump and describes what went into it.
+// Partial is set when any table was limited by a WHERE predicate.
+type Manifest struct {
+	Server          string            `json:"server"`
+	Database        string            `json:"database"`
+	Engine          string            `json:"engine"`
+	DumpMode        string            `json:"dump_mode"`
+	Key             string            `json:"key"`
+	Size            int64             `json:"size"`
+	SHA256          string            `json:"sha256"`
+	Objects         []ManifestObject  `json:"objects,omitempty"`
+	ToolVersions    map[string]string `json:"tool_versions"`
+	Tables          []ManifestTable   `json:"tables"`
+	Partial         bool              `json:"partial"`
+	Compression     string            `json:"compression"`
+	Encryption      string            `json:"encryption"`
+	EncryptionKeyId string            `json:"encryption_key_id"`
+	Binlog          *BinlogPosition   `json:"binlog,omitempty"`
+	Start           time.Time         `json:"start"`
+	End             time.Time         `json:"end"`
+}
+
+// ManifestObject is one part of a per-table dump. The schema has no table.
+type ManifestObject struct {
+	Table  string `json:"table,omitempty"`
+	Key    string `json:"key"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+type ManifestTable struct {
+	Name string `json:"name"`
+	// Estimate from information_schema, exact only for MyISAM
+	Rows int64 `json:"rows"`
+	// full, schema or skip
+	Mode string `json:"mode"`
+	// Pattern of the rule deciding the mode, if any
+	Rule string `json:"rule,omitempty"`
+	// Predicate the dumped rows were limited to, with dates filled in
+	Where string `json:"where,omitempty"`
+}
+
+// filteredTables returns the names of the tables limited by a WHERE
+// predicate
+func (m *Manifest) filteredTables() []string {
+	names := make([]string, 0, len(m.Tables))
+	for _, table := range m.Tables {
+		if table.Where != "" {
+			names = append(names, table.Name)
+		}
+	}
+	return names
+}
+
+func ManifestKey(key string) string {
+	return key + manifestSuffix
+}
+
+func isManifestKey(key string) bool {
+	return strings.HasSuffix(key, manifestSuffix)
+}
+
+// describe fills in the tool versions. These are informational, so failures
+// are logged rather than failing the backup.
+func (m *Manifest) describe(ctx context.Context, server api.Server) error {
+	m.ToolVersions = make(map[string]string)
+
+	args, err := server.DumpVersionCmd()
+	if err != nil {
+		return err
+	}
+	output, err := runOutput(ctx, args)
+	if err != nil {
+		return err
+	}
+	m.ToolVersions["mysqldump"] = strings.TrimSpace(output)
+
+	if args, err = server.ServerVersionCmd(); err != nil {
+		return err
+	}
+	if output, err = runOutput(ctx, args); err != nil {
+		return err
+	}
+	m.ToolVersions["server"] = strings.TrimSpace(output)
+
+	return nil
+}
+
+func parseTables(output string) []ManifestTable {
+	tables := make([]ManifestTable, 0, 100)
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), "\t")
+		if fields[0] == "" {
+			continue
+		}
+		table := ManifestTable{Name: fields[0]}
+		if len(fields) > 1 {
+			table.Rows, _ = strconv.ParseInt(fields[1], 10, 64)
+		}
+		tables = append(tables, table)
+	}
+	return tables
+}
+
+func runOutput(ctx context.Context, args []string) (string, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stderr = &stderr
+	output, err := process.Output(ctx, cmd)
+	if err != nil && stderr.Len() > 0 {
+		return "", &commandError{err: err, stderr: lastLine(stderr.String())}
+	}
+	return string(output), err
+}
+
+type commandError struct {
+	err    error
+	stderr string
+}
+
+func (e *commandError) Error() string {
+	return e.err.Error() + ": " + e.stderr
+}
+
+func (e *commandError) Unwrap() error {
+	return e.err
+}
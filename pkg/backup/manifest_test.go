@@ -0,0 +1,24 @@
+package backup
+
+import (
+	"testing"
+
+	"github.com/zeebo/assert"
+)
+
+func TestParseTables(t *testing.T) {
+	output := "customers\t1200\norders\tNULL\n\nviews_only\n"
+	tables := parseTables(output)
+	assert.DeepEqual(t, tables, []ManifestTable{
+		{Name: "customers", Rows: 1200},
+		{Name: "orders", Rows: 0},
+		{Name: "views_only", Rows: 0},
+	})
+}
+
+func TestManifestKey(t *testing.T) {
+	key := ManifestKey("db1/orders/db1_orders_2022-01-14.sql")
+	assert.Equal(t, key, "db1/orders/db1_orders_2022-01-14.sql.manifest.json")
+	assert.That(t, isManifestKey(key))
+	assert.That(t, !isManifestKey("db1/orders/db1_orders_2022-01-14.sql"))
+}
@@ -0,0 +1,267 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/jbaikge/database-backups/pkg/api"
+)
+
+// Dumps are uploaded as mysqldump writes them, neither compressed nor
+// encrypted on the way
+const (
+	dumpCompression = "none"
+	dumpEncryption  = "none"
+)
+
+// Shown for what could not be read from the bucket
+const unknown = "unknown"
+
+// Plan describes everything a run would do without doing any of it. Commands
+// are built from redacted servers so no passwords are decrypted.
+type Plan struct {
+	Refresh []RefreshStep `json:"refresh"`
+	Backups []BackupStep  `json:"backups"`
+}
+
+type RefreshStep struct {
+	Server  string   `json:"server"`
+	Command []string `json:"command"`
+}
+
+type BackupStep struct {
+	Server      string     `json:"server"`
+	Database    string     `json:"database"`
+	DumpMode    string     `json:"dump_mode"`
+	Commands    [][]string `json:"commands"`
+	Destination string     `json:"destination"`
+	Compression string     `json:"compression"`
+	Encryption  string     `json:"encryption"`
+	Retention   string     `json:"retention"`
+}
+
+// Bucket holds what the bucket does to uploaded objects: its default
+// encryption and lifecycle rules
+type Bucket struct {
+	Encryption string
+	Rules      []*s3.LifecycleRule
+}
+
+func (r *Runner) PlanRefresh(server api.Server) (RefreshStep, error) {
+	cmd, err := server.Redacted().DatabaseListCmd()
+	if err != nil {
+		return RefreshStep{}, err
+	}
+
+	return RefreshStep{
+		Server:  server.Name,
+		Command: cmd,
+	}, nil
+}
+
+// PlanBackup shows the commands for a single dump, or the schema command for
+// a per-table dump as the tables are only known once it runs. Table rules
+// appear as written since patterns are only resolved against the server's
+// tables when the dump runs. Encryption and retention come from bucket, and
+// are unknown when it is nil.
+func (r *Runner) PlanBackup(server api.Server, database api.Database, bucket *Bucket) (BackupStep, error) {
+	redacted := server.Redacted()
+	key := server.S3Key(database)
+
+	var cmds [][]string
+	var err error
+	if database.DumpMode == api.DumpModePerTable {
+		key = tablesKey(server, database) + "/"
+		var cmd []string
+		cmd, err = redacted.SchemaDumpCmd(database)
+		cmds = [][]string{cmd}
+	} else {
+		cmds, err = redacted.DatabaseDumpCmds(database)
+	}
+	if err != nil {
+		return BackupStep{}, err
+	}
+
+	step := BackupStep{
+		Server:      server.Name,
+		Database:    database.Name,
+		DumpMode:    database.DumpMode,
+		Commands:    cmds,
+		Destination: fmt.Sprintf("s3://%s/%s", r.bucket, key),
+		Compression: dumpCompression,
+		Encryption:  unknown,
+		Retention:   unknown,
+	}
+	if bucket != nil {
+		step.Encryption = bucket.Encryption
+		step.Retention = bucket.Retention(key)
+	}
+	return step, nil
+}
+
+// LoadBucket reads the bucket's default encryption and lifecycle rules
+func LoadBucket(ctx context.Context, bucket string) (*Bucket, error) {
+	sess, err := session.NewSession()
+	if err != nil {
+		return nil, err
+	}
+
+	return loadBucket(ctx, s3.New(sess), bucket)
+}
+
+func loadBucket(ctx context.Context, client s3iface.S3API, name string) (*Bucket, error) {
+	// Objects are stored as uploaded unless the bucket encrypts them
+	bucket := &Bucket{Encryption: "none"}
+
+	encryption, err := client.GetBucketEncryptionWithContext(ctx, &s3.GetBucketEncryptionInput{Bucket: aws.String(name)})
+	switch {
+	case errorCode(err) == "ServerSideEncryptionConfigurationNotFoundError":
+	case err != nil:
+		return nil, err
+	case encryption.ServerSideEncryptionConfiguration != nil:
+		for _, rule := range encryption.ServerSideEncryptionConfiguration.Rules {
+			if rule.ApplyServerSideEncryptionByDefault == nil {
+				continue
+			}
+			bucket.Encryption = aws.StringValue(rule.ApplyServerSideEncryptionByDefault.SSEAlgorithm)
+			if key := aws.StringValue(rule.ApplyServerSideEncryptionByDefault.KMSMasterKeyID); key != "" {
+				bucket.Encryption += " " + key
+			}
+		}
+	}
+
+	lifecycle, err := client.GetBucketLifecycleConfigurationWithContext(ctx, &s3.GetBucketLifecycleConfigurationInput{Bucket: aws.String(name)})
+	switch {
+	case errorCode(err) == "NoSuchLifecycleConfiguration":
+	case err != nil:
+		return nil, err
+	default:
+		bucket.Rules = lifecycle.Rules
+	}
+
+	return bucket, nil
+}
+
+// Retention describes what the bucket's enabled lifecycle rules do to the
+// objects under key, or keep when nothing does. Rules filtering on tags never
+// apply as uploads are not tagged; size limits are not considered.
+func (b *Bucket) Retention(key string) string {
+	var actions []string
+	for _, rule := range b.Rules {
+		if aws.StringValue(rule.Status) != s3.ExpirationStatusEnabled || !ruleApplies(rule, key) {
+			continue
+		}
+		for _, transition := range rule.Transitions {
+			actions = append(actions, aws.StringValue(transition.StorageClass)+" "+lifecycleWhen(transition.Days, transition.Date))
+		}
+		if expiration := rule.Expiration; expiration != nil && (expiration.Days != nil || expiration.Date != nil) {
+			actions = append(actions, "expire "+lifecycleWhen(expiration.Days, expiration.Date))
+		}
+	}
+	if len(actions) == 0 {
+		return "keep"
+	}
+	return strings.Join(actions, ", ")
+}
+
+func ruleApplies(rule *s3.LifecycleRule, key string) bool {
+	prefix := aws.StringValue(rule.Prefix)
+	if filter := rule.Filter; filter != nil {
+		if filter.Tag != nil || (filter.And != nil && len(filter.And.Tags) > 0) {
+			return false
+		}
+		prefix = aws.StringValue(filter.Prefix)
+		if filter.And != nil {
+			prefix = aws.StringValue(filter.And.Prefix)
+		}
+	}
+	return strings.HasPrefix(key, prefix)
+}
+
+func lifecycleWhen(days *int64, date *time.Time) string {
+	if date != nil {
+		return "on " + date.Format("2006-01-02")
+	}
+	return fmt.Sprintf("after %d days", aws.Int64Value(days))
+}
+
+func errorCode(err error) string {
+	var awsErr awserr.Error
+	if errors.As(err, &awsErr) {
+		return awsErr.Code()
+	}
+	return ""
+}
+
+func (p Plan) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(p)
+}
+
+func (p Plan) WriteTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+
+	fmt.Fprintln(tw, "SERVER\tREFRESH COMMAND")
+	for _, step := range p.Refresh {
+		fmt.Fprintf(tw, "%s\t%s\n", step.Server, shellJoin(step.Command))
+	}
+	fmt.Fprintln(tw)
+
+	fmt.Fprintln(tw, "SERVER\tDATABASE\tMODE\tDESTINATION\tCOMPRESSION\tENCRYPTION\tRETENTION\tCOMMAND")
+	for _, step := range p.Backups {
+		fmt.Fprintf(
+			tw,
+			"%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			step.Server,
+			step.Database,
+			step.DumpMode,
+			step.Destination,
+			step.Compression,
+			step.Encryption,
+			step.Retention,
+			joinCmds(step.Commands),
+		)
+	}
+
+	return tw.Flush()
+}
+
+// joinCmds renders commands run one after another into the same file
+func joinCmds(cmds [][]string) string {
+	joined := make([]string, len(cmds))
+	for i, cmd := range cmds {
+		joined[i] = shellJoin(cmd)
+	}
+	return strings.Join(joined, " && ")
+}
+
+// shellJoin renders a command for reading. Arguments are quoted as a shell
+// would need them, except those following an ssh destination, which are
+// already quoted for the remote shell.
+func shellJoin(args []string) string {
+	quoted := make([]string, len(args))
+	remote := len(args)
+	if len(args) > 0 && args[0] == "ssh" {
+		remote = 4
+	}
+	for i, arg := range args {
+		if i >= remote {
+			quoted[i] = arg
+			continue
+		}
+		quoted[i] = api.ShellQuote(arg)
+	}
+	return strings.Join(quoted, " ")
+}
@@ -0,0 +1,102 @@
+package backup
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/jbaikge/database-backups/pkg/api"
+	"github.com/zeebo/assert"
+)
+
+// bucketS3 answers the bucket lookups, with a nil configuration answered by
+// S3's not-found error
+type bucketS3 struct {
+	s3iface.S3API
+	encryption *s3.ServerSideEncryptionConfiguration
+	rules      []*s3.LifecycleRule
+}
+
+func (b bucketS3) GetBucketEncryptionWithContext(ctx aws.Context, input *s3.GetBucketEncryptionInput, opts ...request.Option) (*s3.GetBucketEncryptionOutput, error) {
+	if b.encryption == nil {
+		return nil, awserr.New("ServerSideEncryptionConfigurationNotFoundError", "", nil)
+	}
+	return &s3.GetBucketEncryptionOutput{ServerSideEncryptionConfiguration: b.encryption}, nil
+}
+
+func (b bucketS3) GetBucketLifecycleConfigurationWithContext(ctx aws.Context, input *s3.GetBucketLifecycleConfigurationInput, opts ...request.Option) (*s3.GetBucketLifecycleConfigurationOutput, error) {
+	if b.rules == nil {
+		return nil, awserr.New("NoSuchLifecycleConfiguration", "", nil)
+	}
+	return &s3.GetBucketLifecycleConfigurationOutput{Rules: b.rules}, nil
+}
+
+func TestLoadBucket(t *testing.T) {
+	bucket, err := loadBucket(context.Background(), bucketS3{}, "backups")
+	assert.NoError(t, err)
+	assert.Equal(t, bucket.Encryption, "none")
+	assert.Equal(t, bucket.Retention("db1/shop.sql"), "keep")
+
+	enabled := aws.String(s3.ExpirationStatusEnabled)
+	bucket, err = loadBucket(context.Background(), bucketS3{
+		encryption: &s3.ServerSideEncryptionConfiguration{Rules: []*s3.ServerSideEncryptionRule{{
+			ApplyServerSideEncryptionByDefault: &s3.ServerSideEncryptionByDefault{
+				SSEAlgorithm:   aws.String(s3.ServerSideEncryptionAwsKms),
+				KMSMasterKeyID: aws.String("alias/backups"),
+			},
+		}}},
+		rules: []*s3.LifecycleRule{
+			{
+				Status:      enabled,
+				Filter:      &s3.LifecycleRuleFilter{Prefix: aws.String("db1/")},
+				Transitions: []*s3.Transition{{Days: aws.Int64(30), StorageClass: aws.String(s3.TransitionStorageClassGlacier)}},
+				Expiration:  &s3.LifecycleExpiration{Days: aws.Int64(365)},
+			},
+			{
+				Status:     aws.String(s3.ExpirationStatusDisabled),
+				Expiration: &s3.LifecycleExpiration{Days: aws.Int64(1)},
+			},
+			{
+				Status:     enabled,
+				Filter:     &s3.LifecycleRuleFilter{Tag: &s3.Tag{Key: aws.String("temporary"), Value: aws.String("true")}},
+				Expiration: &s3.LifecycleExpiration{Days: aws.Int64(1)},
+			},
+			{
+				Status:     enabled,
+				Prefix:     aws.String("db2/"),
+				Expiration: &s3.LifecycleExpiration{Date: aws.Time(time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC))},
+			},
+		},
+	}, "backups")
+	assert.NoError(t, err)
+	assert.Equal(t, bucket.Encryption, "aws:kms alias/backups")
+	assert.Equal(t, bucket.Retention("db1/shop.sql"), "GLACIER after 30 days, expire after 365 days")
+	assert.Equal(t, bucket.Retention("db2/crm.sql"), "expire on 2030-01-01")
+	assert.Equal(t, bucket.Retention("db3/crm.sql"), "keep")
+}
+
+func TestPlanBackup(t *testing.T) {
+	runner := &Runner{bucket: "backups"}
+	server := api.Server{Name: "db1", Host: "db1", Port: 3306, Username: "backup"}
+	database := api.Database{Name: "shop"}
+
+	step, err := runner.PlanBackup(server, database, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, step.Compression, "none")
+	assert.Equal(t, step.Encryption, "unknown")
+	assert.Equal(t, step.Retention, "unknown")
+
+	bucket := &Bucket{Encryption: "AES256", Rules: []*s3.LifecycleRule{{
+		Status:     aws.String(s3.ExpirationStatusEnabled),
+		Expiration: &s3.LifecycleExpiration{Days: aws.Int64(90)},
+	}}}
+	step, err = runner.PlanBackup(server, database, bucket)
+	assert.NoError(t, err)
+	assert.Equal(t, step.Encryption, "AES256")
+	assert.Equal(t, step.Retention, "expire after 90 days")
+}
@@ -0,0 +1,74 @@
+package backup
+
+import (
+	"context"
+	"sync"
+
+	"github.com/jbaikge/database-backups/pkg/api"
+	"github.com/jbaikge/database-backups/pkg/logging"
+)
+
+type Job struct {
+	Server   api.Server
+	Database api.Database
+}
+
+// Queue runs backup jobs one at a time. A database can only be queued once;
+// enqueueing it again while it is pending or running is a no-op.
+type Queue struct {
+	runner  *Runner
+	jobs    chan Job
+	mu      sync.Mutex
+	pending map[int]bool
+}
+
+func NewQueue(runner *Runner, size int) *Queue {
+	return &Queue{
+		runner:  runner,
+		jobs:    make(chan Job, size),
+		pending: make(map[int]bool),
+	}
+}
+
+// Enqueue adds a job to the queue and reports whether it was accepted
+func (q *Queue) Enqueue(job Job) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.pending[job.Database.Id] {
+		return false
+	}
+
+	select {
+	case q.jobs <- job:
+		q.pending[job.Database.Id] = true
+		return true
+	default:
+		return false
+	}
+}
+
+func (q *Queue) Len() int {
+	return len(q.jobs)
+}
+
+// Run processes jobs until the context is cancelled. Each job is logged under
+// its own job_id.
+func (q *Queue) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case job := <-q.jobs:
+			jobCtx := logging.With(ctx, "job_id", logging.NewID())
+			logging.Info(jobCtx, "Backing up", "server", job.Server.Name, "database", job.Database.Name)
+			if err := q.runner.Backup(jobCtx, job.Server, job.Database); err != nil {
+				logging.Error(jobCtx, "Backup failed", "server", job.Server.Name, "database", job.Database.Name, "error", err)
+			}
+
+			q.mu.Lock()
+			delete(q.pending, job.Database.Id)
+			q.mu.Unlock()
+		}
+	}
+}
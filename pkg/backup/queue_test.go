@@ -0,0 +1,36 @@
+package backup
+
+import (
+	"testing"
+
+	"github.com/jbaikge/database-backups/pkg/api"
+	"github.com/zeebo/assert"
+)
+
+func TestQueueEnqueue(t *testing.T) {
+	q := NewQueue(nil, 2)
+	shop := Job{Database: api.Database{Id: 1, Name: "shop"}}
+	crm := Job{Database: api.Database{Id: 2, Name: "crm"}}
+
+	assert.True(t, q.Enqueue(shop))
+	assert.False(t, q.Enqueue(shop))
+	assert.Equal(t, q.Len(), 1)
+
+	// Run takes the job off the channel but keeps it pending until the
+	// backup returns
+	<-q.jobs
+	assert.False(t, q.Enqueue(shop))
+	assert.Equal(t, q.Len(), 0)
+
+	q.mu.Lock()
+	delete(q.pending, shop.Database.Id)
+	q.mu.Unlock()
+	assert.True(t, q.Enqueue(shop))
+	assert.True(t, q.Enqueue(crm))
+
+	// A full queue turns jobs away without marking them pending
+	other := Job{Database: api.Database{Id: 3, Name: "wiki"}}
+	assert.False(t, q.Enqueue(other))
+	<-q.jobs
+	assert.True(t, q.Enqueue(other))
+}
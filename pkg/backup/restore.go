@@ -0,0 +1,250 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/jbaikge/database-backups/pkg/api"
+	"github.com/jbaikge/database-backups/pkg/logging"
+	"github.com/jbaikge/database-backups/pkg/process"
+)
+
+// ChecksumError means a downloaded dump does not match the checksum recorded
+// when it was uploaded
+type ChecksumError struct {
+	Key      string
+	Expected string
+	Actual   string
+}
+
+func (e *ChecksumError) Error() string {
+	return fmt.Sprintf("%s: checksum mismatch, expected %s, got %s", e.Key, e.Expected, e.Actual)
+}
+
+type VerifyResult struct {
+	Key string
+	Err error
+}
+
+// RestoreOptions controls where and how much of a dump is restored
+type RestoreOptions struct {
+	// Database to load into
+	Target string
+	// Restore one table from a per-table dump instead of everything
+	Table string
+	// Tables of a per-table dump loaded at once
+	Parallel int
+}
+
+// FindKey returns the most recent backup of a database, or the one taken on
+// date (formatted 2006-01-02) when given. Backups are found by their
+// manifests, or by the dump itself for those taken before manifests were
+// written.
+func (r *Runner) FindKey(ctx context.Context, server api.Server, database api.Database, date string) (string, error) {
+	prefix := path.Dir(server.S3Key(database)) + "/"
+	keys, err := listKeys(ctx, r.bucket, prefix)
+	if err != nil {
+		return "", err
+	}
+
+	found := ""
+	for _, key := range keys {
+		// Objects belonging to per-table dumps
+		if strings.Contains(strings.TrimPrefix(key, prefix), "/") {
+			continue
+		}
+		key = strings.TrimSuffix(key, manifestSuffix)
+		if date != "" && !strings.Contains(path.Base(key), "_"+date) {
+			continue
+		}
+		if key > found {
+			found = key
+		}
+	}
+	if found == "" {
+		return "", fmt.Errorf("no backup found under s3://%s/%s", r.bucket, prefix)
+	}
+	return found, nil
+}
+
+// Restore downloads a backup, verifies its checksums and loads it into the
+// target database on the server
+func (r *Runner) Restore(ctx context.Context, server api.Server, key string, options RestoreOptions) error {
+	if err := os.MkdirAll(r.dumpDir, 0755); err != nil {
+		return err
+	}
+
+	manifest, err := r.manifest(ctx, key)
+	if err != nil {
+		return err
+	}
+	if manifest != nil && manifest.Partial {
+		logging.Warn(ctx, "Backup is partial, only some rows were dumped", "key", key, "tables", strings.Join(manifest.filteredTables(), ", "))
+	}
+	if manifest != nil && manifest.DumpMode == api.DumpModePerTable {
+		return r.restoreTables(ctx, server, manifest, options)
+	}
+	if options.Table != "" {
+		return fmt.Errorf("%s is a single dump, restoring one table needs a per-table dump", key)
+	}
+
+	filename := filepath.Join(r.dumpDir, path.Base(key))
+	defer os.Remove(filename)
+
+	logging.Info(ctx, "Downloading from S3", "bucket", r.bucket, "key", key)
+	if err := r.fetch(ctx, key, manifestChecksum(manifest), filename); err != nil {
+		return err
+	}
+
+	logging.Info(ctx, "Loading dump", "file", path.Base(key), "target", options.Target, "server", server.Name)
+	return loadDump(ctx, server, options.Target, filename)
+}
+
+// Verify downloads every object of a backup and checks it against its
+// recorded checksum
+func (r *Runner) Verify(ctx context.Context, key string) error {
+	manifest, err := r.manifest(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	file, err := ioutil.TempFile(r.dumpDir, "verify-")
+	if err != nil {
+		return err
+	}
+	file.Close()
+	defer os.Remove(file.Name())
+
+	if manifest == nil || manifest.DumpMode != api.DumpModePerTable {
+		return r.fetch(ctx, key, manifestChecksum(manifest), file.Name())
+	}
+
+	for _, object := range manifest.Objects {
+		if err := r.fetch(ctx, object.Key, object.SHA256, file.Name()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// VerifySample verifies up to n backups under prefix picked at random from
+// those with a manifest
+func (r *Runner) VerifySample(ctx context.Context, prefix string, n int) ([]VerifyResult, error) {
+	if err := os.MkdirAll(r.dumpDir, 0755); err != nil {
+		return nil, err
+	}
+
+	keys, err := listKeys(ctx, r.bucket, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	dumps := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if isManifestKey(key) {
+			dumps = append(dumps, strings.TrimSuffix(key, manifestSuffix))
+		}
+	}
+	rand.Shuffle(len(dumps), func(i, j int) {
+		dumps[i], dumps[j] = dumps[j], dumps[i]
+	})
+	if n < len(dumps) {
+		dumps = dumps[:n]
+	}
+
+	results := make([]VerifyResult, len(dumps))
+	for i, key := range dumps {
+		logging.Info(ctx, "Verifying backup", "bucket", r.bucket, "key", key)
+		results[i] = VerifyResult{Key: key, Err: r.Verify(ctx, key)}
+		if ctx.Err() != nil {
+			return results[:i+1], ctx.Err()
+		}
+	}
+	return results, nil
+}
+
+// fetch downloads key to filename and compares its checksum with the one
+// recorded in the manifest and the object metadata. Dumps uploaded before
+// checksums were recorded have neither and are accepted with a warning.
+func (r *Runner) fetch(ctx context.Context, key string, recorded string, filename string) error {
+	checksum, metadata, err := downloadFromS3(ctx, r.bucket, key, filename)
+	if err != nil {
+		return err
+	}
+
+	expected := metadata[checksumMetadata]
+	if recorded != "" {
+		if expected != "" && expected != recorded {
+			return fmt.Errorf("%s: manifest and object metadata disagree on checksum", key)
+		}
+		expected = recorded
+	}
+
+	if expected == "" {
+		logging.Warn(ctx, "No recorded checksum", "key", key)
+		return nil
+	}
+	if checksum != expected {
+		return &ChecksumError{Key: key, Expected: expected, Actual: checksum}
+	}
+
+	return nil
+}
+
+// manifest returns the backup's manifest, or nil for backups taken before
+// manifests were written
+func (r *Runner) manifest(ctx context.Context, key string) (*Manifest, error) {
+	manifest := new(Manifest)
+	err := getJSON(ctx, r.bucket, ManifestKey(key), manifest)
+	var awsErr awserr.Error
+	if errors.As(err, &awsErr) && awsErr.Code() == s3.ErrCodeNoSuchKey {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+func manifestChecksum(manifest *Manifest) string {
+	if manifest == nil {
+		return ""
+	}
+	return manifest.SHA256
+}
+
+func loadDump(ctx context.Context, server api.Server, target string, filename string) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	args, err := server.RestoreCmd(target)
+	if err != nil {
+		return err
+	}
+
+	stderr := process.NewTailBuffer(process.StderrLimit)
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin = file
+	cmd.Stderr = stderr
+	if err := process.Run(ctx, cmd); err != nil {
+		if message := lastLine(stderr.String()); message != "" {
+			return fmt.Errorf("loading %s: %s: %s", filepath.Base(filename), err, message)
+		}
+		return err
+	}
+
+	return nil
+}
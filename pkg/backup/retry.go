@@ -0,0 +1,121 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"syscall"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/jbaikge/database-backups/pkg/api"
+	"github.com/jbaikge/database-backups/pkg/logging"
+)
+
+// Policy controls how a step is retried. The delay before attempt n+1 is
+// Backoff * 2^(n-1), capped at MaxBackoff unless that is zero and randomised
+// by up to Jitter (a fraction) either way.
+type Policy struct {
+	MaxAttempts int
+	Backoff     time.Duration
+	MaxBackoff  time.Duration
+	Jitter      float64
+}
+
+// PolicyFor reads the retry policy for a step from the settings
+func PolicyFor(settings api.SettingService, step string) (policy Policy, err error) {
+	attemptSettings := map[string]string{
+		api.AttemptStepRefresh: api.SettingRetryAttemptsRefresh,
+		api.AttemptStepDump:    api.SettingRetryAttemptsDump,
+		api.AttemptStepUpload:  api.SettingRetryAttemptsUpload,
+	}
+
+	if policy.MaxAttempts, err = settings.Int(attemptSettings[step]); err != nil {
+		return
+	}
+	if policy.Backoff, err = settings.Duration(api.SettingRetryBackoff); err != nil {
+		return
+	}
+	if policy.MaxBackoff, err = settings.Duration(api.SettingRetryBackoffMax); err != nil {
+		return
+	}
+	if policy.Jitter, err = settings.Float(api.SettingRetryJitter); err != nil {
+		return
+	}
+	return
+}
+
+// Retry calls fn until it succeeds, fails with a permanent error or runs out
+// of attempts. record is called after every attempt.
+func (p Policy) Retry(ctx context.Context, fn func() error, record func(number int, started time.Time, err error)) error {
+	var err error
+	for number := 1; ; number++ {
+		started := time.Now()
+		err = fn()
+		record(number, started, err)
+
+		if err == nil || number >= p.MaxAttempts || !Retryable(err) || ctx.Err() != nil {
+			return err
+		}
+
+		delay := p.Delay(number)
+		logging.Warn(ctx, "Attempt failed, retrying", "attempt", number, "delay", delay.Round(time.Second), "error", err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+	}
+}
+
+// Delay returns how long to wait after attempt number failed
+func (p Policy) Delay(number int) time.Duration {
+	delay := p.Backoff
+	for i := 1; i < number && (p.MaxBackoff == 0 || delay < p.MaxBackoff); i++ {
+		delay *= 2
+	}
+	if p.MaxBackoff > 0 && delay > p.MaxBackoff {
+		delay = p.MaxBackoff
+	}
+	if p.Jitter > 0 {
+		delay += time.Duration((rand.Float64()*2 - 1) * p.Jitter * float64(delay))
+	}
+	return delay
+}
+
+// Retryable reports whether err looks transient: connection failures, ssh
+// losing its connection, and S3 server errors or throttling. Anything else,
+// including authentication failures and missing databases, is permanent.
+func Retryable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var temporary interface{ Temporary() bool }
+	if errors.As(err, &temporary) && temporary.Temporary() {
+		return true
+	}
+
+	if errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) {
+		return true
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+
+	var requestErr awserr.RequestFailure
+	if errors.As(err, &requestErr) {
+		return requestErr.StatusCode() >= 500 || request.IsErrorThrottle(requestErr) || request.IsErrorRetryable(requestErr)
+	}
+
+	var awsErr awserr.Error
+	if errors.As(err, &awsErr) {
+		return request.IsErrorRetryable(awsErr) || request.IsErrorThrottle(awsErr)
+	}
+
+	return false
+}
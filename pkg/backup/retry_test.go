@@ -0,0 +1,90 @@
+package backup_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/jbaikge/database-backups/pkg/backup"
+	"github.com/jbaikge/database-backups/pkg/process"
+	"github.com/zeebo/assert"
+)
+
+func TestRetryable(t *testing.T) {
+	tests := []struct {
+		err       error
+		retryable bool
+	}{
+		{&process.ExitError{Name: "ssh", ExitCode: 255, Err: errors.New("exit status 255")}, true},
+		{&process.ExitError{Name: "mysqldump", ExitCode: 2, Err: errors.New("exit status 2")}, false},
+		{awserr.NewRequestFailure(awserr.New("InternalError", "", nil), 500, ""), true},
+		{awserr.NewRequestFailure(awserr.New("SlowDown", "", nil), 503, ""), true},
+		{awserr.NewRequestFailure(awserr.New("AccessDenied", "", nil), 403, ""), false},
+		{context.DeadlineExceeded, false},
+		{errors.New("unknown"), false},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, backup.Retryable(test.err), test.retryable)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	policy := backup.Policy{MaxAttempts: 3, Backoff: time.Millisecond}
+	transient := &process.ExitError{Name: "ssh", ExitCode: 255, Err: errors.New("exit status 255")}
+
+	calls, recorded := 0, 0
+	err := policy.Retry(context.Background(), func() error {
+		calls++
+		return transient
+	}, func(int, time.Time, error) {
+		recorded++
+	})
+	assert.Equal(t, err, transient)
+	assert.Equal(t, calls, 3)
+	assert.Equal(t, recorded, 3)
+
+	calls = 0
+	err = policy.Retry(context.Background(), func() error {
+		calls++
+		if calls == 2 {
+			return nil
+		}
+		return transient
+	}, func(int, time.Time, error) {})
+	assert.Nil(t, err)
+	assert.Equal(t, calls, 2)
+
+	calls = 0
+	err = policy.Retry(context.Background(), func() error {
+		calls++
+		return errors.New("permanent")
+	}, func(int, time.Time, error) {})
+	assert.Error(t, err)
+	assert.Equal(t, calls, 1)
+}
+
+func TestPolicyDelay(t *testing.T) {
+	tests := []struct {
+		maxBackoff time.Duration
+		number     int
+		delay      time.Duration
+	}{
+		{time.Minute, 1, time.Second},
+		{time.Minute, 4, 8 * time.Second},
+		{time.Minute, 10, time.Minute},
+		{5 * time.Second, 3, 4 * time.Second},
+		{5 * time.Second, 4, 5 * time.Second},
+		// Uncapped
+		{0, 1, time.Second},
+		{0, 4, 8 * time.Second},
+		{0, 10, 512 * time.Second},
+	}
+
+	for _, test := range tests {
+		policy := backup.Policy{Backoff: time.Second, MaxBackoff: test.maxBackoff}
+		assert.Equal(t, policy.Delay(test.number), test.delay)
+	}
+}
@@ -0,0 +1,501 @@
+package backup
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"github.com/jbaikge/database-backups/pkg/api"
+	"github.com/jbaikge/database-backups/pkg/logging"
+	"github.com/jbaikge/database-backups/pkg/process"
+	"github.com/jbaikge/database-backups/pkg/tracing"
+)
+
+// Runner dumps a single database, ships it to S3 and records the attempt in
+// the logs table.
+type Runner struct {
+	logService     api.LogService
+	lockService    api.LockService
+	settingService api.SettingService
+	notifier       api.Notifier
+	dumpDir        string
+	bucket         string
+}
+
+func NewRunner(logService api.LogService, lockService api.LockService, settingService api.SettingService, notifier api.Notifier, dumpDir string, bucket string) *Runner {
+	return &Runner{
+		logService:     logService,
+		lockService:    lockService,
+		settingService: settingService,
+		notifier:       notifier,
+		dumpDir:        dumpDir,
+		bucket:         bucket,
+	}
+}
+
+// Backup holds two locks for the duration of the dump: an flock covering
+// other processes on this host sharing the dump directory, and a row in the
+// locks table covering anything else sharing the configuration database.
+// Either being held results in an *api.LockError.
+//
+// The dump and upload are bound by the database timeout as well as ctx, and
+// the reason for stopping early is recorded in the logs table. Lines logged
+// along the way carry the server, database and log entry, and each stage is
+// traced under a backup span.
+func (r *Runner) Backup(ctx context.Context, server api.Server, database api.Database) error {
+	ctx = logging.With(ctx, "server", server.Name, "database", database.Name)
+	ctx, span := tracing.Start(ctx, "backup",
+		tracing.String("server", server.Name),
+		tracing.String("database", database.Name),
+		tracing.String("dump_mode", database.DumpMode),
+	)
+	defer span.End()
+
+	if err := os.MkdirAll(r.dumpDir, 0755); err != nil {
+		return err
+	}
+
+	// Resolved before the log entry is made, which would otherwise be left
+	// running, and before locking, as the row lock is held for as long as the
+	// dump may run
+	timeout, err := r.databaseTimeout(database)
+	if err != nil {
+		return err
+	}
+
+	lockName := server.Name + "/" + database.Name
+	lockPath := filepath.Join(r.dumpDir, lockFilename(server.Name, database.Name))
+	fileLock, err := lockFile(lockPath, lockName)
+	if err != nil {
+		return err
+	}
+	defer fileLock.Unlock()
+
+	rowLock, err := r.lockService.Acquire(lockName, timeout)
+	if err != nil {
+		return err
+	}
+	defer r.lockService.Release(rowLock)
+
+	start := time.Now()
+	entry := api.Log{
+		DatabaseId:  database.Id,
+		BackupStart: &start,
+		Status:      api.LogStatusRunning,
+	}
+
+	previous, err := r.logService.LastSuccessful(database.Id)
+	if err != nil {
+		return err
+	}
+	if previous != nil {
+		entry.SizePrevious = previous.SizeCurrent
+	}
+
+	logId, err := r.logService.New(entry)
+	if err != nil {
+		return err
+	}
+	ctx = logging.With(ctx, "log_id", logId)
+
+	dbCtx := ctx
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		dbCtx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	size, warning, err := r.backup(dbCtx, server, database, logId, entry.SizePrevious)
+
+	end := time.Now()
+	entry.BackupEnd = &end
+	entry.SizeCurrent = size
+	entry.Status = api.LogStatusSuccess
+	entry.Warning = warning
+	if err != nil {
+		entry.Status, err = failure(ctx, dbCtx, timeout, err)
+		entry.Error = err.Error()
+	}
+
+	span.SetAttributes(
+		tracing.String("status", entry.Status),
+		tracing.Int64("size_bytes", entry.SizeCurrent),
+		tracing.Int64("previous_size_bytes", entry.SizePrevious),
+	)
+	span.RecordError(err)
+
+	// The event goes out even when the result could not be recorded
+	updateErr := r.logService.Update(logId, entry)
+
+	switch {
+	case err != nil && entry.Status != api.LogStatusCancelled:
+		r.notify(ctx, api.Event{
+			Type:     api.EventBackupFailed,
+			Server:   server.Name,
+			Database: database.Name,
+			Message:  fmt.Sprintf("Backup of %s on %s failed: %s", database.Name, server.Name, entry.Error),
+			Fields:   map[string]string{"status": entry.Status},
+		})
+	case warning != "":
+		r.notify(ctx, api.Event{
+			Type:     api.EventSizeDrop,
+			Server:   server.Name,
+			Database: database.Name,
+			Message:  fmt.Sprintf("Backup of %s on %s: %s", database.Name, server.Name, warning),
+			Fields: map[string]string{
+				"previous_size": fmt.Sprint(entry.SizePrevious),
+				"size":          fmt.Sprint(entry.SizeCurrent),
+			},
+		})
+	}
+
+	switch {
+	case updateErr == nil:
+		return err
+	case err == nil:
+		return fmt.Errorf("recording the result: %w", updateErr)
+	default:
+		return fmt.Errorf("%w; recording the result: %s", err, updateErr)
+	}
+}
+
+// NotifyRunSummary raises the event summing up a run over many databases,
+// carrying each database's result
+func (r *Runner) NotifyRunSummary(ctx context.Context, results []api.BackupResult, skipped int, started time.Time) {
+	failed := 0
+	for _, result := range results {
+		if result.Status != api.LogStatusSuccess {
+			failed++
+		}
+	}
+	elapsed := time.Since(started).Round(time.Second)
+
+	r.notify(ctx, api.Event{
+		Type:    api.EventRunSummary,
+		Message: fmt.Sprintf("%d of %d backups failed, %d skipped, in %s", failed, len(results), skipped, elapsed),
+		Fields: map[string]string{
+			"attempted": fmt.Sprint(len(results)),
+			"failed":    fmt.Sprint(failed),
+			"skipped":   fmt.Sprint(skipped),
+			"started":   started.Format(time.RFC3339),
+			"elapsed":   elapsed.String(),
+		},
+		Results: results,
+	})
+}
+
+// notify sends an event, logging rather than returning failures. Events are
+// sent even after the run is cancelled, as that is when they matter most, so
+// ctx only supplies the logger.
+func (r *Runner) notify(ctx context.Context, event api.Event) {
+	event.Time = time.Now()
+	if err := r.notifier.Notify(context.Background(), event); err != nil {
+		logging.Error(ctx, "Notifying failed", "event", event.Type, "error", err)
+	}
+}
+
+// Refresh updates the server's database list, retrying transient failures
+func (r *Runner) Refresh(ctx context.Context, serverService api.ServerService, server api.Server) error {
+	ctx, span := tracing.Start(ctx, "refresh", tracing.String("server", server.Name))
+	defer span.End()
+
+	err := r.retry(ctx, api.AttemptStepRefresh, server.Id, nil, func() error {
+		return refreshError(server, serverService.UpdateDatabases(ctx, server.Id))
+	})
+	span.RecordError(err)
+	return err
+}
+
+// backup dumps, validates and uploads a database, then its manifest. A
+// warning is returned when the dump shrank suspiciously but the
+// size_drop_action setting says to carry on anyway.
+func (r *Runner) backup(ctx context.Context, server api.Server, database api.Database, logId int, previousSize int64) (size int64, warning string, err error) {
+	manifest := Manifest{
+		Server:      server.Name,
+		Database:    database.Name,
+		Engine:      "mysql",
+		DumpMode:    database.DumpMode,
+		Compression: dumpCompression,
+		Encryption:  dumpEncryption,
+		Start:       time.Now(),
+	}
+	describeCtx, span := tracing.Start(ctx, "describe")
+	if err := manifest.describe(describeCtx, server); err != nil {
+		logging.Warn(ctx, "Collecting manifest details failed", "error", err)
+		span.RecordError(err)
+	}
+	span.End()
+
+	// Table rules are matched against the tables as they are now
+	resolveCtx, span := tracing.Start(ctx, "resolve_tables")
+	err = r.retry(resolveCtx, api.AttemptStepDump, server.Id, &logId, func() error {
+		var err error
+		database, manifest.Tables, err = ResolveTables(resolveCtx, server, database, r.settingService)
+		return err
+	})
+	span.RecordError(err)
+	span.SetAttributes(tracing.Int("tables", len(manifest.Tables)))
+	span.End()
+	if err != nil {
+		return
+	}
+	manifest.Partial = len(manifest.filteredTables()) > 0
+
+	if database.DumpMode == api.DumpModePerTable {
+		warning, err = r.backupTables(ctx, server, database, logId, previousSize, &manifest)
+	} else {
+		warning, err = r.backupSingle(ctx, server, database, logId, previousSize, &manifest)
+	}
+	if err != nil {
+		return
+	}
+	size = manifest.Size
+
+	// Written last so a backup without one is known to be incomplete
+	manifest.End = time.Now()
+	uploadCtx, span := tracing.Start(ctx, "upload_manifest", tracing.String("key", ManifestKey(manifest.Key)))
+	err = r.retry(uploadCtx, api.AttemptStepUpload, server.Id, &logId, func() error {
+		return putJSON(uploadCtx, r.bucket, ManifestKey(manifest.Key), manifest)
+	})
+	span.RecordError(err)
+	span.End()
+	return
+}
+
+// backupSingle dumps the whole database to one file. The size is checked
+// before uploading so a suspiciously small dump can be held back.
+func (r *Runner) backupSingle(ctx context.Context, server api.Server, database api.Database, logId int, previousSize int64, manifest *Manifest) (warning string, err error) {
+	filename := filepath.Join(r.dumpDir, server.Filename(database))
+	defer func() {
+		if err != nil {
+			os.Remove(filename)
+		}
+	}()
+
+	manifest.Key = server.S3Key(database)
+	build := func(s api.Server) ([][]string, error) {
+		return s.DatabaseDumpCmds(database)
+	}
+
+	logging.Info(ctx, "Dumping database", "file", filename)
+	if manifest.SHA256, manifest.Size, err = r.dump(ctx, server, database, logId, filename, build); err != nil {
+		return
+	}
+
+	if server.Binlog != "" {
+		if manifest.Binlog, err = binlogPosition(filename); err != nil {
+			return
+		}
+		if manifest.Binlog == nil {
+			logging.Warn(ctx, "No binlog position found in the dump")
+		}
+	}
+
+	if warning, err = r.checkSizeDrop(ctx, previousSize, manifest.Size); err != nil {
+		return
+	}
+
+	if err = r.upload(ctx, server, logId, filename, manifest.Key, manifest.SHA256); err != nil {
+		return
+	}
+
+	logging.Debug(ctx, "Removing temporary file", "file", filename)
+	err = os.Remove(filename)
+	return
+}
+
+// dump runs the commands from build into filename, retrying transient
+// failures. build is called with the server and its redacted copy. Going
+// through an ssh proxy, connecting is part of the dump's time.
+func (r *Runner) dump(ctx context.Context, server api.Server, database api.Database, logId int, filename string, build func(api.Server) ([][]string, error)) (checksum string, size int64, err error) {
+	ctx, span := tracing.Start(ctx, "dump",
+		tracing.String("file", filename),
+		tracing.Bool("proxy", server.ProxyHost != ""),
+	)
+	defer span.End()
+
+	err = r.retry(ctx, api.AttemptStepDump, server.Id, &logId, func() error {
+		var err error
+		checksum, size, err = dumpDatabase(ctx, server, database, filename, build)
+		return err
+	})
+	span.SetAttributes(tracing.Int64("size_bytes", size))
+	span.RecordError(err)
+	return
+}
+
+func (r *Runner) upload(ctx context.Context, server api.Server, logId int, filename string, key string, checksum string) error {
+	logging.Info(ctx, "Sending to S3", "bucket", r.bucket, "key", key)
+	ctx, span := tracing.Start(ctx, "upload",
+		tracing.String("bucket", r.bucket),
+		tracing.String("key", key),
+	)
+	defer span.End()
+	if info, err := os.Stat(filename); err == nil {
+		span.SetAttributes(tracing.Int64("size_bytes", info.Size()))
+	}
+
+	metadata := map[string]string{checksumMetadata: checksum}
+	err := r.retry(ctx, api.AttemptStepUpload, server.Id, &logId, func() error {
+		return sendToS3(ctx, r.bucket, filename, key, metadata)
+	})
+	span.RecordError(err)
+	return err
+}
+
+func (r *Runner) checkSizeDrop(ctx context.Context, previous int64, current int64) (string, error) {
+	threshold, err := r.settingService.Float(api.SettingSizeDropThreshold)
+	if err != nil {
+		return "", err
+	}
+	action, err := r.settingService.Get(api.SettingSizeDropAction)
+	if err != nil {
+		return "", err
+	}
+
+	drop := sizeDrop(previous, current, threshold)
+	if drop == "" {
+		return "", nil
+	}
+	if action == api.SizeDropActionFail {
+		return "", errors.New(drop)
+	}
+	logging.Warn(ctx, "Dump shrank", "warning", drop)
+	return drop, nil
+}
+
+// retry runs one step of a backup under its retry policy, recording every
+// attempt. The number of attempts is added to the current span.
+func (r *Runner) retry(ctx context.Context, step string, serverId int, logId *int, fn func() error) error {
+	policy, err := PolicyFor(r.settingService, step)
+	if err != nil {
+		return err
+	}
+
+	attempts := 0
+	defer func() {
+		tracing.SpanFromContext(ctx).SetAttributes(tracing.Int("attempts", attempts))
+	}()
+	return policy.Retry(ctx, fn, func(number int, started time.Time, err error) {
+		attempts = number
+		attempt := api.Attempt{
+			LogId:    logId,
+			ServerId: serverId,
+			Step:     step,
+			Number:   number,
+			Started:  started,
+			Ended:    time.Now(),
+		}
+		if err != nil {
+			attempt.Error = err.Error()
+		}
+		if err := r.logService.NewAttempt(attempt); err != nil {
+			logging.Error(ctx, "Recording attempt failed", "step", step, "attempt", number, "error", err)
+		}
+	})
+}
+
+// A database's own timeout takes precedence over the database_timeout
+// setting
+func (r *Runner) databaseTimeout(database api.Database) (time.Duration, error) {
+	if database.Timeout != "" {
+		return time.ParseDuration(database.Timeout)
+	}
+	return r.settingService.Duration(api.SettingDatabaseTimeout)
+}
+
+// failure works out why a backup stopped for the run history. Cancellation of
+// the run (a signal or the run timeout) is told apart from the database's own
+// timeout by checking which context finished first. The reason wraps err.
+func failure(runCtx context.Context, dbCtx context.Context, timeout time.Duration, err error) (status string, reason error) {
+	switch {
+	case runCtx.Err() == context.DeadlineExceeded:
+		return api.LogStatusTimeout, fmt.Errorf("run timeout exceeded: %w", err)
+	case runCtx.Err() == context.Canceled:
+		return api.LogStatusCancelled, fmt.Errorf("run cancelled: %w", err)
+	case dbCtx.Err() == context.DeadlineExceeded:
+		return api.LogStatusTimeout, fmt.Errorf("database timeout of %s exceeded: %w", timeout, err)
+	default:
+		return api.LogStatusFailed, err
+	}
+}
+
+// dumpDatabase runs the commands from build one after another, appending
+// their output to path and validating what each wrote. It returns the hex
+// SHA-256 of the whole file, computed on the way through, and its size.
+func dumpDatabase(ctx context.Context, server api.Server, database api.Database, path string, build func(api.Server) ([][]string, error)) (checksum string, size int64, err error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	// In case this runs twice in the same day, empty the file before writing
+	if err = file.Truncate(0); err != nil {
+		return
+	}
+
+	cmds, err := build(server)
+	if err != nil {
+		return
+	}
+	redacted, err := build(server.Redacted())
+	if err != nil {
+		return
+	}
+
+	hash := sha256.New()
+	for i, args := range cmds {
+		stderr := process.NewTailBuffer(process.StderrLimit)
+		cmd := exec.Command(args[0], args[1:]...)
+		cmd.Stdout = io.MultiWriter(file, hash)
+		cmd.Stderr = stderr
+		if err = process.Run(ctx, cmd); err != nil {
+			if ctx.Err() == nil {
+				err = dumpError(server, database, redacted[i], stderr.String(), err)
+			}
+			return
+		}
+
+		if size, err = validateDump(path, size, redacted[i]); err != nil {
+			return
+		}
+	}
+
+	checksum = hex.EncodeToString(hash.Sum(nil))
+	return
+}
+
+// refreshError turns a failed database list into a DumpError, so the MySQL
+// error in its output decides whether it is retried as it does for dumps
+func refreshError(server api.Server, err error) error {
+	var exitErr *process.ExitError
+	if !errors.As(err, &exitErr) {
+		return err
+	}
+	command, _ := server.Redacted().DatabaseListCmd()
+	return dumpError(server, api.Database{}, command, exitErr.Stderr, err)
+}
+
+func dumpError(server api.Server, database api.Database, command []string, stderr string, err error) *DumpError {
+	dumpErr := &DumpError{
+		Server:   server.Name,
+		Database: database.Name,
+		Command:  command,
+		Stderr:   stderr,
+		Code:     parseMySQLError(stderr),
+		Err:      err,
+	}
+	var exitErr *process.ExitError
+	if errors.As(err, &exitErr) {
+		dumpErr.ExitCode = exitErr.ExitCode
+	}
+	return dumpErr
+}
@@ -0,0 +1,77 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jbaikge/database-backups/pkg/api"
+	"github.com/zeebo/assert"
+)
+
+type lockService struct{}
+
+func (lockService) Acquire(name string, hold time.Duration) (*api.Lock, error) {
+	return &api.Lock{Name: name}, nil
+}
+
+func (lockService) Release(*api.Lock) error {
+	return nil
+}
+
+type settingRepository map[string]string
+
+func (r settingRepository) GetSetting(key string) (*api.Setting, error) {
+	value, ok := r[key]
+	if !ok {
+		return nil, nil
+	}
+	return &api.Setting{Key: key, Value: value}, nil
+}
+
+func (r settingRepository) ListSettings() ([]api.Setting, error) {
+	return nil, nil
+}
+
+func (r settingRepository) SetSetting(setting api.Setting) error {
+	r[setting.Key] = setting.Value
+	return nil
+}
+
+type notifier []api.Event
+
+func (n *notifier) Notify(ctx context.Context, event api.Event) error {
+	*n = append(*n, event)
+	return nil
+}
+
+func TestBackupRecordingFails(t *testing.T) {
+	logs := &logService{updateErr: errors.New("database is locked")}
+	events := &notifier{}
+	runner := NewRunner(logs, lockService{}, api.NewSettingService(settingRepository{}), events, t.TempDir(), "backups")
+
+	// Nothing can be run, and the database timeout has passed before the
+	// dump starts
+	path := os.Getenv("PATH")
+	os.Setenv("PATH", "")
+	defer os.Setenv("PATH", path)
+
+	server := api.Server{Id: 1, Name: "db1", Host: "127.0.0.1", Port: 1}
+	database := api.Database{Id: 1, ServerId: 1, Name: "shop", Timeout: "1ns"}
+	err := runner.Backup(context.Background(), server, database)
+	assert.Error(t, err)
+
+	// The failure is still sent, and the error keeps both causes
+	assert.Equal(t, len(logs.updated), 1)
+	assert.Equal(t, logs.updated[0].Status, api.LogStatusTimeout)
+	assert.Equal(t, len(*events), 1)
+	assert.Equal(t, (*events)[0].Type, api.EventBackupFailed)
+	var execErr *exec.Error
+	assert.True(t, errors.As(err, &execErr))
+	assert.That(t, strings.Contains(err.Error(), "database timeout of 1ns exceeded"))
+	assert.That(t, strings.Contains(err.Error(), "recording the result: database is locked"))
+}
@@ -0,0 +1,175 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/jbaikge/database-backups/pkg/logging"
+)
+
+// Object metadata key holding the hex SHA-256 of a dump
+const checksumMetadata = "Sha256"
+
+// How long aborting a failed multipart upload may take. The upload's own
+// context may be cancelled already, so the abort gets a context of its own.
+const abortTimeout = 30 * time.Second
+
+// Required environment variables:
+// AWS_ACCESS_KEY_ID
+// AWS_SECRET_ACCESS_KEY
+// AWS_REGION
+//
+// Cancelling the context stops the upload, and any multipart upload already
+// started is then aborted so its parts do not stay in the bucket.
+func sendToS3(ctx context.Context, bucket string, filename string, key string, metadata map[string]string) error {
+	sess, err := session.NewSession()
+	if err != nil {
+		return err
+	}
+
+	return uploadFile(ctx, s3.New(sess), bucket, filename, key, metadata)
+}
+
+func uploadFile(ctx context.Context, client s3iface.S3API, bucket string, filename string, key string, metadata map[string]string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	input := &s3manager.UploadInput{
+		Bucket:   aws.String(bucket),
+		Key:      aws.String(key),
+		Body:     f,
+		Metadata: aws.StringMap(metadata),
+	}
+
+	// The uploader aborts with the upload's context, which fails once that
+	// is cancelled, so the parts are left for the abort below instead
+	uploader := s3manager.NewUploaderWithClient(client, func(u *s3manager.Uploader) {
+		u.LeavePartsOnError = true
+	})
+	_, err = uploader.UploadWithContext(ctx, input)
+
+	var failure s3manager.MultiUploadFailure
+	if errors.As(err, &failure) {
+		abortCtx, cancel := context.WithTimeout(context.Background(), abortTimeout)
+		defer cancel()
+		_, abortErr := client.AbortMultipartUploadWithContext(abortCtx, &s3.AbortMultipartUploadInput{
+			Bucket:   aws.String(bucket),
+			Key:      aws.String(key),
+			UploadId: aws.String(failure.UploadID()),
+		})
+		if abortErr != nil {
+			logging.Error(ctx, "Aborting multipart upload failed", "key", key, "upload_id", failure.UploadID(), "error", abortErr)
+		}
+	}
+	return err
+}
+
+// downloadFromS3 saves an object to filename and returns its hex SHA-256
+// along with the object's metadata
+func downloadFromS3(ctx context.Context, bucket string, key string, filename string) (string, map[string]string, error) {
+	sess, err := session.NewSession()
+	if err != nil {
+		return "", nil, err
+	}
+
+	output, err := s3.New(sess).GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	defer output.Body.Close()
+
+	f, err := os.OpenFile(filename, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return "", nil, err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(f, hash), output.Body); err != nil {
+		return "", nil, err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), aws.StringValueMap(output.Metadata), nil
+}
+
+func getJSON(ctx context.Context, bucket string, key string, v interface{}) error {
+	sess, err := session.NewSession()
+	if err != nil {
+		return err
+	}
+
+	output, err := s3.New(sess).GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return err
+	}
+	defer output.Body.Close()
+
+	return json.NewDecoder(output.Body).Decode(v)
+}
+
+// listKeys returns every key under prefix in lexical order
+func listKeys(ctx context.Context, bucket string, prefix string) ([]string, error) {
+	sess, err := session.NewSession()
+	if err != nil {
+		return nil, err
+	}
+
+	input := &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(prefix),
+	}
+
+	keys := make([]string, 0, 100)
+	err = s3.New(sess).ListObjectsV2PagesWithContext(ctx, input, func(page *s3.ListObjectsV2Output, last bool) bool {
+		for _, object := range page.Contents {
+			keys = append(keys, aws.StringValue(object.Key))
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+func putJSON(ctx context.Context, bucket string, key string, v interface{}) error {
+	sess, err := session.NewSession()
+	if err != nil {
+		return err
+	}
+
+	body, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	_, err = s3.New(sess).PutObjectWithContext(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(bucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(body),
+		ContentType: aws.String("application/json"),
+	})
+	return err
+}
@@ -0,0 +1,64 @@
+package backup
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/zeebo/assert"
+)
+
+// multipartS3 starts multipart uploads whose parts only finish when the
+// upload's context is cancelled, and records aborts
+type multipartS3 struct {
+	s3iface.S3API
+	started   chan struct{}
+	aborted   []string
+	abortErrs []error
+}
+
+func (m *multipartS3) CreateMultipartUploadWithContext(ctx aws.Context, input *s3.CreateMultipartUploadInput, opts ...request.Option) (*s3.CreateMultipartUploadOutput, error) {
+	close(m.started)
+	return &s3.CreateMultipartUploadOutput{UploadId: aws.String("upload-1")}, nil
+}
+
+func (m *multipartS3) UploadPartWithContext(ctx aws.Context, input *s3.UploadPartInput, opts ...request.Option) (*s3.UploadPartOutput, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (m *multipartS3) AbortMultipartUploadWithContext(ctx aws.Context, input *s3.AbortMultipartUploadInput, opts ...request.Option) (*s3.AbortMultipartUploadOutput, error) {
+	m.aborted = append(m.aborted, aws.StringValue(input.UploadId))
+	m.abortErrs = append(m.abortErrs, ctx.Err())
+	return &s3.AbortMultipartUploadOutput{}, nil
+}
+
+func TestUploadAbortsOnCancel(t *testing.T) {
+	dir, err := ioutil.TempDir("", "upload")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// Larger than one part, so the upload is a multipart one
+	filename := filepath.Join(dir, "dump.sql")
+	assert.NoError(t, ioutil.WriteFile(filename, make([]byte, s3manager.MinUploadPartSize+1), 0600))
+
+	client := &multipartS3{started: make(chan struct{})}
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-client.started
+		cancel()
+	}()
+
+	err = uploadFile(ctx, client, "bucket", filename, "db1/shop.sql", nil)
+	assert.Error(t, err)
+	assert.DeepEqual(t, client.aborted, []string{"upload-1"})
+	// The abort must not be sent with the cancelled context
+	assert.DeepEqual(t, client.abortErrs, []error{nil})
+}
@@ -0,0 +1,143 @@
+package backup
+
+import (
+	"context"
+	"time"
+
+	"github.com/jbaikge/database-backups/pkg/api"
+	"github.com/jbaikge/database-backups/pkg/cron"
+	"github.com/jbaikge/database-backups/pkg/logging"
+)
+
+// Scheduler enqueues databases according to their cron expressions. A
+// database without its own schedule inherits the one set on its server;
+// databases with neither are left to the external timer.
+type Scheduler struct {
+	serverService   api.ServerService
+	databaseService api.DatabaseService
+	logService      api.LogService
+	queue           *Queue
+}
+
+func NewScheduler(serverService api.ServerService, databaseService api.DatabaseService, logService api.LogService, queue *Queue) *Scheduler {
+	return &Scheduler{
+		serverService:   serverService,
+		databaseService: databaseService,
+		logService:      logService,
+		queue:           queue,
+	}
+}
+
+// Entries lists every scheduled database along with its next run. The next
+// run is computed from the start of the last attempt, so a run missed while
+// the scheduler was down shows up as already due and is caught up once.
+func (s *Scheduler) Entries() ([]api.ScheduleEntry, error) {
+	servers, err := s.serverService.List()
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]api.ScheduleEntry, 0, 100)
+	for _, server := range servers {
+		databases, err := s.databaseService.List(server.Id)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, database := range databases {
+			if !database.Backup {
+				continue
+			}
+
+			expr := database.Schedule
+			if expr == "" {
+				expr = server.Schedule
+			}
+			if expr == "" {
+				continue
+			}
+
+			schedule, err := cron.Parse(expr)
+			if err != nil {
+				logging.Default().Warn("Skipping database with a bad schedule", "server", server.Name, "database", database.Name, "error", err)
+				continue
+			}
+
+			entry := api.ScheduleEntry{
+				ServerId:   server.Id,
+				DatabaseId: database.Id,
+				Server:     server.Name,
+				Database:   database.Name,
+				Schedule:   expr,
+			}
+
+			last, err := s.logService.Last(database.Id)
+			if err != nil {
+				return nil, err
+			}
+
+			base := database.Added
+			if last != nil && last.BackupStart != nil {
+				entry.LastRun = last.BackupStart
+				base = *last.BackupStart
+			}
+			entry.NextRun = schedule.Next(base)
+			if entry.NextRun.IsZero() {
+				continue
+			}
+
+			entries = append(entries, entry)
+		}
+	}
+
+	return entries, nil
+}
+
+// Run checks for due databases once a minute until the context is cancelled
+func (s *Scheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		if err := s.enqueueDue(time.Now()); err != nil {
+			logging.Error(ctx, "Scheduler error", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Scheduler) enqueueDue(now time.Time) error {
+	entries, err := s.Entries()
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if entry.NextRun.After(now) {
+			continue
+		}
+
+		server, err := s.serverService.Get(entry.ServerId)
+		if err != nil {
+			return err
+		}
+		database, err := s.databaseService.Get(entry.DatabaseId)
+		if err != nil {
+			return err
+		}
+		if server == nil || database == nil {
+			continue
+		}
+
+		if s.queue.Enqueue(Job{Server: *server, Database: *database}) {
+			logging.Default().Info("Queued", "server", server.Name, "database", database.Name, "scheduled", entry.NextRun.Format(time.RFC3339))
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,149 @@
+package backup
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jbaikge/database-backups/pkg/api"
+	"github.com/zeebo/assert"
+)
+
+type serverService struct {
+	api.ServerService
+	servers []api.Server
+}
+
+func (s serverService) Get(id int) (*api.Server, error) {
+	for _, server := range s.servers {
+		if server.Id == id {
+			return &server, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s serverService) List() ([]api.Server, error) {
+	return s.servers, nil
+}
+
+type databaseService struct {
+	api.DatabaseService
+	databases []api.Database
+}
+
+func (s databaseService) Get(id int) (*api.Database, error) {
+	for _, database := range s.databases {
+		if database.Id == id {
+			return &database, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s databaseService) List(serverId int) ([]api.Database, error) {
+	var databases []api.Database
+	for _, database := range s.databases {
+		if database.ServerId == serverId {
+			databases = append(databases, database)
+		}
+	}
+	return databases, nil
+}
+
+type logService struct {
+	api.LogService
+	starts    map[int]time.Time
+	updateErr error
+	updated   []api.Log
+}
+
+func (s *logService) Last(databaseId int) (*api.Log, error) {
+	start, ok := s.starts[databaseId]
+	if !ok {
+		return nil, nil
+	}
+	return &api.Log{DatabaseId: databaseId, BackupStart: &start}, nil
+}
+
+func (s *logService) LastSuccessful(int) (*api.Log, error) {
+	return nil, nil
+}
+
+func (s *logService) New(api.Log) (int, error) {
+	return 1, nil
+}
+
+func (s *logService) NewAttempt(api.Attempt) error {
+	return nil
+}
+
+func (s *logService) Update(id int, entry api.Log) error {
+	s.updated = append(s.updated, entry)
+	return s.updateErr
+}
+
+func testScheduler(starts map[int]time.Time) *Scheduler {
+	added := time.Date(2022, time.January, 1, 12, 0, 0, 0, time.UTC)
+	servers := serverService{servers: []api.Server{
+		{Id: 1, Name: "db1", Schedule: "15 1 * * *"},
+		{Id: 2, Name: "db2"},
+	}}
+	databases := databaseService{databases: []api.Database{
+		// Inherits the server schedule
+		{Id: 1, ServerId: 1, Name: "shop", Backup: true, Added: added},
+		{Id: 2, ServerId: 1, Name: "crm", Backup: true, Schedule: "0 */6 * * *", Added: added},
+		{Id: 3, ServerId: 1, Name: "old", Backup: false, Added: added},
+		{Id: 4, ServerId: 1, Name: "typo", Backup: true, Schedule: "0 25 * * *", Added: added},
+		// Neither the database nor its server has a schedule
+		{Id: 5, ServerId: 2, Name: "wiki", Backup: true, Added: added},
+		{Id: 6, ServerId: 2, Name: "never", Backup: true, Schedule: "0 0 30 2 *", Added: added},
+	}}
+	return NewScheduler(servers, databases, &logService{starts: starts}, NewQueue(nil, 10))
+}
+
+func TestSchedulerEntries(t *testing.T) {
+	scheduler := testScheduler(map[int]time.Time{
+		1: time.Date(2022, time.January, 10, 1, 15, 0, 0, time.UTC),
+	})
+
+	entries, err := scheduler.Entries()
+	assert.NoError(t, err)
+	assert.Equal(t, len(entries), 2)
+
+	assert.Equal(t, entries[0].Database, "shop")
+	assert.Equal(t, entries[0].Schedule, "15 1 * * *")
+	assert.Equal(t, *entries[0].LastRun, time.Date(2022, time.January, 10, 1, 15, 0, 0, time.UTC))
+	assert.Equal(t, entries[0].NextRun, time.Date(2022, time.January, 11, 1, 15, 0, 0, time.UTC))
+
+	// Never backed up, so the next run follows the time it was added
+	assert.Equal(t, entries[1].Database, "crm")
+	assert.Nil(t, entries[1].LastRun)
+	assert.Equal(t, entries[1].NextRun, time.Date(2022, time.January, 1, 18, 0, 0, 0, time.UTC))
+}
+
+func TestSchedulerCatchUp(t *testing.T) {
+	// The scheduler was down for several days: each database is due once,
+	// at its first missed run, rather than once per missed run
+	now := time.Date(2022, time.January, 14, 10, 30, 0, 0, time.UTC)
+	scheduler := testScheduler(map[int]time.Time{
+		1: time.Date(2022, time.January, 10, 1, 15, 0, 0, time.UTC),
+		2: time.Date(2022, time.January, 14, 6, 0, 0, 0, time.UTC),
+	})
+
+	entries, err := scheduler.Entries()
+	assert.NoError(t, err)
+	assert.Equal(t, len(entries), 2)
+	assert.That(t, !entries[0].NextRun.After(now))
+	assert.That(t, entries[1].NextRun.After(now))
+
+	assert.NoError(t, scheduler.enqueueDue(now))
+	assert.Equal(t, scheduler.queue.Len(), 1)
+
+	// Still due on the next tick, but already queued
+	assert.NoError(t, scheduler.enqueueDue(now.Add(time.Minute)))
+	assert.Equal(t, scheduler.queue.Len(), 1)
+
+	job := <-scheduler.queue.jobs
+	assert.Equal(t, job.Server.Name, "db1")
+	assert.Equal(t, job.Database.Name, "shop")
+}
@@ -0,0 +1,120 @@
+package backup
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/jbaikge/database-backups/pkg/api"
+)
+
+// Selector narrows a run down to a subset of servers and databases using
+// glob patterns. Patterns of the same kind are OR'd together while different
+// kinds must all match. An empty Selector matches everything.
+type Selector struct {
+	// Servers match the server name
+	Servers []string
+	// Databases match the database name, or server/database when the
+	// pattern contains a slash
+	Databases []string
+	// Tags match any tag on either the server or the database
+	Tags []string
+}
+
+// Validate checks every pattern for syntax errors
+func (s Selector) Validate() error {
+	for _, patterns := range [][]string{s.Servers, s.Databases, s.Tags} {
+		for _, pattern := range patterns {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("invalid pattern %q: %s", pattern, err)
+			}
+		}
+	}
+	return nil
+}
+
+// MatchServer reports whether any database on the server could be selected.
+// Only the server part of server/database patterns is checked, so that newly
+// created databases can still be discovered when refreshing database lists.
+func (s Selector) MatchServer(server api.Server, databases []api.Database) bool {
+	if len(s.Servers) > 0 && !matchAny(s.Servers, server.Name) {
+		return false
+	}
+
+	if len(s.Databases) > 0 && !s.matchDatabaseServer(server.Name) {
+		return false
+	}
+
+	if len(s.Tags) == 0 || s.matchTags(server.Tags) {
+		return true
+	}
+
+	for _, database := range databases {
+		if s.matchTags(database.Tags) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s Selector) MatchDatabase(server api.Server, database api.Database) bool {
+	if len(s.Servers) > 0 && !matchAny(s.Servers, server.Name) {
+		return false
+	}
+
+	if len(s.Databases) > 0 {
+		matched := false
+		for _, pattern := range s.Databases {
+			name := database.Name
+			if strings.Contains(pattern, "/") {
+				name = server.Name + "/" + database.Name
+			}
+			if ok, _ := path.Match(pattern, name); ok {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+
+	if len(s.Tags) > 0 && !s.matchTags(server.Tags) && !s.matchTags(database.Tags) {
+		return false
+	}
+
+	return true
+}
+
+// matchDatabaseServer reports whether a database pattern could select a
+// database on the named server. Patterns without a slash match any server.
+func (s Selector) matchDatabaseServer(name string) bool {
+	for _, pattern := range s.Databases {
+		i := strings.Index(pattern, "/")
+		if i < 0 {
+			return true
+		}
+		if ok, _ := path.Match(pattern[:i], name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (s Selector) matchTags(tags string) bool {
+	for _, tag := range strings.Fields(tags) {
+		if matchAny(s.Tags, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,72 @@
+package backup
+
+import (
+	"testing"
+
+	"github.com/jbaikge/database-backups/pkg/api"
+	"github.com/zeebo/assert"
+)
+
+func TestSelectorMatchServer(t *testing.T) {
+	db1 := api.Server{Name: "db1", Tags: "prod"}
+	databases := []api.Database{{Name: "shop"}, {Name: "crm", Tags: "pii"}}
+
+	tests := []struct {
+		selector Selector
+		match    bool
+	}{
+		{Selector{}, true},
+		{Selector{Servers: []string{"db*"}}, true},
+		{Selector{Servers: []string{"db2"}}, false},
+		// Names alone could match a database not listed yet
+		{Selector{Databases: []string{"missing"}}, true},
+		{Selector{Databases: []string{"db1/x"}}, true},
+		{Selector{Databases: []string{"db*/shop"}}, true},
+		{Selector{Databases: []string{"db2/x"}}, false},
+		{Selector{Databases: []string{"db2/x", "crm"}}, true},
+		{Selector{Databases: []string{"db2/x", "db1/crm"}}, true},
+		{Selector{Tags: []string{"prod"}}, true},
+		{Selector{Tags: []string{"pii"}}, true},
+		{Selector{Tags: []string{"staging"}}, false},
+		{Selector{Servers: []string{"db1"}, Databases: []string{"db2/x"}}, false},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.selector.MatchServer(db1, databases), test.match)
+	}
+}
+
+func TestSelectorMatchDatabase(t *testing.T) {
+	db1 := api.Server{Name: "db1", Tags: "prod"}
+	shop := api.Database{Name: "shop"}
+	crm := api.Database{Name: "crm", Tags: "pii"}
+
+	tests := []struct {
+		selector Selector
+		database api.Database
+		match    bool
+	}{
+		{Selector{}, shop, true},
+		{Selector{Servers: []string{"db2"}}, shop, false},
+		{Selector{Databases: []string{"shop"}}, shop, true},
+		{Selector{Databases: []string{"shop"}}, crm, false},
+		{Selector{Databases: []string{"s*"}}, shop, true},
+		{Selector{Databases: []string{"db1/shop"}}, shop, true},
+		{Selector{Databases: []string{"db2/shop"}}, shop, false},
+		{Selector{Databases: []string{"*/crm", "shop"}}, crm, true},
+		{Selector{Tags: []string{"prod"}}, shop, true},
+		{Selector{Tags: []string{"pii"}}, shop, false},
+		{Selector{Tags: []string{"pii"}}, crm, true},
+		{Selector{Databases: []string{"shop"}, Tags: []string{"pii"}}, shop, false},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.selector.MatchDatabase(db1, test.database), test.match)
+	}
+}
+
+func TestSelectorValidate(t *testing.T) {
+	assert.NoError(t, Selector{Servers: []string{"db*"}, Databases: []string{"db1/[a-c]*"}}.Validate())
+	assert.Error(t, Selector{Databases: []string{"db1/[a-"}}.Validate())
+	assert.Error(t, Selector{Tags: []string{"["}}.Validate())
+}
@@ -0,0 +1,253 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jbaikge/database-backups/pkg/api"
+	"github.com/jbaikge/database-backups/pkg/logging"
+	"github.com/jbaikge/database-backups/pkg/tracing"
+)
+
+// backupTables dumps the schema and then each table to its own object under
+// the backup's prefix. Tables are dumped in separate transactions, so unlike
+// a single dump they are not a consistent snapshot of the whole database.
+//
+// Each object is uploaded as soon as it is dumped to keep disk use down to
+// one table, which means the size check can only happen once everything is
+// uploaded. A failed check leaves the objects without a manifest.
+func (r *Runner) backupTables(ctx context.Context, server api.Server, database api.Database, logId int, previousSize int64, manifest *Manifest) (warning string, err error) {
+	manifest.Key = tablesKey(server, database)
+
+	dir := filepath.Join(r.dumpDir, path.Base(manifest.Key))
+	if err = os.MkdirAll(filepath.Join(dir, "tables"), 0755); err != nil {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	tables := selectTables(manifest.Tables)
+
+	builds := make([]func(api.Server) ([][]string, error), 0, len(tables)+1)
+	manifest.Objects = make([]ManifestObject, 0, len(tables)+1)
+
+	manifest.Objects = append(manifest.Objects, ManifestObject{Key: manifest.Key + "/schema.sql"})
+	builds = append(builds, func(s api.Server) ([][]string, error) {
+		cmd, err := s.SchemaDumpCmd(database)
+		return [][]string{cmd}, err
+	})
+	for _, table := range tables {
+		name := table.Name
+		manifest.Objects = append(manifest.Objects, ManifestObject{
+			Table: name,
+			Key:   manifest.Key + "/tables/" + name + ".sql",
+		})
+		builds = append(builds, func(s api.Server) ([][]string, error) {
+			cmd, err := s.TableDumpCmd(database, name)
+			return [][]string{cmd}, err
+		})
+	}
+
+	for i := range manifest.Objects {
+		object := &manifest.Objects[i]
+		filename := objectFilename(dir, manifest.Key, object.Key)
+
+		if err = r.backupObject(ctx, server, database, logId, filename, object, builds[i]); err != nil {
+			return
+		}
+		manifest.Size += object.Size
+	}
+
+	warning, err = r.checkSizeDrop(ctx, previousSize, manifest.Size)
+	return
+}
+
+// backupObject dumps and uploads one object of a per-table dump, filling in
+// its checksum and size
+func (r *Runner) backupObject(ctx context.Context, server api.Server, database api.Database, logId int, filename string, object *ManifestObject, build func(api.Server) ([][]string, error)) (err error) {
+	ctx, span := tracing.Start(ctx, "object", tracing.String("object", objectName(database, *object)))
+	defer func() {
+		span.RecordError(err)
+		span.End()
+	}()
+	defer os.Remove(filename)
+
+	logging.Info(ctx, "Dumping object", "object", objectName(database, *object), "file", filename)
+	if object.SHA256, object.Size, err = r.dump(ctx, server, database, logId, filename, build); err != nil {
+		return
+	}
+	return r.upload(ctx, server, logId, filename, object.Key, object.SHA256)
+}
+
+// restoreTables loads a per-table dump. The schema goes first, then the
+// tables are loaded up to parallel at a time. When only one table is asked
+// for the schema is skipped, as each table's dump recreates it.
+func (r *Runner) restoreTables(ctx context.Context, server api.Server, manifest *Manifest, options RestoreOptions) error {
+	dir := filepath.Join(r.dumpDir, path.Base(manifest.Key))
+	if err := os.MkdirAll(filepath.Join(dir, "tables"), 0755); err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	if options.Table != "" {
+		for _, object := range manifest.Objects {
+			if object.Table == options.Table {
+				return r.restoreObject(ctx, server, manifest, object, dir, options.Target)
+			}
+		}
+		return fmt.Errorf("%s has no table %s", manifest.Key, options.Table)
+	}
+
+	tables := make([]ManifestObject, 0, len(manifest.Objects))
+	for _, object := range manifest.Objects {
+		if object.Table != "" {
+			tables = append(tables, object)
+			continue
+		}
+		if err := r.restoreObject(ctx, server, manifest, object, dir, options.Target); err != nil {
+			return err
+		}
+	}
+
+	parallel := options.Parallel
+	if parallel < 1 {
+		parallel = 1
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	jobs := make(chan ManifestObject)
+	errs := make(chan error, len(tables))
+	var wg sync.WaitGroup
+	for i := 0; i < parallel; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for object := range jobs {
+				if err := r.restoreObject(ctx, server, manifest, object, dir, options.Target); err != nil {
+					errs <- err
+					cancel()
+				}
+			}
+		}()
+	}
+
+queue:
+	for _, object := range tables {
+		select {
+		case jobs <- object:
+		case <-ctx.Done():
+			break queue
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	close(errs)
+
+	// The first error is the cause, any after it are from cancelling
+	if err := <-errs; err != nil {
+		return err
+	}
+	return ctx.Err()
+}
+
+func (r *Runner) restoreObject(ctx context.Context, server api.Server, manifest *Manifest, object ManifestObject, dir string, target string) error {
+	filename := objectFilename(dir, manifest.Key, object.Key)
+	defer os.Remove(filename)
+
+	logging.Info(ctx, "Downloading from S3", "bucket", r.bucket, "key", object.Key)
+	if err := r.fetch(ctx, object.Key, object.SHA256, filename); err != nil {
+		return err
+	}
+
+	logging.Info(ctx, "Loading dump", "file", path.Base(object.Key), "target", target, "server", server.Name)
+	return loadDump(ctx, server, target, filename)
+}
+
+// ResolveTables lists the database's tables on the server and works out how
+// each is backed up, including the table_exclude setting. The database is
+// returned with its rules resolved to table names and the relative dates in
+// their WHERE predicates filled in, ready for building dump commands.
+func ResolveTables(ctx context.Context, server api.Server, database api.Database, settings api.SettingService) (api.Database, []ManifestTable, error) {
+	defaults, err := api.DefaultTableRules(settings)
+	if err != nil {
+		return database, nil, err
+	}
+
+	tables, err := listTables(ctx, server, database)
+	if err != nil {
+		return database, nil, err
+	}
+
+	combined := database
+	combined.Tables = append(append(make([]api.TableRule, 0, len(database.Tables)+len(defaults)), database.Tables...), defaults...)
+	names := make([]string, len(tables))
+	for i, table := range tables {
+		names[i] = table.Name
+		tables[i].Mode = combined.TableModeFor(table.Name)
+		if rule, ok := combined.TableRuleFor(table.Name); ok {
+			tables[i].Rule = rule.Table
+		}
+	}
+
+	resolved, err := database.Resolve(names, defaults).ExpandWhere(time.Now())
+	if err != nil {
+		return database, nil, err
+	}
+	for i, table := range tables {
+		if rule, ok := resolved.TableRuleFor(table.Name); ok && table.Mode == api.TableModeFull {
+			tables[i].Where = rule.Where
+		}
+	}
+
+	return resolved, tables, nil
+}
+
+func listTables(ctx context.Context, server api.Server, database api.Database) ([]ManifestTable, error) {
+	args, err := server.DatabaseTablesCmd(database)
+	if err != nil {
+		return nil, err
+	}
+	output, err := runOutput(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	return parseTables(output), nil
+}
+
+// objectFilename mirrors the object's place under the backup's prefix so
+// the schema and a table called schema do not collide
+func objectFilename(dir string, prefix string, key string) string {
+	return filepath.Join(dir, filepath.FromSlash(strings.TrimPrefix(key, prefix+"/")))
+}
+
+func objectName(database api.Database, object ManifestObject) string {
+	if object.Table == "" {
+		return database.Name + " schema"
+	}
+	return database.Name + "." + object.Table
+}
+
+// selectTables returns the tables dumped with their data, each of which gets
+// its own object
+func selectTables(tables []ManifestTable) []ManifestTable {
+	selected := make([]ManifestTable, 0, len(tables))
+	for _, table := range tables {
+		if table.Mode == api.TableModeFull {
+			selected = append(selected, table)
+		}
+	}
+	return selected
+}
+
+// tablesKey is the prefix holding a per-table dump, the single dump's key
+// without its extension
+func tablesKey(server api.Server, database api.Database) string {
+	return strings.TrimSuffix(server.S3Key(database), ".sql")
+}
@@ -0,0 +1,28 @@
+package backup
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/jbaikge/database-backups/pkg/api"
+	"github.com/zeebo/assert"
+)
+
+func TestSelectTables(t *testing.T) {
+	tables := []ManifestTable{
+		{Name: "customers", Mode: api.TableModeFull},
+		{Name: "logs", Mode: api.TableModeSchema},
+		{Name: "orders", Mode: api.TableModeFull},
+		{Name: "sessions", Mode: api.TableModeSkip},
+	}
+	selected := selectTables(tables)
+	assert.DeepEqual(t, selected, []ManifestTable{tables[0], tables[2]})
+}
+
+func TestObjectFilename(t *testing.T) {
+	prefix := "db1/orders/db1_orders_2022-01-14"
+	schema := objectFilename("/tmp/dumps", prefix, prefix+"/schema.sql")
+	table := objectFilename("/tmp/dumps", prefix, prefix+"/tables/schema.sql")
+	assert.Equal(t, schema, filepath.Join("/tmp/dumps", "schema.sql"))
+	assert.Equal(t, table, filepath.Join("/tmp/dumps", "tables", "schema.sql"))
+}
@@ -0,0 +1,92 @@
+package backup
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Marker each dump tool writes at the very end of a complete dump
+var dumpTrailers = map[string]string{
+	"mysqldump": "-- Dump completed",
+	"pg_dump":   "-- PostgreSQL database dump complete",
+}
+
+// How far from the end of the file to look for the trailer
+const trailerWindow = 4096
+
+// ValidationError means a dump finished without error but does not look
+// complete. As this usually points at a dropped connection somewhere between
+// the server and here, it is worth retrying.
+type ValidationError struct {
+	Path   string
+	Reason string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("%s: %s", filepath.Base(e.Path), e.Reason)
+}
+
+func (e *ValidationError) Temporary() bool {
+	return true
+}
+
+// validateDump checks the output of the dump command args, written to path
+// from start on, is non-empty and ends with the trailer of the tool found in
+// args. Earlier commands' output is not looked at, so one of theirs cannot
+// pass for it. It returns the size of the whole file.
+func validateDump(path string, start int64, args []string) (int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	if info.Size() <= start {
+		return 0, &ValidationError{Path: path, Reason: "dump is empty"}
+	}
+
+	trailer := ""
+	for _, arg := range args {
+		if t, ok := dumpTrailers[filepath.Base(arg)]; ok {
+			trailer = t
+			break
+		}
+	}
+	if trailer == "" {
+		return info.Size(), nil
+	}
+
+	offset := info.Size() - trailerWindow
+	if offset < start {
+		offset = start
+	}
+	tail := make([]byte, info.Size()-offset)
+	if _, err := file.ReadAt(tail, offset); err != nil && err != io.EOF {
+		return 0, err
+	}
+	if !strings.Contains(string(tail), trailer) {
+		return 0, &ValidationError{Path: path, Reason: fmt.Sprintf("dump is incomplete, %q not found", trailer)}
+	}
+
+	return info.Size(), nil
+}
+
+// sizeDrop returns a description of the drop when the dump shrank by more
+// than threshold (a fraction) compared to the previous successful backup
+func sizeDrop(previous int64, current int64, threshold float64) string {
+	if previous <= 0 || threshold <= 0 {
+		return ""
+	}
+	drop := float64(previous-current) / float64(previous)
+	if drop <= threshold {
+		return ""
+	}
+	return fmt.Sprintf("dump shrank by %.0f%% from %d to %d bytes", drop*100, previous, current)
+}
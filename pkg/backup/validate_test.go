@@ -0,0 +1,79 @@
+package backup
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jbaikge/database-backups/pkg/api"
+	"github.com/zeebo/assert"
+)
+
+func TestValidateDump(t *testing.T) {
+	dir, err := ioutil.TempDir("", "validate")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	args := []string{"ssh", "-i", "id", "user@proxy", "mysqldump", "--single-transaction", "orders"}
+	path := filepath.Join(dir, "dump.sql")
+
+	assert.Nil(t, ioutil.WriteFile(path, nil, 0600))
+	_, err = validateDump(path, 0, args)
+	assert.Error(t, err)
+
+	assert.Nil(t, ioutil.WriteFile(path, []byte("CREATE TABLE a (id INT);\nINSERT INTO a VAL"), 0600))
+	_, err = validateDump(path, 0, args)
+	assert.Error(t, err)
+	assert.That(t, Retryable(err))
+
+	complete := "CREATE TABLE a (id INT);\n-- Dump completed on 2022-01-14 01:15:02\n"
+	assert.Nil(t, ioutil.WriteFile(path, []byte(complete), 0600))
+	size, err := validateDump(path, 0, args)
+	assert.Nil(t, err)
+	assert.Equal(t, size, int64(len(complete)))
+}
+
+func TestDumpDatabaseValidatesEachCommand(t *testing.T) {
+	dir, err := ioutil.TempDir("", "validate")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "dump.sql")
+
+	// sh gets mysqldump as $0, so the trailer is looked for
+	dump := func(output string) []string {
+		return []string{"sh", "-c", "printf '" + output + "'", "mysqldump"}
+	}
+	complete := "CREATE TABLE a (id INT);\\n-- Dump completed on 2022-01-14 01:15:02\\n"
+
+	tests := []struct {
+		second string
+		ok     bool
+	}{
+		{complete, true},
+		{"", false},
+		{"CREATE TABLE b (id INT);\\nINSERT INTO b VAL", false},
+	}
+
+	for _, test := range tests {
+		build := func(api.Server) ([][]string, error) {
+			return [][]string{dump(complete), dump(test.second)}, nil
+		}
+		_, size, err := dumpDatabase(context.Background(), api.Server{}, api.Database{}, path, build)
+		if test.ok {
+			assert.Nil(t, err)
+			assert.That(t, size > 0)
+		} else {
+			_, ok := err.(*ValidationError)
+			assert.That(t, ok)
+		}
+	}
+}
+
+func TestSizeDrop(t *testing.T) {
+	assert.Equal(t, sizeDrop(0, 100, 0.5), "")
+	assert.Equal(t, sizeDrop(1000, 600, 0.5), "")
+	assert.Equal(t, sizeDrop(1000, 400, 0.5), "dump shrank by 60% from 1000 to 400 bytes")
+	assert.Equal(t, sizeDrop(1000, 400, 0), "")
+}
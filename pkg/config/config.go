@@ -0,0 +1,420 @@
+// Package config exports the servers, databases, settings and webhooks held
+// in the configuration database as YAML, and applies such a file back
+// declaratively so the configuration can be kept under version control.
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/jbaikge/database-backups/pkg/api"
+	"gopkg.in/yaml.v2"
+)
+
+// How secrets are written by Export
+const (
+	SecretsEncrypted = "encrypted"
+	SecretsOmit      = "omit"
+)
+
+// Prefix marking a secret sealed with DATABASE_BACKUP_KEY
+const encryptedPrefix = "encrypted:"
+
+// Settings holding secrets, encrypted or omitted like server passwords
+var secretSettings = map[string]bool{
+	api.SettingSMTPPassword: true,
+}
+
+// Config is the whole configuration. Servers, their databases and webhooks
+// are matched by name, so names must be unique.
+type Config struct {
+	// Only settings differing from their defaults are exported
+	Settings map[string]string `yaml:"settings,omitempty"`
+	Servers  []Server          `yaml:"servers"`
+	Webhooks []Webhook         `yaml:"webhooks,omitempty"`
+}
+
+type Server struct {
+	Name              string     `yaml:"name"`
+	Host              string     `yaml:"host"`
+	Port              int        `yaml:"port"`
+	Username          string     `yaml:"username"`
+	Password          string     `yaml:"password,omitempty" secret:"true"`
+	ProxyHost         string     `yaml:"proxy_host,omitempty"`
+	ProxyUsername     string     `yaml:"proxy_username,omitempty"`
+	ProxyIdentity     string     `yaml:"proxy_identity,omitempty"`
+	Schedule          string     `yaml:"schedule,omitempty"`
+	Tags              string     `yaml:"tags,omitempty"`
+	Binlog            string     `yaml:"binlog,omitempty"`
+	DatabaseInclude   string     `yaml:"database_include,omitempty"`
+	DatabaseExclude   string     `yaml:"database_exclude,omitempty"`
+	NewDatabasePolicy string     `yaml:"new_database_policy,omitempty"`
+	ReportRecipients  string     `yaml:"report_recipients,omitempty"`
+	Databases         []Database `yaml:"databases,omitempty" diff:"-"`
+}
+
+type Database struct {
+	Name      string      `yaml:"name"`
+	Backup    bool        `yaml:"backup"`
+	Schedule  string      `yaml:"schedule,omitempty"`
+	Tags      string      `yaml:"tags,omitempty"`
+	Timeout   string      `yaml:"timeout,omitempty"`
+	MaxAge    string      `yaml:"max_age,omitempty"`
+	DumpMode  string      `yaml:"dump_mode,omitempty"`
+	TableMode string      `yaml:"table_mode,omitempty"`
+	Tables    []TableRule `yaml:"tables,omitempty"`
+	// Informational: applying any change to a pending database settles it
+	Pending bool `yaml:"pending,omitempty" diff:"-"`
+}
+
+type TableRule struct {
+	Table string `yaml:"table"`
+	Mode  string `yaml:"mode"`
+	Where string `yaml:"where,omitempty"`
+}
+
+type Webhook struct {
+	Name    string `yaml:"name"`
+	URL     string `yaml:"url,omitempty" secret:"true"`
+	Format  string `yaml:"format,omitempty"`
+	Events  string `yaml:"events,omitempty"`
+	Servers string `yaml:"servers,omitempty"`
+}
+
+// Services are what the configuration is read from and applied through
+type Services struct {
+	Servers   api.ServerService
+	Databases api.DatabaseService
+	Settings  api.SettingService
+	Webhooks  api.WebhookService
+}
+
+// Load reads a configuration, opening its encrypted secrets. Secrets left
+// out are kept as they are when the file is applied.
+func Load(r io.Reader) (*Config, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	config := new(Config)
+	if err := yaml.UnmarshalStrict(data, config); err != nil {
+		return nil, err
+	}
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	if err := config.open(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// Write writes the configuration as YAML
+func (c *Config) Write(w io.Writer) error {
+	var b bytes.Buffer
+	encoder := yaml.NewEncoder(&b)
+	if err := encoder.Encode(c); err != nil {
+		return err
+	}
+	if err := encoder.Close(); err != nil {
+		return err
+	}
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+// Export reads the current configuration. Removed databases are left out.
+// Secrets are sealed with DATABASE_BACKUP_KEY or omitted, according to
+// secrets.
+func (s Services) Export(secrets string) (*Config, error) {
+	if secrets != SecretsEncrypted && secrets != SecretsOmit {
+		return nil, fmt.Errorf("secrets must be %s or %s", SecretsEncrypted, SecretsOmit)
+	}
+
+	current, err := s.read()
+	if err != nil {
+		return nil, err
+	}
+	config := current.config
+	if err := config.seal(secrets); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// state is the configuration in use along with the ids needed to change it
+type state struct {
+	config      *Config
+	defaults    map[string]string
+	serverIds   map[string]int
+	databaseIds map[string]int
+	webhookIds  map[string]int
+	// Databases removed from their servers are left out of config, but still
+	// match entries in a file so they are not added again
+	removed map[string]Database
+}
+
+func databaseKey(server string, database string) string {
+	return server + "/" + database
+}
+
+// read returns the configuration with secrets as stored: server passwords
+// encrypted and everything else in the clear
+func (s Services) read() (*state, error) {
+	config := &Config{
+		Settings: make(map[string]string),
+		Servers:  make([]Server, 0, 16),
+		Webhooks: make([]Webhook, 0, 4),
+	}
+	current := &state{
+		config:      config,
+		defaults:    make(map[string]string),
+		serverIds:   make(map[string]int),
+		databaseIds: make(map[string]int),
+		webhookIds:  make(map[string]int),
+		removed:     make(map[string]Database),
+	}
+
+	settings, err := s.Settings.List()
+	if err != nil {
+		return nil, err
+	}
+	for _, setting := range settings {
+		current.defaults[setting.Key] = setting.Default
+		if setting.Value == setting.Default {
+			continue
+		}
+		// Listed as a placeholder, so read back and opened
+		if secretSettings[setting.Key] {
+			encrypted, err := s.Settings.Get(setting.Key)
+			if err != nil {
+				return nil, err
+			}
+			if setting.Value, err = api.DecryptSecret(encrypted); err != nil {
+				return nil, fmt.Errorf("settings: %s: %s", setting.Key, err)
+			}
+		}
+		config.Settings[setting.Key] = setting.Value
+	}
+
+	servers, err := s.Servers.List()
+	if err != nil {
+		return nil, err
+	}
+	for _, server := range servers {
+		databases, err := s.Databases.List(server.Id)
+		if err != nil {
+			return nil, err
+		}
+		current.serverIds[server.Name] = server.Id
+		exported := newServer(server)
+		for _, database := range databases {
+			key := databaseKey(server.Name, database.Name)
+			current.databaseIds[key] = database.Id
+			if database.Removed != nil {
+				current.removed[key] = newDatabase(database)
+				continue
+			}
+			exported.Databases = append(exported.Databases, newDatabase(database))
+		}
+		config.Servers = append(config.Servers, exported)
+	}
+
+	webhooks, err := s.Webhooks.List()
+	if err != nil {
+		return nil, err
+	}
+	for _, webhook := range webhooks {
+		current.webhookIds[webhook.Name] = webhook.Id
+		config.Webhooks = append(config.Webhooks, Webhook{
+			Name:    webhook.Name,
+			URL:     webhook.URL,
+			Format:  webhook.Format,
+			Events:  webhook.Events,
+			Servers: webhook.Servers,
+		})
+	}
+
+	config.sort()
+	return current, nil
+}
+
+func newServer(server api.Server) Server {
+	return Server{
+		Name:              server.Name,
+		Host:              server.Host,
+		Port:              server.Port,
+		Username:          server.Username,
+		Password:          server.Password,
+		ProxyHost:         server.ProxyHost,
+		ProxyUsername:     server.ProxyUsername,
+		ProxyIdentity:     server.ProxyIdentity,
+		Schedule:          server.Schedule,
+		Tags:              server.Tags,
+		Binlog:            server.Binlog,
+		DatabaseInclude:   server.DatabaseInclude,
+		DatabaseExclude:   server.DatabaseExclude,
+		NewDatabasePolicy: server.NewDatabasePolicy,
+		ReportRecipients:  server.ReportRecipients,
+	}
+}
+
+func newDatabase(database api.Database) Database {
+	exported := Database{
+		Name:      database.Name,
+		Backup:    database.Backup,
+		Schedule:  database.Schedule,
+		Tags:      database.Tags,
+		Timeout:   database.Timeout,
+		MaxAge:    database.MaxAge,
+		DumpMode:  database.DumpMode,
+		TableMode: database.TableMode,
+		Pending:   database.Pending,
+	}
+	for _, rule := range database.Tables {
+		exported.Tables = append(exported.Tables, TableRule{Table: rule.Table, Mode: rule.Mode, Where: rule.Where})
+	}
+	return exported
+}
+
+func (c *Config) sort() {
+	sort.Slice(c.Servers, func(i, j int) bool {
+		return c.Servers[i].Name < c.Servers[j].Name
+	})
+	for _, server := range c.Servers {
+		sort.Slice(server.Databases, func(i, j int) bool {
+			return server.Databases[i].Name < server.Databases[j].Name
+		})
+	}
+	sort.Slice(c.Webhooks, func(i, j int) bool {
+		return c.Webhooks[i].Name < c.Webhooks[j].Name
+	})
+}
+
+// validate checks names are present and unique, as entries are matched on
+// them
+func (c *Config) validate() error {
+	servers := make(map[string]bool, len(c.Servers))
+	for _, server := range c.Servers {
+		if server.Name == "" {
+			return fmt.Errorf("servers: name is required")
+		}
+		if servers[server.Name] {
+			return fmt.Errorf("servers: %s is listed twice", server.Name)
+		}
+		servers[server.Name] = true
+
+		databases := make(map[string]bool, len(server.Databases))
+		for _, database := range server.Databases {
+			if database.Name == "" {
+				return fmt.Errorf("%s: database name is required", server.Name)
+			}
+			if databases[database.Name] {
+				return fmt.Errorf("%s: database %s is listed twice", server.Name, database.Name)
+			}
+			databases[database.Name] = true
+		}
+	}
+
+	webhooks := make(map[string]bool, len(c.Webhooks))
+	for _, webhook := range c.Webhooks {
+		if webhook.Name == "" {
+			return fmt.Errorf("webhooks: name is required")
+		}
+		if webhooks[webhook.Name] {
+			return fmt.Errorf("webhooks: %s is listed twice", webhook.Name)
+		}
+		webhooks[webhook.Name] = true
+	}
+	return nil
+}
+
+// seal encrypts or blanks the secrets of a configuration read by current.
+// Server passwords are already encrypted and only need marking.
+func (c *Config) seal(secrets string) error {
+	for key, value := range c.Settings {
+		if !secretSettings[key] {
+			continue
+		}
+		if secrets == SecretsOmit {
+			delete(c.Settings, key)
+			continue
+		}
+		encrypted, err := api.EncryptSecret(value)
+		if err != nil {
+			return fmt.Errorf("settings: %s: %s", key, err)
+		}
+		c.Settings[key] = encryptedPrefix + encrypted
+	}
+
+	for i := range c.Servers {
+		server := &c.Servers[i]
+		if secrets == SecretsOmit || server.Password == "" {
+			server.Password = ""
+			continue
+		}
+		server.Password = encryptedPrefix + server.Password
+	}
+
+	for i := range c.Webhooks {
+		webhook := &c.Webhooks[i]
+		if secrets == SecretsOmit {
+			webhook.URL = ""
+			continue
+		}
+		encrypted, err := api.EncryptSecret(webhook.URL)
+		if err != nil {
+			return fmt.Errorf("webhooks: %s: %s", webhook.Name, err)
+		}
+		webhook.URL = encryptedPrefix + encrypted
+	}
+	return nil
+}
+
+// open undoes seal, leaving secrets as they are stored. Server passwords
+// must be encrypted, as they are stored that way; other secrets may be
+// written in the clear.
+func (c *Config) open() error {
+	for key, value := range c.Settings {
+		if !strings.HasPrefix(value, encryptedPrefix) {
+			continue
+		}
+		decrypted, err := api.DecryptSecret(strings.TrimPrefix(value, encryptedPrefix))
+		if err != nil {
+			return fmt.Errorf("settings: %s: %s", key, err)
+		}
+		c.Settings[key] = decrypted
+	}
+
+	for i := range c.Servers {
+		server := &c.Servers[i]
+		if server.Password == "" {
+			continue
+		}
+		if !strings.HasPrefix(server.Password, encryptedPrefix) {
+			return fmt.Errorf("%s: password must be %s followed by the encrypted password", server.Name, encryptedPrefix)
+		}
+		server.Password = strings.TrimPrefix(server.Password, encryptedPrefix)
+		if _, err := api.DecryptSecret(server.Password); err != nil {
+			return fmt.Errorf("%s: password: %s", server.Name, err)
+		}
+	}
+
+	for i := range c.Webhooks {
+		webhook := &c.Webhooks[i]
+		if !strings.HasPrefix(webhook.URL, encryptedPrefix) {
+			continue
+		}
+		decrypted, err := api.DecryptSecret(strings.TrimPrefix(webhook.URL, encryptedPrefix))
+		if err != nil {
+			return fmt.Errorf("webhooks: %s: url: %s", webhook.Name, err)
+		}
+		webhook.URL = decrypted
+	}
+	return nil
+}
@@ -0,0 +1,196 @@
+package config_test
+
+import (
+	"bytes"
+	"database/sql"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/jbaikge/database-backups/pkg/api"
+	"github.com/jbaikge/database-backups/pkg/config"
+	"github.com/jbaikge/database-backups/pkg/notify"
+	"github.com/jbaikge/database-backups/pkg/repository"
+	"github.com/zeebo/assert"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const testKey = "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"
+
+func setup(t *testing.T) config.Services {
+	os.Setenv("DATABASE_BACKUP_KEY", testKey)
+
+	db, err := sql.Open("sqlite3", ":memory:")
+	assert.NoError(t, err)
+	db.SetMaxOpenConns(1)
+	storage := repository.NewStorage(db)
+	assert.NoError(t, storage.RunMigrations())
+
+	settings := api.NewSettingService(storage)
+	services := config.Services{
+		Servers:   api.NewServerService(storage, settings, notify.Log{}),
+		Databases: api.NewDatabaseService(storage),
+		Settings:  settings,
+		Webhooks:  api.NewWebhookService(storage),
+	}
+
+	password, err := api.EncryptSecret("s3cret")
+	assert.NoError(t, err)
+	server, err := services.Servers.New(api.NewServerRequest{
+		Name:     "db1",
+		Host:     "db1.example.com",
+		Port:     3306,
+		Username: "backup",
+		Password: password,
+	})
+	assert.NoError(t, err)
+
+	for _, name := range []string{"orders", "shop"} {
+		assert.NoError(t, services.Databases.New(api.NewDatabaseRequest{ServerId: server.Id, Name: name, Backup: true}))
+	}
+	assert.NoError(t, services.Databases.Update(2, api.UpdateDatabaseRequest{
+		ServerId:  server.Id,
+		Name:      "shop",
+		Backup:    true,
+		DumpMode:  api.DumpModePerTable,
+		TableMode: api.TableModeFull,
+		Tables:    []api.TableRule{{Table: "cache_*", Mode: api.TableModeSkip}},
+	}))
+
+	assert.NoError(t, settings.Set(api.SettingMaxAge, "48h"))
+	assert.NoError(t, settings.Set(api.SettingSMTPPassword, "hunter2"))
+
+	_, err = services.Webhooks.New(api.NewWebhookRequest{Name: "chat", URL: "https://hooks.example.com/T0/B0/token"})
+	assert.NoError(t, err)
+
+	return services
+}
+
+func roundTrip(t *testing.T, services config.Services, secrets string) string {
+	exported, err := services.Export(secrets)
+	assert.NoError(t, err)
+
+	var b bytes.Buffer
+	assert.NoError(t, exported.Write(&b))
+	text := b.String()
+
+	loaded, err := config.Load(strings.NewReader(text))
+	assert.NoError(t, err)
+	changes, err := services.Plan(loaded, true)
+	assert.NoError(t, err)
+	assert.Equal(t, len(changes), 0)
+	return text
+}
+
+func TestRoundTrip(t *testing.T) {
+	services := setup(t)
+
+	text := roundTrip(t, services, config.SecretsEncrypted)
+	assert.False(t, strings.Contains(text, "hunter2"))
+	assert.False(t, strings.Contains(text, "token"))
+	assert.That(t, strings.Contains(text, "smtp_password: encrypted:"))
+	assert.That(t, strings.Contains(text, "password: encrypted:"))
+	assert.That(t, strings.Contains(text, "max_age: 48h"))
+
+	text = roundTrip(t, services, config.SecretsOmit)
+	assert.False(t, strings.Contains(text, "password"))
+	assert.False(t, strings.Contains(text, "url:"))
+}
+
+func TestImport(t *testing.T) {
+	services := setup(t)
+
+	desired, err := config.Load(strings.NewReader(`
+settings:
+  retry_attempts_dump: "5"
+servers:
+  - name: db1
+    host: db1.example.com
+    port: 3307
+    username: backup
+    databases:
+      - name: shop
+        backup: true
+        dump_mode: per-table
+        tables:
+          - table: cache_*
+            mode: skip
+      - name: reports
+        backup: false
+        max_age: 168h
+  - name: db2
+    host: db2.example.com
+    port: 3306
+    username: backup
+    databases:
+      - name: crm
+        backup: true
+`))
+	assert.NoError(t, err)
+
+	changes, err := services.Plan(desired, false)
+	assert.NoError(t, err)
+	lines := make([]string, len(changes))
+	for i, change := range changes {
+		lines[i] = change.String()
+	}
+	assert.Equal(t, lines, []string{
+		`~ setting retry_attempts_dump: value: "3" -> "5"`,
+		`~ server db1: port: 3306 -> 3307`,
+		`+ database db1/reports`,
+		`+ server db2`,
+		`+ database db2/crm`,
+	})
+
+	changes, err = services.Plan(desired, true)
+	assert.NoError(t, err)
+	lines = make([]string, len(changes))
+	for i, change := range changes {
+		lines[i] = change.String()
+	}
+	assert.Equal(t, lines[5:], []string{
+		`- setting max_age: back to default: "48h" -> "36h"`,
+		`- database db1/orders`,
+		`- webhook chat`,
+	})
+
+	assert.NoError(t, config.Apply(changes))
+	changes, err = services.Plan(desired, true)
+	assert.NoError(t, err)
+	assert.Equal(t, len(changes), 0)
+
+	// The password was left out of the file, so it is kept
+	server, err := services.Servers.Get(1)
+	assert.NoError(t, err)
+	password, err := server.DecryptPassword()
+	assert.NoError(t, err)
+	assert.Equal(t, password, "s3cret")
+	smtpPassword, err := services.Settings.Get(api.SettingSMTPPassword)
+	assert.NoError(t, err)
+	smtpPassword, err = api.DecryptSecret(smtpPassword)
+	assert.NoError(t, err)
+	assert.Equal(t, smtpPassword, "hunter2")
+
+	databases, err := services.Databases.List(2)
+	assert.NoError(t, err)
+	assert.Equal(t, len(databases), 1)
+	assert.Equal(t, databases[0].Name, "crm")
+	assert.That(t, databases[0].Backup)
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		yaml  string
+		error string
+	}{
+		{"servers:\n  - name: a\n  - name: a\n", "listed twice"},
+		{"servers:\n  - name: a\n    password: plain\n", "must be encrypted:"},
+		{"servers:\n  - name: a\n    colour: blue\n", "colour"},
+	}
+	for _, test := range tests {
+		_, err := config.Load(strings.NewReader(test.yaml))
+		assert.Error(t, err)
+		assert.That(t, strings.Contains(err.Error(), test.error))
+	}
+}
@@ -0,0 +1,481 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/jbaikge/database-backups/pkg/api"
+)
+
+const (
+	ActionAdd    = "add"
+	ActionUpdate = "update"
+	ActionRemove = "remove"
+)
+
+var actionSymbols = map[string]string{
+	ActionAdd:    "+",
+	ActionUpdate: "~",
+	ActionRemove: "-",
+}
+
+// Change is one difference between the configuration in use and a file,
+// along with how to make it
+type Change struct {
+	Action string
+	// setting, server, database or webhook
+	Kind string
+	// Databases are named server/database
+	Name string
+	// What differs. Secrets are only said to have changed.
+	Fields []string
+
+	apply func() error
+}
+
+func (c Change) String() string {
+	line := actionSymbols[c.Action] + " " + c.Kind + " " + c.Name
+	if len(c.Fields) > 0 {
+		line += ": " + strings.Join(c.Fields, ", ")
+	}
+	return line
+}
+
+// Apply makes the changes in order, stopping at the first to fail. Changes
+// are not made in a transaction, so those before a failure stay made.
+func Apply(changes []Change) error {
+	for _, change := range changes {
+		if err := change.apply(); err != nil {
+			return fmt.Errorf("%s %s %s: %s", change.Action, change.Kind, change.Name, err)
+		}
+	}
+	return nil
+}
+
+// Plan lists the changes making the configuration in use match desired:
+// settings first, then servers with their databases, then webhooks, and
+// removals last. With prune, whatever desired leaves out is removed, or
+// reset to its default for settings. Secrets left out of desired are never
+// changed.
+func (s Services) Plan(desired *Config, prune bool) ([]Change, error) {
+	current, err := s.read()
+	if err != nil {
+		return nil, err
+	}
+
+	p := &planner{services: s, current: current}
+	if err := p.settings(desired.Settings, prune); err != nil {
+		return nil, err
+	}
+	p.servers(desired.Servers, prune)
+	p.webhooks(desired.Webhooks, prune)
+	return append(p.changes, p.removals...), nil
+}
+
+type planner struct {
+	services Services
+	current  *state
+	changes  []Change
+	// Made after every other change
+	removals []Change
+}
+
+func (p *planner) add(change Change) {
+	if change.Action == ActionRemove {
+		p.removals = append(p.removals, change)
+		return
+	}
+	p.changes = append(p.changes, change)
+}
+
+func (p *planner) settings(desired map[string]string, prune bool) error {
+	keys := make([]string, 0, len(desired))
+	for key := range desired {
+		if _, ok := p.current.defaults[key]; !ok {
+			return fmt.Errorf("settings: unknown setting: %s", key)
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		key, value := key, desired[key]
+		current, ok := p.current.config.Settings[key]
+		if !ok {
+			current = p.current.defaults[key]
+		}
+		if value == current {
+			continue
+		}
+		p.add(Change{
+			Action: ActionUpdate,
+			Kind:   "setting",
+			Name:   key,
+			Fields: []string{describeValue("value", current, value, secretSettings[key])},
+			apply: func() error {
+				return p.services.Settings.Set(key, value)
+			},
+		})
+	}
+
+	if !prune {
+		return nil
+	}
+	stored := make([]string, 0, len(p.current.config.Settings))
+	for key := range p.current.config.Settings {
+		if _, ok := desired[key]; !ok && !secretSettings[key] {
+			stored = append(stored, key)
+		}
+	}
+	sort.Strings(stored)
+	for _, key := range stored {
+		key, value := key, p.current.defaults[key]
+		p.add(Change{
+			Action: ActionRemove,
+			Kind:   "setting",
+			Name:   key,
+			Fields: []string{describeValue("back to default", p.current.config.Settings[key], value, false)},
+			apply: func() error {
+				return p.services.Settings.Set(key, value)
+			},
+		})
+	}
+	return nil
+}
+
+func (p *planner) servers(desired []Server, prune bool) {
+	currentServers := make(map[string]Server, len(p.current.config.Servers))
+	for _, server := range p.current.config.Servers {
+		currentServers[server.Name] = server
+	}
+
+	wanted := make(map[string]bool, len(desired))
+	for _, server := range desired {
+		server := server
+		wanted[server.Name] = true
+		if server.NewDatabasePolicy == "" {
+			server.NewDatabasePolicy = api.NewDatabasePolicyEnable
+		}
+
+		current, ok := currentServers[server.Name]
+		switch {
+		case !ok:
+			p.add(Change{
+				Action: ActionAdd,
+				Kind:   "server",
+				Name:   server.Name,
+				apply: func() error {
+					created, err := p.services.Servers.New(serverRequest(server))
+					if err != nil {
+						return err
+					}
+					p.current.serverIds[server.Name] = created.Id
+					return nil
+				},
+			})
+		default:
+			if server.Password == "" {
+				server.Password = current.Password
+			}
+			fields := diffFields(current, server)
+			if len(fields) == 0 {
+				break
+			}
+			id := p.current.serverIds[server.Name]
+			p.add(Change{
+				Action: ActionUpdate,
+				Kind:   "server",
+				Name:   server.Name,
+				Fields: fields,
+				apply: func() error {
+					return p.services.Servers.Update(id, serverRequest(server))
+				},
+			})
+		}
+
+		p.databases(server.Name, current.Databases, server.Databases, prune)
+	}
+
+	if !prune {
+		return
+	}
+	for _, server := range p.current.config.Servers {
+		if wanted[server.Name] {
+			continue
+		}
+		id := p.current.serverIds[server.Name]
+		p.add(Change{
+			Action: ActionRemove,
+			Kind:   "server",
+			Name:   server.Name,
+			Fields: []string{fmt.Sprintf("with its %d databases", len(server.Databases))},
+			apply: func() error {
+				return p.services.Servers.Delete(id)
+			},
+		})
+	}
+}
+
+// databases plans the databases of one server. Databases are normally added
+// by refreshing the server; adding one here lets its settings be made ahead
+// of that.
+func (p *planner) databases(serverName string, current []Database, desired []Database, prune bool) {
+	currentDatabases := make(map[string]Database, len(current))
+	for _, database := range current {
+		currentDatabases[database.Name] = database
+	}
+
+	wanted := make(map[string]bool, len(desired))
+	for _, database := range desired {
+		database := database
+		wanted[database.Name] = true
+		if database.DumpMode == "" {
+			database.DumpMode = api.DumpModeSingle
+		}
+		if database.TableMode == "" {
+			database.TableMode = api.TableModeFull
+		}
+
+		key := databaseKey(serverName, database.Name)
+		existing, ok := currentDatabases[database.Name]
+		if !ok {
+			existing, ok = p.current.removed[key]
+		}
+		if !ok {
+			p.add(Change{
+				Action: ActionAdd,
+				Kind:   "database",
+				Name:   key,
+				apply: func() error {
+					return p.addDatabase(serverName, database)
+				},
+			})
+			continue
+		}
+
+		fields := diffFields(existing, database)
+		if len(fields) == 0 {
+			continue
+		}
+		if _, removed := p.current.removed[key]; removed {
+			fields = append(fields, "still removed from the server")
+		}
+		id := p.current.databaseIds[key]
+		p.add(Change{
+			Action: ActionUpdate,
+			Kind:   "database",
+			Name:   key,
+			Fields: fields,
+			apply: func() error {
+				return p.services.Databases.Update(id, p.databaseRequest(serverName, database))
+			},
+		})
+	}
+
+	if !prune {
+		return
+	}
+	for _, database := range current {
+		if wanted[database.Name] {
+			continue
+		}
+		key := databaseKey(serverName, database.Name)
+		id := p.current.databaseIds[key]
+		p.add(Change{
+			Action: ActionRemove,
+			Kind:   "database",
+			Name:   key,
+			apply: func() error {
+				return p.services.Databases.Delete(id)
+			},
+		})
+	}
+}
+
+func (p *planner) addDatabase(serverName string, database Database) error {
+	serverId := p.current.serverIds[serverName]
+	err := p.services.Databases.New(api.NewDatabaseRequest{
+		ServerId: serverId,
+		Name:     database.Name,
+		Backup:   database.Backup,
+	})
+	if err != nil {
+		return err
+	}
+
+	databases, err := p.services.Databases.List(serverId)
+	if err != nil {
+		return err
+	}
+	for _, created := range databases {
+		if created.Name == database.Name {
+			return p.services.Databases.Update(created.Id, p.databaseRequest(serverName, database))
+		}
+	}
+	return fmt.Errorf("%s was not created", database.Name)
+}
+
+func (p *planner) webhooks(desired []Webhook, prune bool) {
+	currentWebhooks := make(map[string]Webhook, len(p.current.config.Webhooks))
+	for _, webhook := range p.current.config.Webhooks {
+		currentWebhooks[webhook.Name] = webhook
+	}
+
+	wanted := make(map[string]bool, len(desired))
+	for _, webhook := range desired {
+		webhook := webhook
+		wanted[webhook.Name] = true
+		if webhook.Format == "" {
+			webhook.Format = api.WebhookFormatJSON
+		}
+
+		current, ok := currentWebhooks[webhook.Name]
+		if !ok {
+			p.add(Change{
+				Action: ActionAdd,
+				Kind:   "webhook",
+				Name:   webhook.Name,
+				apply: func() error {
+					_, err := p.services.Webhooks.New(webhookRequest(webhook))
+					return err
+				},
+			})
+			continue
+		}
+
+		if webhook.URL == "" {
+			webhook.URL = current.URL
+		}
+		fields := diffFields(current, webhook)
+		if len(fields) == 0 {
+			continue
+		}
+		id := p.current.webhookIds[webhook.Name]
+		p.add(Change{
+			Action: ActionUpdate,
+			Kind:   "webhook",
+			Name:   webhook.Name,
+			Fields: fields,
+			apply: func() error {
+				return p.services.Webhooks.Update(id, webhookRequest(webhook))
+			},
+		})
+	}
+
+	if !prune {
+		return
+	}
+	for _, webhook := range p.current.config.Webhooks {
+		if wanted[webhook.Name] {
+			continue
+		}
+		id := p.current.webhookIds[webhook.Name]
+		p.add(Change{
+			Action: ActionRemove,
+			Kind:   "webhook",
+			Name:   webhook.Name,
+			apply: func() error {
+				return p.services.Webhooks.Delete(id)
+			},
+		})
+	}
+}
+
+func serverRequest(server Server) api.NewServerRequest {
+	return api.NewServerRequest{
+		Name:              server.Name,
+		Host:              server.Host,
+		Port:              server.Port,
+		Username:          server.Username,
+		Password:          server.Password,
+		ProxyHost:         server.ProxyHost,
+		ProxyUsername:     server.ProxyUsername,
+		ProxyIdentity:     server.ProxyIdentity,
+		Schedule:          server.Schedule,
+		Tags:              server.Tags,
+		Binlog:            server.Binlog,
+		DatabaseInclude:   server.DatabaseInclude,
+		DatabaseExclude:   server.DatabaseExclude,
+		NewDatabasePolicy: server.NewDatabasePolicy,
+		ReportRecipients:  server.ReportRecipients,
+	}
+}
+
+// databaseRequest looks the server up when the change is made, as it may
+// only just have been added
+func (p *planner) databaseRequest(serverName string, database Database) api.UpdateDatabaseRequest {
+	request := api.UpdateDatabaseRequest{
+		ServerId:  p.current.serverIds[serverName],
+		Name:      database.Name,
+		Backup:    database.Backup,
+		Schedule:  database.Schedule,
+		Tags:      database.Tags,
+		Timeout:   database.Timeout,
+		MaxAge:    database.MaxAge,
+		DumpMode:  database.DumpMode,
+		TableMode: database.TableMode,
+		Tables:    make([]api.TableRule, len(database.Tables)),
+	}
+	for i, rule := range database.Tables {
+		request.Tables[i] = api.TableRule{Table: rule.Table, Mode: rule.Mode, Where: rule.Where}
+	}
+	return request
+}
+
+func webhookRequest(webhook Webhook) api.NewWebhookRequest {
+	return api.NewWebhookRequest{
+		Name:    webhook.Name,
+		URL:     webhook.URL,
+		Format:  webhook.Format,
+		Events:  webhook.Events,
+		Servers: webhook.Servers,
+	}
+}
+
+// diffFields describes the fields differing between two values of the same
+// struct type, by their YAML names. Nested lists are only said to have
+// changed, and fields tagged diff:"-" are skipped.
+func diffFields(current interface{}, desired interface{}) []string {
+	currentValue := reflect.ValueOf(current)
+	desiredValue := reflect.ValueOf(desired)
+	t := currentValue.Type()
+
+	fields := make([]string, 0, 4)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Tag.Get("diff") == "-" {
+			continue
+		}
+		a, b := currentValue.Field(i), desiredValue.Field(i)
+		if field.Type.Kind() == reflect.Slice {
+			if a.Len() == 0 && b.Len() == 0 {
+				continue
+			}
+		}
+		if reflect.DeepEqual(a.Interface(), b.Interface()) {
+			continue
+		}
+
+		name := strings.Split(field.Tag.Get("yaml"), ",")[0]
+		if field.Type.Kind() == reflect.Slice {
+			fields = append(fields, name+" changed")
+			continue
+		}
+		fields = append(fields, describeValue(name, a.Interface(), b.Interface(), field.Tag.Get("secret") == "true"))
+	}
+	return fields
+}
+
+// describeValue shows a change of value, quoting strings so empty ones show
+func describeValue(name string, current interface{}, desired interface{}, secret bool) string {
+	if secret {
+		return name + " changed"
+	}
+	format := "%s: %v -> %v"
+	if _, ok := current.(string); ok {
+		format = "%s: %q -> %q"
+	}
+	return fmt.Sprintf(format, name, current, desired)
+}
@@ -0,0 +1,45 @@
+package cron_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jbaikge/database-backups/pkg/cron"
+	"github.com/zeebo/assert"
+)
+
+func TestNext(t *testing.T) {
+	base := time.Date(2022, time.January, 14, 10, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		expr string
+		next time.Time
+	}{
+		{"@hourly", time.Date(2022, time.January, 14, 11, 0, 0, 0, time.UTC)},
+		{"15 1 * * *", time.Date(2022, time.January, 15, 1, 15, 0, 0, time.UTC)},
+		{"*/20 * * * *", time.Date(2022, time.January, 14, 10, 40, 0, 0, time.UTC)},
+		{"0 3 * * sun", time.Date(2022, time.January, 16, 3, 0, 0, 0, time.UTC)},
+		{"0 3 * * 7", time.Date(2022, time.January, 16, 3, 0, 0, 0, time.UTC)},
+		{"0 0 1 feb-mar *", time.Date(2022, time.February, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2024, time.February, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 0 30 2 *", time.Time{}},
+		{"0 0 1,15 * mon", time.Date(2022, time.January, 15, 0, 0, 0, 0, time.UTC)},
+		{"*/1 * * * 1", time.Date(2022, time.January, 17, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1-31 * mon", time.Date(2022, time.January, 17, 0, 0, 0, 0, time.UTC)},
+		{"0 0 15 * 0-6", time.Date(2022, time.January, 15, 0, 0, 0, 0, time.UTC)},
+		{"0 0 15 * */1", time.Date(2022, time.January, 15, 0, 0, 0, 0, time.UTC)},
+	}
+
+	for _, test := range tests {
+		schedule, err := cron.Parse(test.expr)
+		assert.Nil(t, err)
+		assert.Equal(t, schedule.Next(base), test.next)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, expr := range []string{"", "* * * *", "60 * * * *", "* * * * mon-", "*/0 * * * *", "5-1 * * * *"} {
+		_, err := cron.Parse(expr)
+		assert.Error(t, err)
+	}
+}
@@ -0,0 +1,276 @@
+// Package logging writes structured log lines as logfmt-style text or JSON.
+// Loggers carry fields such as the run ID or the database being backed up,
+// and travel in contexts so every line written on behalf of a backup is
+// tagged with them.
+package logging
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+type Level int
+
+const (
+	LevelDebug Level = -4
+	LevelInfo  Level = 0
+	LevelWarn  Level = 4
+	LevelError Level = 8
+)
+
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "DEBUG"
+	case LevelInfo:
+		return "INFO"
+	case LevelWarn:
+		return "WARN"
+	case LevelError:
+		return "ERROR"
+	}
+	return fmt.Sprintf("LEVEL(%d)", int(l))
+}
+
+func ParseLevel(value string) (Level, error) {
+	switch strings.ToLower(value) {
+	case "debug":
+		return LevelDebug, nil
+	case "info":
+		return LevelInfo, nil
+	case "warn", "warning":
+		return LevelWarn, nil
+	case "error":
+		return LevelError, nil
+	}
+	return 0, fmt.Errorf("unknown log level: %s", value)
+}
+
+const (
+	FormatJSON = "json"
+	FormatText = "text"
+)
+
+type field struct {
+	key   string
+	value interface{}
+}
+
+// output is shared by a logger and everything derived from it with With
+type output struct {
+	mu     sync.Mutex
+	w      io.Writer
+	format string
+	level  Level
+	now    func() time.Time
+}
+
+type Logger struct {
+	out    *output
+	fields []field
+}
+
+// New returns a logger writing lines at level and above to w in format
+func New(w io.Writer, format string, level Level) (*Logger, error) {
+	if format != FormatJSON && format != FormatText {
+		return nil, fmt.Errorf("unknown log format: %s", format)
+	}
+	return &Logger{
+		out: &output{w: w, format: format, level: level, now: time.Now},
+	}, nil
+}
+
+// With returns a logger adding the alternating keys and values to every line
+func (l *Logger) With(keyvals ...interface{}) *Logger {
+	fields := make([]field, len(l.fields), len(l.fields)+len(keyvals)/2+1)
+	copy(fields, l.fields)
+	return &Logger{
+		out:    l.out,
+		fields: append(fields, pairs(keyvals)...),
+	}
+}
+
+func (l *Logger) Enabled(level Level) bool {
+	return level >= l.out.level
+}
+
+func (l *Logger) Debug(msg string, keyvals ...interface{}) {
+	l.Log(LevelDebug, msg, keyvals...)
+}
+
+func (l *Logger) Info(msg string, keyvals ...interface{}) {
+	l.Log(LevelInfo, msg, keyvals...)
+}
+
+func (l *Logger) Warn(msg string, keyvals ...interface{}) {
+	l.Log(LevelWarn, msg, keyvals...)
+}
+
+func (l *Logger) Error(msg string, keyvals ...interface{}) {
+	l.Log(LevelError, msg, keyvals...)
+}
+
+// Log writes one line. Secrets are redacted from the message and every
+// field, see Redact.
+func (l *Logger) Log(level Level, msg string, keyvals ...interface{}) {
+	if !l.Enabled(level) {
+		return
+	}
+
+	fields := make([]field, 0, 3+len(l.fields)+len(keyvals)/2+1)
+	fields = append(fields,
+		field{"time", l.out.now().UTC().Format("2006-01-02T15:04:05.000Z07:00")},
+		field{"level", level.String()},
+		field{"msg", RedactString(msg)},
+	)
+	for _, f := range append(l.fields, pairs(keyvals)...) {
+		fields = append(fields, field{f.key, Redact(f.key, f.value)})
+	}
+
+	var b bytes.Buffer
+	if l.out.format == FormatJSON {
+		writeJSON(&b, fields)
+	} else {
+		writeText(&b, fields)
+	}
+
+	l.out.mu.Lock()
+	defer l.out.mu.Unlock()
+	l.out.w.Write(b.Bytes())
+}
+
+// pairs turns alternating keys and values into fields. A value without a key
+// is kept under !BADKEY rather than dropped.
+func pairs(keyvals []interface{}) []field {
+	fields := make([]field, 0, len(keyvals)/2+1)
+	for i := 0; i < len(keyvals); i += 2 {
+		if i+1 == len(keyvals) {
+			fields = append(fields, field{"!BADKEY", keyvals[i]})
+			break
+		}
+		fields = append(fields, field{fmt.Sprint(keyvals[i]), keyvals[i+1]})
+	}
+	return fields
+}
+
+func writeJSON(b *bytes.Buffer, fields []field) {
+	b.WriteByte('{')
+	for i, f := range fields {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		key, _ := json.Marshal(f.key)
+		b.Write(key)
+		b.WriteByte(':')
+		value, err := json.Marshal(f.value)
+		if err != nil {
+			value, _ = json.Marshal(fmt.Sprint(f.value))
+		}
+		b.Write(value)
+	}
+	b.WriteString("}\n")
+}
+
+func writeText(b *bytes.Buffer, fields []field) {
+	for i, f := range fields {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(f.key)
+		b.WriteByte('=')
+		value := fmt.Sprint(f.value)
+		if needsQuoting(value) {
+			value = strconv.Quote(value)
+		}
+		b.WriteString(value)
+	}
+	b.WriteByte('\n')
+}
+
+func needsQuoting(value string) bool {
+	if value == "" {
+		return true
+	}
+	for _, r := range value {
+		if unicode.IsSpace(r) || r == '"' || r == '=' || !unicode.IsPrint(r) {
+			return true
+		}
+	}
+	return false
+}
+
+// NewID returns a short random identifier for correlating the lines of a run
+// or job
+func NewID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return hex.EncodeToString(b)
+}
+
+var (
+	defaultMu     sync.RWMutex
+	defaultLogger = &Logger{
+		out: &output{w: os.Stderr, format: FormatText, level: LevelInfo, now: time.Now},
+	}
+)
+
+// Default returns the logger used when a context carries none: text at info
+// level on stderr until SetDefault is called
+func Default() *Logger {
+	defaultMu.RLock()
+	defer defaultMu.RUnlock()
+	return defaultLogger
+}
+
+func SetDefault(l *Logger) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	defaultLogger = l
+}
+
+type contextKey struct{}
+
+func NewContext(ctx context.Context, l *Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, l)
+}
+
+func FromContext(ctx context.Context) *Logger {
+	if l, ok := ctx.Value(contextKey{}).(*Logger); ok {
+		return l
+	}
+	return Default()
+}
+
+// With returns a context whose logger adds the keys and values to every line
+func With(ctx context.Context, keyvals ...interface{}) context.Context {
+	return NewContext(ctx, FromContext(ctx).With(keyvals...))
+}
+
+func Debug(ctx context.Context, msg string, keyvals ...interface{}) {
+	FromContext(ctx).Log(LevelDebug, msg, keyvals...)
+}
+
+func Info(ctx context.Context, msg string, keyvals ...interface{}) {
+	FromContext(ctx).Log(LevelInfo, msg, keyvals...)
+}
+
+func Warn(ctx context.Context, msg string, keyvals ...interface{}) {
+	FromContext(ctx).Log(LevelWarn, msg, keyvals...)
+}
+
+func Error(ctx context.Context, msg string, keyvals ...interface{}) {
+	FromContext(ctx).Log(LevelError, msg, keyvals...)
+}
@@ -0,0 +1,61 @@
+package logging
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const redacted = "[REDACTED]"
+
+// Fields whose names contain any of these are never written
+var secretKeys = []string{"authorization", "passwd", "password", "secret", "token"}
+
+var secretPatterns = []struct {
+	re      *regexp.Regexp
+	replace string
+}{
+	// mysql --password=... as found in commands
+	{regexp.MustCompile(`(--password=)\S+`), "${1}" + redacted},
+	// Credentials in URLs
+	{regexp.MustCompile(`(://[^/:@\s]+:)[^/@\s]+@`), "${1}" + redacted + "@"},
+	// password=..., token: ... and the like in messages
+	{regexp.MustCompile(`(?i)((?:password|passwd|secret|token)["']?\s*[:=]\s*["']?)[^\s"',&]+`), "${1}" + redacted},
+}
+
+// Redact returns a field's value as it should be written: replaced entirely
+// for secret keys, scrubbed of secrets when it is text, and otherwise as is.
+// Errors, durations and other Stringers are written as text.
+func Redact(key string, value interface{}) interface{} {
+	lower := strings.ToLower(key)
+	for _, secret := range secretKeys {
+		if strings.Contains(lower, secret) {
+			return redacted
+		}
+	}
+
+	switch v := value.(type) {
+	case string:
+		return RedactString(v)
+	case error:
+		return RedactString(v.Error())
+	case time.Time:
+		return v
+	case time.Duration:
+		return v.String()
+	case fmt.Stringer:
+		return RedactString(v.String())
+	case []string:
+		return RedactString(strings.Join(v, " "))
+	}
+	return value
+}
+
+// RedactString scrubs passwords and tokens from free text
+func RedactString(s string) string {
+	for _, pattern := range secretPatterns {
+		s = pattern.re.ReplaceAllString(s, pattern.replace)
+	}
+	return s
+}
@@ -0,0 +1,179 @@
+// Package metrics renders backup history in the Prometheus text format for
+// scraping, pushing to a Pushgateway or node_exporter's textfile collector
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jbaikge/database-backups/pkg/api"
+)
+
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+type Family struct {
+	Name    string
+	Help    string
+	Type    string
+	Samples []Sample
+}
+
+type Sample struct {
+	Labels map[string]string
+	Value  float64
+}
+
+// BackupFamilies turns each database's history into gauges of its last
+// successful backup and a counter of its failures. Databases never backed up
+// successfully only appear in the failure counter.
+func BackupFamilies(stats []api.BackupStats) []Family {
+	success := Family{
+		Name: "database_backup_last_success_timestamp_seconds",
+		Help: "Time the last successful backup finished.",
+		Type: "gauge",
+	}
+	duration := Family{
+		Name: "database_backup_last_duration_seconds",
+		Help: "Time taken by the last successful backup.",
+		Type: "gauge",
+	}
+	size := Family{
+		Name: "database_backup_last_size_bytes",
+		Help: "Size of the last successful backup.",
+		Type: "gauge",
+	}
+	failures := Family{
+		Name: "database_backup_failures_total",
+		Help: "Backups that failed or timed out.",
+		Type: "counter",
+	}
+
+	for _, stat := range stats {
+		labels := map[string]string{"server": stat.Server, "database": stat.Database}
+		failures.Samples = append(failures.Samples, Sample{Labels: labels, Value: float64(stat.Failures)})
+		if stat.LastSuccess == nil {
+			continue
+		}
+		success.Samples = append(success.Samples, Sample{Labels: labels, Value: float64(stat.LastSuccess.Unix())})
+		duration.Samples = append(duration.Samples, Sample{Labels: labels, Value: stat.LastDuration.Seconds()})
+		size.Samples = append(size.Samples, Sample{Labels: labels, Value: float64(stat.LastSize)})
+	}
+
+	return []Family{success, duration, size, failures}
+}
+
+// QueueFamily reports the jobs waiting in the API's backup queue
+func QueueFamily(depth int) Family {
+	return Family{
+		Name:    "database_backup_queue_depth",
+		Help:    "Backup jobs waiting to run.",
+		Type:    "gauge",
+		Samples: []Sample{{Value: float64(depth)}},
+	}
+}
+
+// Write renders the families in the Prometheus text exposition format
+func Write(w io.Writer, families []Family) error {
+	var b bytes.Buffer
+	for _, family := range families {
+		fmt.Fprintf(&b, "# HELP %s %s\n", family.Name, family.Help)
+		fmt.Fprintf(&b, "# TYPE %s %s\n", family.Name, family.Type)
+		for _, sample := range family.Samples {
+			b.WriteString(family.Name)
+			writeLabels(&b, sample.Labels)
+			b.WriteString(" ")
+			b.WriteString(strconv.FormatFloat(sample.Value, 'f', -1, 64))
+			b.WriteString("\n")
+		}
+	}
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+func writeLabels(b *bytes.Buffer, labels map[string]string) {
+	if len(labels) == 0 {
+		return
+	}
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	b.WriteString("{")
+	for i, name := range names {
+		if i > 0 {
+			b.WriteString(",")
+		}
+		fmt.Fprintf(b, "%s=\"%s\"", name, escapeLabel(labels[name]))
+	}
+	b.WriteString("}")
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+func escapeLabel(value string) string {
+	return labelEscaper.Replace(value)
+}
+
+// Push replaces the metrics of job on a Pushgateway
+func Push(ctx context.Context, gateway string, job string, families []Family) error {
+	var body bytes.Buffer
+	if err := Write(&body, families); err != nil {
+		return err
+	}
+
+	endpoint := strings.TrimSuffix(gateway, "/") + "/metrics/job/" + url.PathEscape(job)
+	request, err := http.NewRequestWithContext(ctx, http.MethodPut, endpoint, &body)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", ContentType)
+
+	client := http.Client{Timeout: 30 * time.Second}
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	io.Copy(ioutil.Discard, response.Body)
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("pushgateway: %s", response.Status)
+	}
+	return nil
+}
+
+// WriteFile writes the metrics for node_exporter's textfile collector. The
+// file is replaced atomically so the collector never reads half of it.
+func WriteFile(filename string, families []Family) error {
+	file, err := ioutil.TempFile(filepath.Dir(filename), "."+filepath.Base(filename)+"-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	if err := Write(file, families); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Chmod(0644); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), filename)
+}
@@ -0,0 +1,81 @@
+package metrics_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jbaikge/database-backups/pkg/api"
+	"github.com/jbaikge/database-backups/pkg/metrics"
+	"github.com/zeebo/assert"
+)
+
+var stats = []api.BackupStats{
+	{Server: "db1", Database: "new", Failures: 2},
+	{
+		Server:       "db1",
+		Database:     `sh"op`,
+		LastSuccess:  func() *time.Time { t := time.Unix(1646100000, 0); return &t }(),
+		LastDuration: 90 * time.Second,
+		LastSize:     1 << 20,
+	},
+}
+
+const expected = `# HELP database_backup_last_success_timestamp_seconds Time the last successful backup finished.
+# TYPE database_backup_last_success_timestamp_seconds gauge
+database_backup_last_success_timestamp_seconds{database="sh\"op",server="db1"} 1646100000
+# HELP database_backup_last_duration_seconds Time taken by the last successful backup.
+# TYPE database_backup_last_duration_seconds gauge
+database_backup_last_duration_seconds{database="sh\"op",server="db1"} 90
+# HELP database_backup_last_size_bytes Size of the last successful backup.
+# TYPE database_backup_last_size_bytes gauge
+database_backup_last_size_bytes{database="sh\"op",server="db1"} 1048576
+# HELP database_backup_failures_total Backups that failed or timed out.
+# TYPE database_backup_failures_total counter
+database_backup_failures_total{database="new",server="db1"} 2
+database_backup_failures_total{database="sh\"op",server="db1"} 0
+# HELP database_backup_queue_depth Backup jobs waiting to run.
+# TYPE database_backup_queue_depth gauge
+database_backup_queue_depth 3
+`
+
+func TestWrite(t *testing.T) {
+	var b bytes.Buffer
+	families := append(metrics.BackupFamilies(stats), metrics.QueueFamily(3))
+	assert.Nil(t, metrics.Write(&b, families))
+	assert.Equal(t, b.String(), expected)
+}
+
+func TestPush(t *testing.T) {
+	var method, path, body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+		method, path, body = r.Method, r.URL.Path, string(data)
+	}))
+	defer server.Close()
+
+	assert.Nil(t, metrics.Push(context.Background(), server.URL+"/", "nightly backups", []metrics.Family{metrics.QueueFamily(1)}))
+	assert.Equal(t, method, http.MethodPut)
+	assert.Equal(t, path, "/metrics/job/nightly backups")
+	assert.Equal(t, body, "# HELP database_backup_queue_depth Backup jobs waiting to run.\n# TYPE database_backup_queue_depth gauge\ndatabase_backup_queue_depth 1\n")
+}
+
+func TestWriteFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metrics")
+	assert.Nil(t, err)
+	filename := filepath.Join(dir, "backups.prom")
+
+	assert.Nil(t, metrics.WriteFile(filename, metrics.BackupFamilies(stats)))
+	data, err := ioutil.ReadFile(filename)
+	assert.Nil(t, err)
+	assert.That(t, bytes.Contains(data, []byte(`database_backup_failures_total{database="new",server="db1"} 2`)))
+
+	files, err := ioutil.ReadDir(dir)
+	assert.Nil(t, err)
+	assert.Equal(t, len(files), 1)
+}
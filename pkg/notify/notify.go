@@ -0,0 +1,50 @@
+// Package notify delivers events to people
+package notify
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/jbaikge/database-backups/pkg/api"
+	"github.com/jbaikge/database-backups/pkg/logging"
+)
+
+// Log writes events to the logger, failures as errors and size drops as
+// warnings
+type Log struct{}
+
+func (Log) Notify(ctx context.Context, event api.Event) error {
+	level := logging.LevelInfo
+	switch event.Type {
+	case api.EventBackupFailed:
+		level = logging.LevelError
+	case api.EventSizeDrop:
+		level = logging.LevelWarn
+	}
+	keyvals := []interface{}{"event", event.Type}
+	if event.Server != "" {
+		keyvals = append(keyvals, "server", event.Server)
+	}
+	if event.Database != "" {
+		keyvals = append(keyvals, "database", event.Database)
+	}
+	logging.FromContext(ctx).Log(level, event.Message, keyvals...)
+	return nil
+}
+
+// Multi sends each event to every notifier, carrying on past failures
+type Multi []api.Notifier
+
+func (m Multi) Notify(ctx context.Context, event api.Event) error {
+	failures := make([]string, 0, len(m))
+	for _, notifier := range m {
+		if err := notifier.Notify(ctx, event); err != nil {
+			failures = append(failures, err.Error())
+		}
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("notify: %s", strings.Join(failures, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,316 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/jbaikge/database-backups/pkg/api"
+)
+
+const defaultReportText = `Backup report for {{.Server}}
+{{.Start.Format "2006-01-02 15:04"}} to {{.End.Format "2006-01-02 15:04"}}
+{{range .Results}}
+{{.Database}}: {{.Status}}, {{size .Size}} ({{delta .SizePrevious .Size}}) in {{.Elapsed}}
+{{- if .Error}}
+    Error: {{.Error}}
+{{- end}}
+{{- if .Warning}}
+    Warning: {{.Warning}}
+{{- end}}
+{{end}}`
+
+const defaultReportHTML = `<html>
+<body>
+<h2>Backup report for {{.Server}}</h2>
+<p>{{.Start.Format "2006-01-02 15:04"}} to {{.End.Format "2006-01-02 15:04"}}, {{len .Results}} databases, {{.Failed}} failed</p>
+<table border="1" cellpadding="4" cellspacing="0">
+<tr><th>Database</th><th>Status</th><th>Size</th><th>Change</th><th>Time</th><th>Notes</th></tr>
+{{- range .Results}}
+<tr><td>{{.Database}}</td><td>{{.Status}}</td><td>{{size .Size}}</td><td>{{delta .SizePrevious .Size}}</td><td>{{.Elapsed}}</td><td>{{.Error}}{{.Warning}}</td></tr>
+{{- end}}
+</table>
+</body>
+</html>
+`
+
+// Mailer emails each server's recipients a report of its databases at the end
+// of a run. It does nothing until the smtp_host setting is filled in.
+type Mailer struct {
+	settings api.SettingService
+	servers  api.ServerService
+}
+
+func NewMailer(settings api.SettingService, servers api.ServerService) *Mailer {
+	return &Mailer{
+		settings: settings,
+		servers:  servers,
+	}
+}
+
+type smtpConfig struct {
+	host     string
+	port     string
+	username string
+	password string
+	from     string
+	startTLS bool
+}
+
+// Notify sends reports for run summaries and ignores every other event
+func (m *Mailer) Notify(ctx context.Context, event api.Event) error {
+	if event.Type != api.EventRunSummary || len(event.Results) == 0 {
+		return nil
+	}
+
+	config, err := m.config()
+	if err != nil || config.host == "" {
+		return err
+	}
+
+	servers, err := m.servers.List()
+	if err != nil {
+		return err
+	}
+
+	start, _ := time.Parse(time.RFC3339, event.Fields["started"])
+	failures := make([]string, 0)
+	for _, server := range servers {
+		if server.ReportRecipients == "" {
+			continue
+		}
+		report := api.Report{Server: server.Name, Start: start, End: event.Time}
+		for _, result := range event.Results {
+			if result.Server != server.Name {
+				continue
+			}
+			report.Results = append(report.Results, result)
+			if result.Status != api.LogStatusSuccess {
+				report.Failed++
+			}
+		}
+		if len(report.Results) == 0 {
+			continue
+		}
+		if err := m.sendReport(ctx, config, server.ReportRecipients, report); err != nil {
+			failures = append(failures, server.Name+": "+err.Error())
+		}
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("smtp: %s", strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+func (m *Mailer) config() (config smtpConfig, err error) {
+	values := map[string]*string{
+		api.SettingSMTPHost:     &config.host,
+		api.SettingSMTPPort:     &config.port,
+		api.SettingSMTPUsername: &config.username,
+		api.SettingSMTPPassword: &config.password,
+		api.SettingSMTPFrom:     &config.from,
+	}
+	for key, value := range values {
+		if *value, err = m.settings.Get(key); err != nil {
+			return
+		}
+	}
+	if config.password != "" {
+		if config.password, err = api.DecryptSecret(config.password); err != nil {
+			err = fmt.Errorf("%s: %s, set it again to store it encrypted", api.SettingSMTPPassword, err)
+			return
+		}
+	}
+	startTLS, err := m.settings.Get(api.SettingSMTPStartTLS)
+	config.startTLS = startTLS == "true"
+	return
+}
+
+func (m *Mailer) sendReport(ctx context.Context, config smtpConfig, recipients string, report api.Report) error {
+	to, err := mail.ParseAddressList(recipients)
+	if err != nil {
+		return err
+	}
+	if config.from == "" {
+		return errors.New("smtp_from is not set")
+	}
+	from, err := mail.ParseAddress(config.from)
+	if err != nil {
+		return err
+	}
+
+	subject, text, html, err := m.render(report)
+	if err != nil {
+		return err
+	}
+
+	message, err := buildMessage(from, to, subject, text, html)
+	if err != nil {
+		return err
+	}
+	return sendMail(ctx, config, from, to, message)
+}
+
+// render executes the report templates, falling back to the built-in ones
+// when the settings are empty
+func (m *Mailer) render(report api.Report) (subject string, text string, html string, err error) {
+	values := make(map[string]string)
+	for _, key := range []string{api.SettingReportSubject, api.SettingReportTemplateText, api.SettingReportTemplateHTML} {
+		if values[key], err = m.settings.Get(key); err != nil {
+			return
+		}
+	}
+	if values[api.SettingReportTemplateText] == "" {
+		values[api.SettingReportTemplateText] = defaultReportText
+	}
+	if values[api.SettingReportTemplateHTML] == "" {
+		values[api.SettingReportTemplateHTML] = defaultReportHTML
+	}
+
+	if subject, err = executeText(values[api.SettingReportSubject], report); err != nil {
+		return
+	}
+	if text, err = executeText(values[api.SettingReportTemplateText], report); err != nil {
+		return
+	}
+
+	tmpl, err := htmltemplate.New("report").Funcs(api.ReportFuncs).Parse(values[api.SettingReportTemplateHTML])
+	if err != nil {
+		return
+	}
+	var b bytes.Buffer
+	if err = tmpl.Execute(&b, report); err != nil {
+		return
+	}
+	html = b.String()
+	return
+}
+
+func executeText(value string, report api.Report) (string, error) {
+	tmpl, err := template.New("report").Funcs(api.ReportFuncs).Parse(value)
+	if err != nil {
+		return "", err
+	}
+	var b bytes.Buffer
+	if err := tmpl.Execute(&b, report); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// buildMessage puts the plain text and HTML versions of the report into a
+// multipart/alternative message
+func buildMessage(from *mail.Address, to []*mail.Address, subject string, text string, html string) ([]byte, error) {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	parts := []struct {
+		contentType string
+		content     string
+	}{
+		{"text/plain; charset=utf-8", text},
+		{"text/html; charset=utf-8", html},
+	}
+	for _, part := range parts {
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Type", part.contentType)
+		header.Set("Content-Transfer-Encoding", "8bit")
+		w, err := writer.CreatePart(header)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write([]byte(strings.ReplaceAll(part.content, "\n", "\r\n"))); err != nil {
+			return nil, err
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+
+	addresses := make([]string, len(to))
+	for i, address := range to {
+		addresses[i] = address.String()
+	}
+
+	var message bytes.Buffer
+	fmt.Fprintf(&message, "From: %s\r\n", from)
+	fmt.Fprintf(&message, "To: %s\r\n", strings.Join(addresses, ", "))
+	fmt.Fprintf(&message, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", strings.TrimSpace(subject)))
+	fmt.Fprintf(&message, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&message, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&message, "Content-Type: multipart/alternative; boundary=%s\r\n", writer.Boundary())
+	fmt.Fprintf(&message, "\r\n")
+	message.Write(body.Bytes())
+	return message.Bytes(), nil
+}
+
+// sendMail delivers the message, upgrading the connection with STARTTLS
+// when configured and authenticating when a username is set. Unlike
+// smtp.SendMail a server without STARTTLS is refused rather than used in
+// the clear.
+func sendMail(ctx context.Context, config smtpConfig, from *mail.Address, to []*mail.Address, message []byte) error {
+	dialer := net.Dialer{Timeout: 30 * time.Second}
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(config.host, config.port))
+	if err != nil {
+		return err
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(time.Minute)
+	}
+	conn.SetDeadline(deadline)
+
+	client, err := smtp.NewClient(conn, config.host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if config.startTLS {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			return fmt.Errorf("%s does not offer STARTTLS", config.host)
+		}
+		if err := client.StartTLS(&tls.Config{ServerName: config.host}); err != nil {
+			return err
+		}
+	}
+
+	if config.username != "" {
+		if err := client.Auth(smtp.PlainAuth("", config.username, config.password, config.host)); err != nil {
+			return err
+		}
+	}
+
+	if err := client.Mail(from.Address); err != nil {
+		return err
+	}
+	for _, address := range to {
+		if err := client.Rcpt(address.Address); err != nil {
+			return err
+		}
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(message); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
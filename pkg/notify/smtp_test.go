@@ -0,0 +1,166 @@
+package notify_test
+
+import (
+	"context"
+	"net"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jbaikge/database-backups/pkg/api"
+	"github.com/jbaikge/database-backups/pkg/notify"
+	"github.com/zeebo/assert"
+)
+
+type settingRepository map[string]string
+
+func (r settingRepository) GetSetting(key string) (*api.Setting, error) {
+	value, ok := r[key]
+	if !ok {
+		return nil, nil
+	}
+	return &api.Setting{Key: key, Value: value}, nil
+}
+
+func (r settingRepository) ListSettings() ([]api.Setting, error) {
+	return nil, nil
+}
+
+func (r settingRepository) SetSetting(setting api.Setting) error {
+	r[setting.Key] = setting.Value
+	return nil
+}
+
+type serverService struct {
+	api.ServerService
+	servers []api.Server
+}
+
+func (s serverService) List() ([]api.Server, error) {
+	return s.servers, nil
+}
+
+type delivery struct {
+	auth       string
+	recipients []string
+	data       string
+}
+
+// smtpSink accepts one message per connection, offering AUTH PLAIN but not
+// STARTTLS
+func smtpSink(t *testing.T) (string, <-chan delivery) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	deliveries := make(chan delivery, 10)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveSMTP(textproto.NewConn(conn), deliveries)
+		}
+	}()
+	return listener.Addr().String(), deliveries
+}
+
+func serveSMTP(conn *textproto.Conn, deliveries chan<- delivery) {
+	defer conn.Close()
+	var d delivery
+	conn.PrintfLine("220 localhost ESMTP sink")
+	for {
+		line, err := conn.ReadLine()
+		if err != nil {
+			return
+		}
+		command := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		switch command {
+		case "EHLO", "HELO":
+			conn.PrintfLine("250-localhost")
+			conn.PrintfLine("250 AUTH PLAIN")
+		case "AUTH":
+			d.auth = line
+			conn.PrintfLine("235 2.7.0 Authentication successful")
+		case "MAIL":
+			conn.PrintfLine("250 2.1.0 OK")
+		case "RCPT":
+			d.recipients = append(d.recipients, line)
+			conn.PrintfLine("250 2.1.5 OK")
+		case "DATA":
+			conn.PrintfLine("354 Go ahead")
+			data, err := conn.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			d.data = string(data)
+			conn.PrintfLine("250 2.0.0 Queued")
+			deliveries <- d
+		case "QUIT":
+			conn.PrintfLine("221 2.0.0 Bye")
+			return
+		default:
+			conn.PrintfLine("502 5.5.2 Unknown command")
+		}
+	}
+}
+
+func TestMailerNotify(t *testing.T) {
+	addr, deliveries := smtpSink(t)
+	host, port, _ := net.SplitHostPort(addr)
+
+	settings := api.NewSettingService(settingRepository{})
+	assert.Nil(t, settings.Set(api.SettingSMTPHost, host))
+	assert.Nil(t, settings.Set(api.SettingSMTPPort, port))
+	assert.Nil(t, settings.Set(api.SettingSMTPStartTLS, "false"))
+	assert.Nil(t, settings.Set(api.SettingSMTPUsername, "reports"))
+	assert.Nil(t, settings.Set(api.SettingSMTPPassword, "secret"))
+	assert.Nil(t, settings.Set(api.SettingSMTPFrom, "Backups <backups@example.com>"))
+
+	mailer := notify.NewMailer(settings, serverService{servers: []api.Server{
+		{Name: "db1", ReportRecipients: "ops@example.com, Client <client@example.com>"},
+		{Name: "db2"},
+	}})
+
+	started := time.Date(2022, 3, 1, 2, 0, 0, 0, time.UTC)
+	event := api.Event{
+		Type:   api.EventRunSummary,
+		Time:   started.Add(time.Hour),
+		Fields: map[string]string{"started": started.Format(time.RFC3339)},
+		Results: []api.BackupResult{
+			{Server: "db1", Database: "shop", Status: api.LogStatusSuccess, Size: 3 << 20, SizePrevious: 2 << 20},
+			{Server: "db1", Database: "crm", Status: api.LogStatusFailed, Error: "exit status 2"},
+			{Server: "db2", Database: "wiki", Status: api.LogStatusSuccess, Size: 100},
+		},
+	}
+	assert.Nil(t, mailer.Notify(context.Background(), event))
+
+	var d delivery
+	select {
+	case d = <-deliveries:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message delivered")
+	}
+	assert.Equal(t, d.auth, "AUTH PLAIN AHJlcG9ydHMAc2VjcmV0")
+	assert.DeepEqual(t, d.recipients, []string{"RCPT TO:<ops@example.com>", "RCPT TO:<client@example.com>"})
+	assert.That(t, strings.Contains(d.data, "Subject: Backup report for db1: 2 databases, 1 failed\n"))
+	assert.That(t, strings.Contains(d.data, "Content-Type: multipart/alternative"))
+	assert.That(t, strings.Contains(d.data, "shop: success, 3.0 MiB (+50.0%)"))
+	assert.That(t, strings.Contains(d.data, "    Error: exit status 2"))
+	assert.That(t, strings.Contains(d.data, "<td>crm</td><td>failed</td>"))
+	assert.False(t, strings.Contains(d.data, "wiki"))
+
+	// db2 has no recipients
+	select {
+	case <-deliveries:
+		t.Fatal("unexpected second message")
+	default:
+	}
+
+	assert.Nil(t, settings.Set(api.SettingSMTPStartTLS, "true"))
+	err := mailer.Notify(context.Background(), event)
+	assert.Error(t, err)
+	assert.That(t, strings.Contains(err.Error(), "does not offer STARTTLS"))
+}
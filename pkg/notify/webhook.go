@@ -0,0 +1,176 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jbaikge/database-backups/pkg/api"
+)
+
+// Webhooks posts each event to the configured webhooks that want it
+type Webhooks struct {
+	service api.WebhookService
+	client  *http.Client
+}
+
+func NewWebhooks(service api.WebhookService) *Webhooks {
+	return &Webhooks{
+		service: service,
+		client:  &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Notify carries on past webhooks that fail so one broken endpoint does not
+// silence the rest
+func (w *Webhooks) Notify(ctx context.Context, event api.Event) error {
+	webhooks, err := w.service.List()
+	if err != nil {
+		return err
+	}
+
+	failures := make([]string, 0, len(webhooks))
+	for _, webhook := range webhooks {
+		if !webhook.Wants(event) {
+			continue
+		}
+		if err := w.send(ctx, webhook, event); err != nil {
+			failures = append(failures, err.Error())
+		}
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("webhooks: %s", strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+// Test sends a test event to the webhook regardless of its routing
+func (w *Webhooks) Test(ctx context.Context, webhook api.Webhook) error {
+	return w.send(ctx, webhook, api.Event{
+		Type:    api.EventTest,
+		Message: fmt.Sprintf("Test notification for webhook %s", webhook.Name),
+		Time:    time.Now(),
+	})
+}
+
+func (w *Webhooks) send(ctx context.Context, webhook api.Webhook, event api.Event) error {
+	body, err := json.Marshal(payload(webhook.Format, event))
+	if err != nil {
+		return err
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("%s: %s", webhook.Name, withoutURL(err))
+	}
+	request.Header.Set("Content-Type", "application/json")
+
+	response, err := w.client.Do(request)
+	if err != nil {
+		return fmt.Errorf("%s: %s", webhook.Name, withoutURL(err))
+	}
+	defer response.Body.Close()
+	io.Copy(ioutil.Discard, response.Body)
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("%s: %s", webhook.Name, response.Status)
+	}
+	return nil
+}
+
+// payload shapes the event for the webhook's format. Slack and Teams get
+// their incoming webhook formats; anything else gets the event as it is.
+func payload(format string, event api.Event) interface{} {
+	switch format {
+	case api.WebhookFormatSlack:
+		lines := append([]string{"*" + title(event) + "*", event.Message}, fieldLines(event)...)
+		return map[string]string{"text": strings.Join(lines, "\n")}
+	case api.WebhookFormatTeams:
+		facts := make([]map[string]string, 0, len(event.Fields))
+		for _, name := range fieldNames(event) {
+			facts = append(facts, map[string]string{"name": name, "value": event.Fields[name]})
+		}
+		card := map[string]interface{}{
+			"@type":      "MessageCard",
+			"@context":   "https://schema.org/extensions",
+			"summary":    event.Message,
+			"themeColor": themeColor(event),
+			"title":      title(event),
+			"text":       event.Message,
+		}
+		if len(facts) > 0 {
+			card["sections"] = []map[string]interface{}{{"facts": facts}}
+		}
+		return card
+	}
+	return event
+}
+
+var titles = map[string]string{
+	api.EventBackupFailed:    "Backup failed",
+	api.EventDatabaseAdded:   "New database",
+	api.EventDatabaseRemoved: "Database removed",
+	api.EventRunSummary:      "Backup run finished",
+	api.EventSizeDrop:        "Backup size dropped",
+	api.EventTest:            "Test notification",
+}
+
+func title(event api.Event) string {
+	t, ok := titles[event.Type]
+	if !ok {
+		t = event.Type
+	}
+	switch {
+	case event.Database != "":
+		return t + ": " + event.Server + "/" + event.Database
+	case event.Server != "":
+		return t + ": " + event.Server
+	}
+	return t
+}
+
+func themeColor(event api.Event) string {
+	switch event.Type {
+	case api.EventBackupFailed:
+		return "d9534f"
+	case api.EventSizeDrop, api.EventDatabaseRemoved:
+		return "f0ad4e"
+	}
+	return "5cb85c"
+}
+
+func fieldNames(event api.Event) []string {
+	names := make([]string, 0, len(event.Fields))
+	for name := range event.Fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func fieldLines(event api.Event) []string {
+	lines := make([]string, 0, len(event.Fields))
+	for _, name := range fieldNames(event) {
+		lines = append(lines, name+": "+event.Fields[name])
+	}
+	return lines
+}
+
+// withoutURL drops the URL from a request error, as webhook URLs carry the
+// token allowing anyone to post to them
+func withoutURL(err error) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return urlErr.Err
+	}
+	return err
+}
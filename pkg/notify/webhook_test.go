@@ -0,0 +1,105 @@
+package notify_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jbaikge/database-backups/pkg/api"
+	"github.com/jbaikge/database-backups/pkg/notify"
+	"github.com/zeebo/assert"
+)
+
+type webhookService struct {
+	api.WebhookService
+	webhooks []api.Webhook
+}
+
+func (s webhookService) List() ([]api.Webhook, error) {
+	return s.webhooks, nil
+}
+
+// listener records the JSON bodies posted to each path
+func listener(t *testing.T) (*httptest.Server, map[string]map[string]interface{}) {
+	received := make(map[string]map[string]interface{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/broken" {
+			http.Error(w, "nope", http.StatusInternalServerError)
+			return
+		}
+		body := make(map[string]interface{})
+		assert.Nil(t, json.NewDecoder(r.Body).Decode(&body))
+		assert.Equal(t, r.Header.Get("Content-Type"), "application/json")
+		received[r.URL.Path] = body
+	}))
+	return server, received
+}
+
+func TestWebhooksNotify(t *testing.T) {
+	server, received := listener(t)
+	defer server.Close()
+
+	webhooks := notify.NewWebhooks(webhookService{webhooks: []api.Webhook{
+		{Name: "generic", URL: server.URL + "/json", Format: api.WebhookFormatJSON},
+		{Name: "slack", URL: server.URL + "/slack", Format: api.WebhookFormatSlack, Events: api.EventBackupFailed},
+		{Name: "teams", URL: server.URL + "/teams", Format: api.WebhookFormatTeams, Servers: "db*"},
+		{Name: "other", URL: server.URL + "/other", Servers: "web*"},
+		{Name: "summary", URL: server.URL + "/summary", Events: api.EventRunSummary},
+	}})
+
+	event := api.Event{
+		Type:     api.EventBackupFailed,
+		Server:   "db1",
+		Database: "shop",
+		Message:  "Backup of shop on db1 failed: exit status 2",
+		Time:     time.Date(2022, 3, 1, 2, 0, 0, 0, time.UTC),
+		Fields:   map[string]string{"status": api.LogStatusFailed},
+	}
+	assert.Nil(t, webhooks.Notify(context.Background(), event))
+
+	assert.Equal(t, len(received), 3)
+	assert.Equal(t, received["/json"]["type"], api.EventBackupFailed)
+	assert.Equal(t, received["/json"]["database"], "shop")
+	assert.Equal(t, received["/slack"]["text"], "*Backup failed: db1/shop*\nBackup of shop on db1 failed: exit status 2\nstatus: failed")
+	assert.Equal(t, received["/teams"]["@type"], "MessageCard")
+	assert.Equal(t, received["/teams"]["title"], "Backup failed: db1/shop")
+	assert.Equal(t, received["/teams"]["themeColor"], "d9534f")
+}
+
+func TestWebhooksFailure(t *testing.T) {
+	server, received := listener(t)
+	defer server.Close()
+
+	webhooks := notify.NewWebhooks(webhookService{webhooks: []api.Webhook{
+		{Name: "broken", URL: server.URL + "/broken"},
+		{Name: "working", URL: server.URL + "/working"},
+	}})
+
+	err := webhooks.Notify(context.Background(), api.Event{Type: api.EventRunSummary, Message: "done"})
+	assert.Error(t, err)
+	assert.Equal(t, err.Error(), "webhooks: broken: 500 Internal Server Error")
+	assert.Equal(t, received["/working"]["message"], "done")
+
+	assert.Nil(t, webhooks.Test(context.Background(), api.Webhook{Name: "test", URL: server.URL + "/test"}))
+	assert.Equal(t, received["/test"]["type"], api.EventTest)
+}
+
+func TestWebhooksFailureHidesURL(t *testing.T) {
+	server, _ := listener(t)
+	url := server.URL + "/services/T0/B0/token"
+	server.Close()
+
+	webhooks := notify.NewWebhooks(webhookService{webhooks: []api.Webhook{{Name: "chat", URL: url}}})
+	err := webhooks.Notify(context.Background(), api.Event{Type: api.EventRunSummary, Message: "done"})
+	assert.Error(t, err)
+	assert.That(t, strings.HasPrefix(err.Error(), "webhooks: chat: "))
+	assert.False(t, strings.Contains(err.Error(), "token"))
+
+	err = webhooks.Test(context.Background(), api.Webhook{Name: "chat", URL: "https://hooks.example.com/token\x7f"})
+	assert.Error(t, err)
+	assert.False(t, strings.Contains(err.Error(), "token"))
+}
@@ -0,0 +1,55 @@
+package process
+
+import "sync"
+
+// StderrLimit is how much of a failed command's error output is kept
+const StderrLimit = 16 * 1024
+
+// TailBuffer is an io.Writer keeping only the last size bytes written to it,
+// enough to hold the interesting end of a command's error output without
+// letting a chatty command eat memory.
+type TailBuffer struct {
+	mu        sync.Mutex
+	size      int
+	buf       []byte
+	truncated bool
+}
+
+func NewTailBuffer(size int) *TailBuffer {
+	return &TailBuffer{
+		size: size,
+		buf:  make([]byte, 0, size),
+	}
+}
+
+func (b *TailBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	n := len(p)
+	if len(p) >= b.size {
+		b.buf = append(b.buf[:0], p[len(p)-b.size:]...)
+		b.truncated = true
+		return n, nil
+	}
+
+	if overflow := len(b.buf) + len(p) - b.size; overflow > 0 {
+		b.buf = append(b.buf[:0], b.buf[overflow:]...)
+		b.truncated = true
+	}
+	b.buf = append(b.buf, p...)
+	return n, nil
+}
+
+func (b *TailBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return string(b.buf)
+}
+
+// Truncated reports whether anything was discarded
+func (b *TailBuffer) Truncated() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.truncated
+}
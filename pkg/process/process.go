@@ -0,0 +1,108 @@
+// Package process runs external commands in their own process group so the
+// whole pipeline, including ssh and anything it spawned, can be stopped when
+// a context is cancelled.
+package process
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// How long a process group has to exit after SIGTERM before it is killed
+const killDelay = 5 * time.Second
+
+// Run starts cmd and waits for it to finish. If ctx is done first, the
+// process group receives SIGTERM, then SIGKILL after a short delay, and the
+// context's error is returned.
+func Run(ctx context.Context, cmd *exec.Cmd) error {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = new(syscall.SysProcAttr)
+	}
+	cmd.SysProcAttr.Setpgid = true
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+
+	select {
+	case err := <-done:
+		return wrapExitError(cmd, err)
+	case <-ctx.Done():
+	}
+
+	pgid := -cmd.Process.Pid
+	syscall.Kill(pgid, syscall.SIGTERM)
+	select {
+	case <-done:
+	case <-time.After(killDelay):
+		syscall.Kill(pgid, syscall.SIGKILL)
+		<-done
+	}
+
+	return ctx.Err()
+}
+
+// Output runs cmd like Run and returns its standard output. When cmd.Stderr
+// is nil, the tail of standard error is kept in the ExitError instead.
+func Output(ctx context.Context, cmd *exec.Cmd) ([]byte, error) {
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	var stderr *TailBuffer
+	if cmd.Stderr == nil {
+		stderr = NewTailBuffer(StderrLimit)
+		cmd.Stderr = stderr
+	}
+
+	err := Run(ctx, cmd)
+	var exitErr *ExitError
+	if stderr != nil && errors.As(err, &exitErr) {
+		exitErr.Stderr = stderr.String()
+	}
+	return stdout.Bytes(), err
+}
+
+// ExitError is returned when a command exits with a non-zero status
+type ExitError struct {
+	Name     string
+	ExitCode int
+	// Tail of standard error, when Output collected it
+	Stderr string
+	Err    error
+}
+
+func (e *ExitError) Error() string {
+	return e.Name + ": " + e.Err.Error()
+}
+
+func (e *ExitError) Unwrap() error {
+	return e.Err
+}
+
+// Temporary reports whether the failure was likely caused by the network
+// rather than the command itself. ssh exits with 255 when it fails to
+// connect or loses the connection.
+func (e *ExitError) Temporary() bool {
+	return e.Name == "ssh" && e.ExitCode == 255
+}
+
+func wrapExitError(cmd *exec.Cmd, err error) error {
+	exitErr, ok := err.(*exec.ExitError)
+	if !ok {
+		return err
+	}
+	return &ExitError{
+		Name:     filepath.Base(cmd.Path),
+		ExitCode: exitErr.ExitCode(),
+		Err:      err,
+	}
+}
@@ -0,0 +1,110 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// dialect holds what differs between the databases storage runs on. Queries
+// are shared, so they stick to SQL both understand: $n placeholders, TRUE and
+// FALSE for flags and RETURNING for new ids.
+type dialect struct {
+	name             string
+	migrations       []migration
+	schemaMigrations string
+	tableExists      func(name string) presentFunc
+}
+
+var sqlite = dialect{
+	name:             "sqlite3",
+	migrations:       sqliteMigrations,
+	schemaMigrations: sqliteSchemaMigrations,
+	tableExists:      sqliteTableExists,
+}
+
+var postgres = dialect{
+	name:             "postgres",
+	migrations:       postgresMigrations,
+	schemaMigrations: postgresSchemaMigrations,
+	tableExists:      postgresTableExists,
+}
+
+// ErrSchemaOutdated is returned by OpenReadOnly for storage with migrations
+// still to run
+var ErrSchemaOutdated = errors.New("schema is not up to date")
+
+// Open connects to the storage named by dsn: a postgres:// or postgresql://
+// URL for PostgreSQL, or the path to a SQLite database. The program has to
+// register the driver, as both commands do.
+func Open(dsn string) (Storage, error) {
+	return open(dsn, dsn)
+}
+
+// OpenReadOnly connects like Open without creating or changing anything, for
+// commands that only look. A SQLite database has to exist already and is
+// opened read-only. Storage with migrations still to run is refused with
+// ErrSchemaOutdated rather than migrated.
+func OpenReadOnly(dsn string) (Storage, error) {
+	driverDSN := dsn
+	if !isPostgres(dsn) {
+		if _, err := os.Stat(dsn); err != nil {
+			return nil, err
+		}
+		driverDSN = "file:" + (&url.URL{Path: dsn}).EscapedPath() + "?mode=ro"
+	}
+
+	storage, err := open(dsn, driverDSN)
+	if err != nil {
+		return nil, err
+	}
+
+	migrations, err := storage.ListMigrations()
+	if err != nil {
+		return nil, err
+	}
+	pending := 0
+	for _, migration := range migrations {
+		if migration.Applied == nil && !migration.Baseline {
+			pending++
+		}
+	}
+	if pending > 0 {
+		return nil, fmt.Errorf("%w: %d of %d migrations pending", ErrSchemaOutdated, pending, len(migrations))
+	}
+	return storage, nil
+}
+
+func open(dsn string, driverDSN string) (Storage, error) {
+	d, newStorage := sqlite, NewStorage
+	if isPostgres(dsn) {
+		d, newStorage = postgres, NewPostgresStorage
+	}
+
+	registered := false
+	for _, driver := range sql.Drivers() {
+		registered = registered || driver == d.name
+	}
+	if !registered {
+		return nil, fmt.Errorf("no %s driver registered", d.name)
+	}
+
+	db, err := sql.Open(d.name, driverDSN)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return newStorage(db), nil
+}
+
+func isPostgres(dsn string) bool {
+	return strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://")
+}
@@ -2,15 +2,42 @@ package repository
 
 import "database/sql"
 
-type checkFunc func(*sql.DB) (bool, error)
+// queryer is satisfied by both *sql.DB and *sql.Tx
+type queryer interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// presentFunc reports whether an install from before schema_migrations
+// already has a migration's changes
+type presentFunc func(queryer) (bool, error)
 
+// migration is one numbered step of the schema. Each runs in its own
+// transaction along with the schema_migrations row recording it. Versions
+// are never reused or reordered; add new steps to the end.
 type migration struct {
-	sql   string
-	check checkFunc
+	version     int
+	description string
+	sql         string
+	// present is only needed by migrations that existed before
+	// schema_migrations did, to baseline older installs
+	present presentFunc
 }
 
-var migrations = []migration{
+// sqliteSchemaMigrations records the migrations run, or baselined from an
+// existing schema, on this database
+const sqliteSchemaMigrations = `
+	CREATE TABLE schema_migrations (
+		version     INTEGER PRIMARY KEY,
+		description TEXT NOT NULL,
+		applied     DATETIME NOT NULL,
+		baseline    INTEGER NOT NULL DEFAULT 0
+	)
+`
+
+var sqliteMigrations = []migration{
 	{
+		version:     1,
+		description: "create servers",
 		sql: `
 			CREATE TABLE servers (
 				server_id      INTEGER PRIMARY KEY,
@@ -24,9 +51,11 @@ var migrations = []migration{
 				proxy_identity TEXT NOT NULL DEFAULT ''
 			)
 		`,
-		check: checkTableExists("servers"),
+		present: sqliteTableExists("servers"),
 	},
 	{
+		version:     2,
+		description: "create databases",
 		sql: `
 			CREATE TABLE databases (
 				database_id    INTEGER PRIMARY KEY,
@@ -41,9 +70,11 @@ var migrations = []migration{
 				FOREIGN KEY (server_id) REFERENCES servers (server_id)
 			)
 		`,
-		check: checkTableExists("databases"),
+		present: sqliteTableExists("databases"),
 	},
 	{
+		version:     3,
+		description: "create logs",
 		sql: `
 			CREATE TABLE logs (
 				log_id        INTEGER PRIMARY KEY,
@@ -56,28 +87,255 @@ var migrations = []migration{
 				FOREIGN KEY (database_id) REFERENCES servers (database_id)
 			)
 		`,
-		check: checkTableExists("logs"),
+		present: sqliteTableExists("logs"),
+	},
+	{
+		version:     4,
+		description: "add servers.schedule",
+		sql:         `ALTER TABLE servers ADD COLUMN schedule TEXT NOT NULL DEFAULT ''`,
+		present:     sqliteColumnExists("servers", "schedule"),
+	},
+	{
+		version:     5,
+		description: "add databases.schedule",
+		sql:         `ALTER TABLE databases ADD COLUMN schedule TEXT NOT NULL DEFAULT ''`,
+		present:     sqliteColumnExists("databases", "schedule"),
+	},
+	{
+		version:     6,
+		description: "create locks",
+		sql: `
+			CREATE TABLE locks (
+				name     TEXT PRIMARY KEY,
+				pid      INTEGER NOT NULL,
+				hostname TEXT NOT NULL,
+				acquired DATETIME NOT NULL
+			)
+		`,
+		present: sqliteTableExists("locks"),
+	},
+	{
+		version:     7,
+		description: "add servers.tags",
+		sql:         `ALTER TABLE servers ADD COLUMN tags TEXT NOT NULL DEFAULT ''`,
+		present:     sqliteColumnExists("servers", "tags"),
+	},
+	{
+		version:     8,
+		description: "add databases.tags",
+		sql:         `ALTER TABLE databases ADD COLUMN tags TEXT NOT NULL DEFAULT ''`,
+		present:     sqliteColumnExists("databases", "tags"),
+	},
+	{
+		version:     9,
+		description: "create settings",
+		sql: `
+			CREATE TABLE settings (
+				key   TEXT PRIMARY KEY,
+				value TEXT NOT NULL
+			)
+		`,
+		present: sqliteTableExists("settings"),
+	},
+	{
+		version:     10,
+		description: "add databases.timeout",
+		sql:         `ALTER TABLE databases ADD COLUMN timeout TEXT NOT NULL DEFAULT ''`,
+		present:     sqliteColumnExists("databases", "timeout"),
+	},
+	{
+		version:     11,
+		description: "add logs.status and logs.error",
+		sql: `
+			ALTER TABLE logs ADD COLUMN status TEXT NOT NULL DEFAULT '';
+			ALTER TABLE logs ADD COLUMN error TEXT NOT NULL DEFAULT '';
+			UPDATE logs SET status = 'success' WHERE backup_end IS NOT NULL;
+		`,
+		present: sqliteColumnExists("logs", "status"),
+	},
+	{
+		version:     12,
+		description: "create attempts",
+		sql: `
+			CREATE TABLE attempts (
+				attempt_id INTEGER PRIMARY KEY,
+				log_id     INTEGER NULL,
+				server_id  INTEGER NOT NULL,
+				step       TEXT NOT NULL,
+				number     INTEGER NOT NULL,
+				started    DATETIME NOT NULL,
+				ended      DATETIME NOT NULL,
+				error      TEXT NOT NULL DEFAULT '',
+				FOREIGN KEY (log_id) REFERENCES logs (log_id),
+				FOREIGN KEY (server_id) REFERENCES servers (server_id)
+			)
+		`,
+		present: sqliteTableExists("attempts"),
+	},
+	{
+		version:     13,
+		description: "add logs.warning",
+		sql:         `ALTER TABLE logs ADD COLUMN warning TEXT NOT NULL DEFAULT ''`,
+		present:     sqliteColumnExists("logs", "warning"),
+	},
+	{
+		version:     14,
+		description: "add servers.binlog",
+		sql:         `ALTER TABLE servers ADD COLUMN binlog TEXT NOT NULL DEFAULT ''`,
+		present:     sqliteColumnExists("servers", "binlog"),
+	},
+	{
+		version:     15,
+		description: "create binlogs",
+		sql: `
+			CREATE TABLE binlogs (
+				binlog_id INTEGER PRIMARY KEY,
+				server_id INTEGER NOT NULL,
+				name      TEXT NOT NULL,
+				key       TEXT NOT NULL,
+				size      INTEGER NOT NULL,
+				sha256    TEXT NOT NULL,
+				started   DATETIME NOT NULL,
+				added     DATETIME NOT NULL,
+				UNIQUE (server_id, name),
+				FOREIGN KEY (server_id) REFERENCES servers (server_id)
+			)
+		`,
+		present: sqliteTableExists("binlogs"),
+	},
+	{
+		version:     16,
+		description: "add databases.dump_mode",
+		sql:         `ALTER TABLE databases ADD COLUMN dump_mode TEXT NOT NULL DEFAULT 'single'`,
+		present:     sqliteColumnExists("databases", "dump_mode"),
+	},
+	{
+		version:     17,
+		description: "move table lists into table_rules",
+		// Moves the space-separated only_tables and exclude_tables into rules.
+		// Listing only some tables becomes skipping everything else.
+		sql: `
+			CREATE TABLE table_rules (
+				rule_id     INTEGER PRIMARY KEY,
+				database_id INTEGER NOT NULL,
+				table_name  TEXT NOT NULL,
+				mode        TEXT NOT NULL,
+				UNIQUE (database_id, table_name),
+				FOREIGN KEY (database_id) REFERENCES databases (database_id)
+			);
+			ALTER TABLE databases ADD COLUMN table_mode TEXT NOT NULL DEFAULT 'full';
+			INSERT OR IGNORE INTO table_rules (database_id, table_name, mode)
+				WITH RECURSIVE split (database_id, mode, rest, name) AS (
+					SELECT database_id, 'skip', trim(replace(replace(exclude_tables, char(9), ' '), char(10), ' ')) || ' ', ''
+					FROM databases
+					UNION ALL
+					SELECT database_id, 'full', trim(replace(replace(only_tables, char(9), ' '), char(10), ' ')) || ' ', ''
+					FROM databases
+					UNION ALL
+					SELECT database_id, mode, ltrim(substr(rest, instr(rest, ' '))), substr(rest, 1, instr(rest, ' ') - 1)
+					FROM split
+					WHERE rest != ''
+				)
+				SELECT database_id, name, mode FROM split WHERE name != '' ORDER BY mode DESC;
+			UPDATE databases SET table_mode = 'skip' WHERE trim(only_tables) != '';
+			ALTER TABLE databases DROP COLUMN only_tables;
+			ALTER TABLE databases DROP COLUMN exclude_tables;
+		`,
+		present: sqliteTableExists("table_rules"),
+	},
+	{
+		version:     18,
+		description: "add table_rules.position",
+		sql: `
+			ALTER TABLE table_rules ADD COLUMN position INTEGER NOT NULL DEFAULT 0;
+			UPDATE table_rules SET position = rule_id;
+		`,
+		present: sqliteColumnExists("table_rules", "position"),
+	},
+	{
+		version:     19,
+		description: "add table_rules.predicate",
+		sql:         `ALTER TABLE table_rules ADD COLUMN predicate TEXT NOT NULL DEFAULT ''`,
+		present:     sqliteColumnExists("table_rules", "predicate"),
+	},
+	{
+		version:     20,
+		description: "add servers.database_include and database_exclude",
+		sql: `
+			ALTER TABLE servers ADD COLUMN database_include TEXT NOT NULL DEFAULT '';
+			ALTER TABLE servers ADD COLUMN database_exclude TEXT NOT NULL DEFAULT '';
+		`,
+		present: sqliteColumnExists("servers", "database_include"),
+	},
+	{
+		version:     21,
+		description: "add servers.new_database_policy and databases.pending",
+		sql: `
+			ALTER TABLE servers ADD COLUMN new_database_policy TEXT NOT NULL DEFAULT 'enable';
+			ALTER TABLE databases ADD COLUMN pending INTEGER NOT NULL DEFAULT 0;
+		`,
+		present: sqliteColumnExists("databases", "pending"),
+	},
+	{
+		version:     22,
+		description: "create webhooks",
+		sql: `
+			CREATE TABLE webhooks (
+				webhook_id INTEGER PRIMARY KEY,
+				name       TEXT NOT NULL,
+				url        TEXT NOT NULL,
+				format     TEXT NOT NULL DEFAULT 'json',
+				events     TEXT NOT NULL DEFAULT '',
+				servers    TEXT NOT NULL DEFAULT '',
+				added      DATETIME NOT NULL
+			)
+		`,
+		present: sqliteTableExists("webhooks"),
+	},
+	{
+		version:     23,
+		description: "add servers.report_recipients",
+		sql:         `ALTER TABLE servers ADD COLUMN report_recipients TEXT NOT NULL DEFAULT ''`,
+		present:     sqliteColumnExists("servers", "report_recipients"),
+	},
+	{
+		version:     24,
+		description: "add databases.max_age",
+		sql:         `ALTER TABLE databases ADD COLUMN max_age TEXT NOT NULL DEFAULT ''`,
+		present:     sqliteColumnExists("databases", "max_age"),
 	},
 }
 
-func checkTableExists(name string) checkFunc {
-	return func(db *sql.DB) (bool, error) {
+func sqliteTableExists(name string) presentFunc {
+	return func(q queryer) (bool, error) {
 		sql := `
 			SELECT name
 			FROM sqlite_master
 			WHERE
 				type = 'table'
-				AND name = ?
+				AND name = $1
 		`
-		stmt, err := db.Prepare(sql)
+		rows, err := q.Query(sql, name)
 		if err != nil {
 			return false, err
 		}
-		rows, err := stmt.Query(name)
+		defer rows.Close()
+		return rows.Next(), rows.Err()
+	}
+}
+
+func sqliteColumnExists(table string, column string) presentFunc {
+	return func(q queryer) (bool, error) {
+		sql := `
+			SELECT name
+			FROM pragma_table_info($1)
+			WHERE name = $2
+		`
+		rows, err := q.Query(sql, table, column)
 		if err != nil {
 			return false, err
 		}
 		defer rows.Close()
-		return !rows.Next(), nil
+		return rows.Next(), rows.Err()
 	}
 }
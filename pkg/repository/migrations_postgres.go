@@ -0,0 +1,188 @@
+package repository
+
+// postgresSchemaMigrations records the migrations run on this database. There
+// were no PostgreSQL installs before migrations were recorded, so none are
+// baselined.
+const postgresSchemaMigrations = `
+	CREATE TABLE schema_migrations (
+		version     INTEGER PRIMARY KEY,
+		description TEXT NOT NULL,
+		applied     TIMESTAMP WITH TIME ZONE NOT NULL,
+		baseline    BOOLEAN NOT NULL DEFAULT FALSE
+	)
+`
+
+// postgresMigrations start from the tables as the SQLite migrations had left
+// them when PostgreSQL was added, and are numbered on their own. Foreign keys
+// are left out: SQLite never enforced them, and storage relies on that, e.g.
+// logs outlive their databases.
+var postgresMigrations = []migration{
+	{
+		version:     1,
+		description: "create servers",
+		sql: `
+			CREATE TABLE servers (
+				server_id           SERIAL PRIMARY KEY,
+				name                TEXT NOT NULL,
+				host                TEXT NOT NULL,
+				port                TEXT NOT NULL,
+				username            TEXT NOT NULL,
+				password            TEXT NOT NULL DEFAULT '',
+				proxy_host          TEXT NOT NULL DEFAULT '',
+				proxy_username      TEXT NOT NULL DEFAULT '',
+				proxy_identity      TEXT NOT NULL DEFAULT '',
+				schedule            TEXT NOT NULL DEFAULT '',
+				tags                TEXT NOT NULL DEFAULT '',
+				binlog              TEXT NOT NULL DEFAULT '',
+				database_include    TEXT NOT NULL DEFAULT '',
+				database_exclude    TEXT NOT NULL DEFAULT '',
+				new_database_policy TEXT NOT NULL DEFAULT 'enable',
+				report_recipients   TEXT NOT NULL DEFAULT ''
+			)
+		`,
+	},
+	{
+		version:     2,
+		description: "create databases",
+		sql: `
+			CREATE TABLE databases (
+				database_id SERIAL PRIMARY KEY,
+				server_id   INTEGER NOT NULL,
+				name        TEXT NOT NULL,
+				backup      BOOLEAN NOT NULL DEFAULT TRUE,
+				added       TIMESTAMP WITH TIME ZONE NOT NULL,
+				removed     TIMESTAMP WITH TIME ZONE,
+				schedule    TEXT NOT NULL DEFAULT '',
+				tags        TEXT NOT NULL DEFAULT '',
+				timeout     TEXT NOT NULL DEFAULT '',
+				dump_mode   TEXT NOT NULL DEFAULT 'single',
+				table_mode  TEXT NOT NULL DEFAULT 'full',
+				pending     BOOLEAN NOT NULL DEFAULT FALSE,
+				max_age     TEXT NOT NULL DEFAULT '',
+				UNIQUE (server_id, name)
+			)
+		`,
+	},
+	{
+		version:     3,
+		description: "create logs",
+		sql: `
+			CREATE TABLE logs (
+				log_id        SERIAL PRIMARY KEY,
+				database_id   INTEGER NOT NULL,
+				backup_start  TIMESTAMP WITH TIME ZONE NULL,
+				backup_end    TIMESTAMP WITH TIME ZONE NULL,
+				size_previous BIGINT NOT NULL DEFAULT 0,
+				size_current  BIGINT NOT NULL DEFAULT 0,
+				added         TIMESTAMP WITH TIME ZONE NOT NULL,
+				status        TEXT NOT NULL DEFAULT '',
+				error         TEXT NOT NULL DEFAULT '',
+				warning       TEXT NOT NULL DEFAULT ''
+			);
+			CREATE INDEX logs_database_id ON logs (database_id)
+		`,
+	},
+	{
+		version:     4,
+		description: "create locks",
+		sql: `
+			CREATE TABLE locks (
+				name     TEXT PRIMARY KEY,
+				pid      INTEGER NOT NULL,
+				hostname TEXT NOT NULL,
+				acquired TIMESTAMP WITH TIME ZONE NOT NULL
+			)
+		`,
+	},
+	{
+		version:     5,
+		description: "create settings",
+		sql: `
+			CREATE TABLE settings (
+				key   TEXT PRIMARY KEY,
+				value TEXT NOT NULL
+			)
+		`,
+	},
+	{
+		version:     6,
+		description: "create attempts",
+		sql: `
+			CREATE TABLE attempts (
+				attempt_id SERIAL PRIMARY KEY,
+				log_id     INTEGER NULL,
+				server_id  INTEGER NOT NULL,
+				step       TEXT NOT NULL,
+				number     INTEGER NOT NULL,
+				started    TIMESTAMP WITH TIME ZONE NOT NULL,
+				ended      TIMESTAMP WITH TIME ZONE NOT NULL,
+				error      TEXT NOT NULL DEFAULT ''
+			)
+		`,
+	},
+	{
+		version:     7,
+		description: "create binlogs",
+		sql: `
+			CREATE TABLE binlogs (
+				binlog_id SERIAL PRIMARY KEY,
+				server_id INTEGER NOT NULL,
+				name      TEXT NOT NULL,
+				key       TEXT NOT NULL,
+				size      BIGINT NOT NULL,
+				sha256    TEXT NOT NULL,
+				started   TIMESTAMP WITH TIME ZONE NOT NULL,
+				added     TIMESTAMP WITH TIME ZONE NOT NULL,
+				UNIQUE (server_id, name)
+			)
+		`,
+	},
+	{
+		version:     8,
+		description: "create table_rules",
+		sql: `
+			CREATE TABLE table_rules (
+				rule_id     SERIAL PRIMARY KEY,
+				database_id INTEGER NOT NULL,
+				table_name  TEXT NOT NULL,
+				mode        TEXT NOT NULL,
+				position    INTEGER NOT NULL DEFAULT 0,
+				predicate   TEXT NOT NULL DEFAULT '',
+				UNIQUE (database_id, table_name)
+			)
+		`,
+	},
+	{
+		version:     9,
+		description: "create webhooks",
+		sql: `
+			CREATE TABLE webhooks (
+				webhook_id SERIAL PRIMARY KEY,
+				name       TEXT NOT NULL,
+				url        TEXT NOT NULL,
+				format     TEXT NOT NULL DEFAULT 'json',
+				events     TEXT NOT NULL DEFAULT '',
+				servers    TEXT NOT NULL DEFAULT '',
+				added      TIMESTAMP WITH TIME ZONE NOT NULL
+			)
+		`,
+	},
+}
+
+func postgresTableExists(name string) presentFunc {
+	return func(q queryer) (bool, error) {
+		sql := `
+			SELECT table_name
+			FROM information_schema.tables
+			WHERE
+				table_schema = current_schema()
+				AND table_name = $1
+		`
+		rows, err := q.Query(sql, name)
+		if err != nil {
+			return false, err
+		}
+		defer rows.Close()
+		return rows.Next(), rows.Err()
+	}
+}
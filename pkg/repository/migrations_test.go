@@ -0,0 +1,185 @@
+package repository_test
+
+import (
+	"database/sql"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jbaikge/database-backups/pkg/api"
+	"github.com/jbaikge/database-backups/pkg/repository"
+	"github.com/zeebo/assert"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// openSnapshot loads a schema from testdata into a new in-memory database
+func openSnapshot(t *testing.T, filename string) *sql.DB {
+	db, err := sql.Open("sqlite3", ":memory:")
+	assert.Nil(t, err)
+	db.SetMaxOpenConns(1)
+
+	if filename != "" {
+		snapshot, err := ioutil.ReadFile(filename)
+		assert.Nil(t, err)
+		_, err = db.Exec(string(snapshot))
+		assert.Nil(t, err)
+	}
+	return db
+}
+
+// columns lists the columns of every table by table name
+func columns(t *testing.T, db *sql.DB) map[string][]string {
+	rows, err := db.Query(`
+		SELECT m.name, c.name
+		FROM sqlite_master AS m, pragma_table_info(m.name) AS c
+		WHERE m.type = 'table'
+		ORDER BY m.name, c.cid
+	`)
+	assert.Nil(t, err)
+	defer rows.Close()
+
+	tables := make(map[string][]string)
+	for rows.Next() {
+		var table, column string
+		assert.Nil(t, rows.Scan(&table, &column))
+		tables[table] = append(tables[table], column)
+	}
+	assert.Nil(t, rows.Err())
+	return tables
+}
+
+func TestMigrateEmpty(t *testing.T) {
+	backends(t, func(t *testing.T, storage repository.Storage) {
+		pending, err := storage.ListMigrations()
+		assert.Nil(t, err)
+		assert.That(t, len(pending) > 0)
+		for i, migration := range pending {
+			assert.Equal(t, migration.Version, i+1)
+			assert.Nil(t, migration.Applied)
+			assert.False(t, migration.Baseline)
+		}
+
+		assert.Nil(t, storage.RunMigrations())
+		applied, err := storage.ListMigrations()
+		assert.Nil(t, err)
+		assert.Equal(t, len(applied), len(pending))
+		for _, migration := range applied {
+			assert.NotNil(t, migration.Applied)
+			assert.False(t, migration.Baseline)
+		}
+
+		// Nothing left to run
+		assert.Nil(t, storage.RunMigrations())
+		again, err := storage.ListMigrations()
+		assert.Nil(t, err)
+		assert.DeepEqual(t, again, applied)
+	})
+}
+
+func TestMigrateUnversioned(t *testing.T) {
+	db := openSnapshot(t, "testdata/unversioned.sql")
+	storage := repository.NewStorage(db)
+
+	// Every step is already made, so the first run only records them
+	pending, err := storage.ListMigrations()
+	assert.Nil(t, err)
+	for _, migration := range pending {
+		assert.Nil(t, migration.Applied)
+		assert.That(t, migration.Baseline)
+	}
+
+	assert.Nil(t, storage.RunMigrations())
+	applied, err := storage.ListMigrations()
+	assert.Nil(t, err)
+	assert.Equal(t, len(applied), len(pending))
+	for _, migration := range applied {
+		assert.NotNil(t, migration.Applied)
+		assert.That(t, migration.Baseline)
+	}
+
+	fresh := openSnapshot(t, "")
+	assert.Nil(t, repository.NewStorage(fresh).RunMigrations())
+	assert.DeepEqual(t, columns(t, db), columns(t, fresh))
+
+	database, err := storage.GetDatabase(1)
+	assert.Nil(t, err)
+	assert.Equal(t, database.Name, "shop")
+	assert.Equal(t, database.DumpMode, api.DumpModePerTable)
+	assert.Equal(t, database.MaxAge, "48h")
+	assert.DeepEqual(t, database.Tables, []api.TableRule{{Table: "cache_*", Mode: api.TableModeSkip}})
+
+	setting, err := storage.GetSetting(api.SettingMaxAge)
+	assert.Nil(t, err)
+	assert.Equal(t, setting.Value, "24h")
+}
+
+func TestMigrateUnversionedEarly(t *testing.T) {
+	db := openSnapshot(t, "testdata/unversioned-early.sql")
+	storage := repository.NewStorage(db)
+
+	assert.Nil(t, storage.RunMigrations())
+	applied, err := storage.ListMigrations()
+	assert.Nil(t, err)
+	for _, migration := range applied {
+		assert.NotNil(t, migration.Applied)
+		// Only servers, databases and logs were there before
+		assert.Equal(t, migration.Baseline, migration.Version <= 3)
+	}
+
+	fresh := openSnapshot(t, "")
+	assert.Nil(t, repository.NewStorage(fresh).RunMigrations())
+	assert.DeepEqual(t, columns(t, db), columns(t, fresh))
+
+	// Table lists became rules
+	shop, err := storage.GetDatabase(1)
+	assert.Nil(t, err)
+	assert.Equal(t, shop.TableMode, api.TableModeSkip)
+	modes := make(map[string]string)
+	for _, rule := range shop.Tables {
+		modes[rule.Table] = rule.Mode
+	}
+	assert.DeepEqual(t, modes, map[string]string{
+		"customers": api.TableModeFull,
+		"orders":    api.TableModeFull,
+	})
+
+	crm, err := storage.GetDatabase(2)
+	assert.Nil(t, err)
+	assert.Equal(t, crm.TableMode, api.TableModeFull)
+	assert.DeepEqual(t, crm.Tables, []api.TableRule{{Table: "sessions", Mode: api.TableModeSkip}})
+
+	// Finished backups were marked successful
+	logs, err := storage.ListLogs(1)
+	assert.Nil(t, err)
+	assert.Equal(t, len(logs), 1)
+	assert.Equal(t, logs[0].Status, api.LogStatusSuccess)
+}
+
+func TestOpenReadOnly(t *testing.T) {
+	dir, err := ioutil.TempDir("", "storage")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "backups.sqlite3")
+
+	// Nothing is created
+	_, err = repository.OpenReadOnly(path)
+	assert.Error(t, err)
+	_, err = os.Stat(path)
+	assert.That(t, os.IsNotExist(err))
+
+	// Nor migrated
+	assert.Nil(t, ioutil.WriteFile(path, nil, 0600))
+	_, err = repository.OpenReadOnly(path)
+	assert.That(t, errors.Is(err, repository.ErrSchemaOutdated))
+
+	storage, err := repository.Open(path)
+	assert.Nil(t, err)
+	assert.Nil(t, storage.RunMigrations())
+	storage, err = repository.OpenReadOnly(path)
+	assert.Nil(t, err)
+	_, err = storage.CreateServer(api.NewServerRequest{Name: "db1"})
+	assert.Error(t, err)
+}
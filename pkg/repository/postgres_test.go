@@ -0,0 +1,135 @@
+package repository_test
+
+import (
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jbaikge/database-backups/pkg/repository"
+	"github.com/zeebo/assert"
+
+	_ "github.com/lib/pq"
+)
+
+// postgresDSN is the server the tests make their schemas on: the one named by
+// DATABASE_BACKUP_TEST_POSTGRES, or else one started for the tests when
+// initdb and pg_ctl are on the PATH
+var postgresDSN string
+
+func TestMain(m *testing.M) {
+	stop := func() {}
+	postgresDSN = os.Getenv("DATABASE_BACKUP_TEST_POSTGRES")
+	if postgresDSN == "" {
+		dsn, stopServer, err := startPostgres()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Skipping PostgreSQL tests: %s\n", err)
+		} else {
+			postgresDSN, stop = dsn, stopServer
+		}
+	}
+	if postgresDSN != "" {
+		openPostgres = openPostgresSchema
+	}
+
+	code := m.Run()
+	stop()
+	os.Exit(code)
+}
+
+// postgresTool finds a server program on the PATH or where Debian puts it
+func postgresTool(name string) (string, error) {
+	path, err := exec.LookPath(name)
+	if err == nil {
+		return path, nil
+	}
+	matches, _ := filepath.Glob(filepath.Join("/usr/lib/postgresql/*/bin", name))
+	if len(matches) == 0 {
+		return "", err
+	}
+	sort.Strings(matches)
+	return matches[len(matches)-1], nil
+}
+
+// startPostgres starts a throwaway server listening only on a unix socket in
+// a temporary directory
+func startPostgres() (dsn string, stop func(), err error) {
+	initdbPath, err := postgresTool("initdb")
+	if err != nil {
+		return "", nil, err
+	}
+	pgCtl, err := postgresTool("pg_ctl")
+	if err != nil {
+		return "", nil, err
+	}
+
+	dir, err := ioutil.TempDir("", "database-backups-postgres")
+	if err != nil {
+		return "", nil, err
+	}
+	data := filepath.Join(dir, "data")
+
+	initdb := exec.Command(initdbPath, "-D", data, "-U", "postgres", "-A", "trust")
+	if out, err := initdb.CombinedOutput(); err != nil {
+		os.RemoveAll(dir)
+		return "", nil, fmt.Errorf("initdb: %s: %s", err, out)
+	}
+
+	options := fmt.Sprintf("-c listen_addresses='' -k %s", dir)
+	start := exec.Command(pgCtl, "-D", data, "-l", filepath.Join(dir, "log"), "-o", options, "-w", "start")
+	if out, err := start.CombinedOutput(); err != nil {
+		os.RemoveAll(dir)
+		return "", nil, fmt.Errorf("pg_ctl start: %s: %s", err, out)
+	}
+
+	stop = func() {
+		exec.Command(pgCtl, "-D", data, "-m", "immediate", "stop").Run()
+		os.RemoveAll(dir)
+	}
+	return fmt.Sprintf("host=%s user=postgres dbname=postgres sslmode=disable", dir), stop, nil
+}
+
+// openPostgresSchema returns storage in an empty schema of its own, dropped
+// once the test is over
+func openPostgresSchema(t *testing.T) repository.Storage {
+	admin, err := sql.Open("postgres", postgresDSN)
+	assert.Nil(t, err)
+
+	schema := fmt.Sprintf("test_%d", time.Now().UnixNano())
+	_, err = admin.Exec("CREATE SCHEMA " + schema)
+	assert.Nil(t, err)
+	t.Cleanup(func() {
+		admin.Exec("DROP SCHEMA " + schema + " CASCADE")
+		admin.Close()
+	})
+
+	db, err := sql.Open("postgres", withSearchPath(postgresDSN, schema))
+	assert.Nil(t, err)
+	t.Cleanup(func() {
+		db.Close()
+	})
+	return repository.NewPostgresStorage(db)
+}
+
+// withSearchPath adds search_path to a URL or key=value DSN
+func withSearchPath(dsn string, schema string) string {
+	if !strings.HasPrefix(dsn, "postgres://") && !strings.HasPrefix(dsn, "postgresql://") {
+		return dsn + " search_path=" + schema
+	}
+
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return dsn
+	}
+	query := u.Query()
+	query.Set("search_path", schema)
+	u.RawQuery = query.Encode()
+	return u.String()
+}
@@ -3,6 +3,7 @@ package repository
 import (
 	"database/sql"
 	"errors"
+	"fmt"
 	"sort"
 	"time"
 
@@ -10,41 +11,149 @@ import (
 )
 
 type Storage interface {
+	CreateAttempt(api.Attempt) error
+	CreateBinlog(api.Binlog) error
 	CreateDatabase(api.NewDatabaseRequest) error
+	CreateLock(api.Lock) error
+	CreateLog(api.Log) (int, error)
 	CreateServer(api.NewServerRequest) (int, error)
+	CreateWebhook(api.NewWebhookRequest) (int, error)
 	DeleteDatabase(int) error
+	DeleteLock(api.Lock) error
+	DeleteStaleLock(string, time.Time) error
 	DeleteServer(int) error
+	DeleteWebhook(int) error
 	GetDatabase(int) (*api.Database, error)
+	GetLastLog(int) (*api.Log, error)
+	GetLock(string) (*api.Lock, error)
+	GetLastSuccessfulLog(int) (*api.Log, error)
 	GetServer(int) (*api.Server, error)
+	GetSetting(string) (*api.Setting, error)
+	GetWebhook(int) (*api.Webhook, error)
+	ListAttempts(int) ([]api.Attempt, error)
+	ListBackupStats() ([]api.BackupStats, error)
+	ListBinlogs(int) ([]api.Binlog, error)
 	ListDatabases(int) ([]api.Database, error)
+	ListLogs(int) ([]api.Log, error)
+	ListMigrations() ([]api.Migration, error)
+	ListPendingDatabases() ([]api.Database, error)
 	ListServers() ([]api.Server, error)
+	ListSettings() ([]api.Setting, error)
+	ListWebhooks() ([]api.Webhook, error)
 	RunMigrations() error
 	ServerTree() ([]api.Tree, error)
+	SetSetting(api.Setting) error
 	UpdateDatabase(int, api.UpdateDatabaseRequest) error
+	UpdateLog(int, api.Log) error
 	UpdateServer(int, api.NewServerRequest) error
-	UpdateServerDatabases(int, []string) error
+	UpdateServerDatabases(int, []api.NewDatabaseRequest) ([]string, []string, error)
+	UpdateWebhook(int, api.NewWebhookRequest) error
 }
 
 type storage struct {
-	db *sql.DB
+	db      *sql.DB
+	dialect dialect
 }
 
+// NewStorage keeps everything in a SQLite database
 func NewStorage(db *sql.DB) Storage {
 	return &storage{
-		db: db,
+		db:      db,
+		dialect: sqlite,
 	}
 }
 
+// NewPostgresStorage keeps everything in a PostgreSQL database, which several
+// copies of the API can share
+func NewPostgresStorage(db *sql.DB) Storage {
+	return &storage{
+		db:      db,
+		dialect: postgres,
+	}
+}
+
+func (s *storage) CreateAttempt(attempt api.Attempt) error {
+	query := `
+		INSERT INTO attempts (
+			log_id,
+			server_id,
+			step,
+			number,
+			started,
+			ended,
+			error
+		) VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`
+	stmt, err := s.db.Prepare(query)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	_, err = stmt.Exec(
+		attempt.LogId,
+		attempt.ServerId,
+		attempt.Step,
+		attempt.Number,
+		attempt.Started,
+		attempt.Ended,
+		attempt.Error,
+	)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (s *storage) CreateBinlog(binlog api.Binlog) error {
+	query := `
+		INSERT INTO binlogs (
+			server_id,
+			name,
+			key,
+			size,
+			sha256,
+			started,
+			added
+		) VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`
+	stmt, err := s.db.Prepare(query)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	_, err = stmt.Exec(
+		binlog.ServerId,
+		binlog.Name,
+		binlog.Key,
+		binlog.Size,
+		binlog.SHA256,
+		binlog.Started,
+		time.Now(),
+	)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
 func (s *storage) CreateDatabase(db api.NewDatabaseRequest) error {
 	query := `
 		INSERT INTO databases (
 			server_id,
 			name,
+			backup,
+			pending,
 			added
 		) VALUES (
 			$1,
 			$2,
-			$3
+			$3,
+			$4,
+			$5
 		)
 	`
 	stmt, err := s.db.Prepare(query)
@@ -53,7 +162,30 @@ func (s *storage) CreateDatabase(db api.NewDatabaseRequest) error {
 	}
 	defer stmt.Close()
 
-	_, err = stmt.Exec(db.ServerId, db.Name, time.Now())
+	_, err = stmt.Exec(db.ServerId, db.Name, db.Backup, db.Pending, time.Now())
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (s *storage) CreateLock(lock api.Lock) error {
+	query := `
+		INSERT INTO locks (
+			name,
+			pid,
+			hostname,
+			acquired
+		) VALUES ($1, $2, $3, $4)
+	`
+	stmt, err := s.db.Prepare(query)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	_, err = stmt.Exec(lock.Name, lock.Pid, lock.Hostname, lock.Acquired)
 	if err != nil {
 		return err
 	}
@@ -61,6 +193,41 @@ func (s *storage) CreateDatabase(db api.NewDatabaseRequest) error {
 	return nil
 }
 
+func (s *storage) CreateLog(log api.Log) (id int, err error) {
+	query := `
+		INSERT INTO logs (
+			database_id,
+			backup_start,
+			backup_end,
+			size_previous,
+			size_current,
+			status,
+			error,
+			warning,
+			added
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		RETURNING log_id
+	`
+	stmt, err := s.db.Prepare(query)
+	if err != nil {
+		return
+	}
+	defer stmt.Close()
+
+	err = stmt.QueryRow(
+		log.DatabaseId,
+		log.BackupStart,
+		log.BackupEnd,
+		log.SizePrevious,
+		log.SizeCurrent,
+		log.Status,
+		log.Error,
+		log.Warning,
+		time.Now(),
+	).Scan(&id)
+	return
+}
+
 func (s *storage) CreateServer(server api.NewServerRequest) (id int, err error) {
 	query := `
 		INSERT INTO servers (
@@ -71,15 +238,24 @@ func (s *storage) CreateServer(server api.NewServerRequest) (id int, err error)
 			password,
 			proxy_host,
 			proxy_username,
-			proxy_identity
-		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+			proxy_identity,
+			schedule,
+			tags,
+			binlog,
+			database_include,
+			database_exclude,
+			new_database_policy,
+			report_recipients
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)
+		RETURNING server_id
 	`
 	stmt, err := s.db.Prepare(query)
 	if err != nil {
 		return
 	}
+	defer stmt.Close()
 
-	result, err := stmt.Exec(
+	err = stmt.QueryRow(
 		server.Name,
 		server.Host,
 		server.Port,
@@ -88,23 +264,49 @@ func (s *storage) CreateServer(server api.NewServerRequest) (id int, err error)
 		server.ProxyHost,
 		server.ProxyUsername,
 		server.ProxyIdentity,
-	)
-	if err != nil {
-		return
-	}
+		server.Schedule,
+		server.Tags,
+		server.Binlog,
+		server.DatabaseInclude,
+		server.DatabaseExclude,
+		server.NewDatabasePolicy,
+		server.ReportRecipients,
+	).Scan(&id)
+	return
+}
 
-	id64, err := result.LastInsertId()
+func (s *storage) CreateWebhook(webhook api.NewWebhookRequest) (id int, err error) {
+	query := `
+		INSERT INTO webhooks (
+			name,
+			url,
+			format,
+			events,
+			servers,
+			added
+		) VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING webhook_id
+	`
+	stmt, err := s.db.Prepare(query)
 	if err != nil {
 		return
 	}
+	defer stmt.Close()
 
-	id = int(id64)
+	err = stmt.QueryRow(
+		webhook.Name,
+		webhook.URL,
+		webhook.Format,
+		webhook.Events,
+		webhook.Servers,
+		time.Now(),
+	).Scan(&id)
 	return
 }
 
 func (s *storage) DeleteDatabase(id int) error {
 	query := `
-		UPDATE databases SET backup = 0, removed = $1 WHERE database_id = $2
+		UPDATE databases SET backup = FALSE, pending = FALSE, removed = $1 WHERE database_id = $2
 	`
 	stmt, err := s.db.Prepare(query)
 	if err != nil {
@@ -120,10 +322,57 @@ func (s *storage) DeleteDatabase(id int) error {
 	return nil
 }
 
+func (s *storage) DeleteLock(lock api.Lock) error {
+	query := `
+		DELETE FROM locks WHERE name = $1 AND pid = $2 AND hostname = $3
+	`
+	stmt, err := s.db.Prepare(query)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	_, err = stmt.Exec(lock.Name, lock.Pid, lock.Hostname)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (s *storage) DeleteStaleLock(name string, before time.Time) error {
+	query := `
+		DELETE FROM locks WHERE name = $1 AND acquired < $2
+	`
+	stmt, err := s.db.Prepare(query)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	_, err = stmt.Exec(name, before)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
 func (s *storage) DeleteServer(id int) error {
+	queryRules := `DELETE FROM table_rules WHERE database_id IN (SELECT database_id FROM databases WHERE server_id = $1)`
 	queryDatabase := `DELETE FROM databases WHERE server_id = $1`
 	queryServer := `DELETE FROM servers WHERE server_id = $1`
 
+	stmtRules, err := s.db.Prepare(queryRules)
+	if err != nil {
+		return err
+	}
+	defer stmtRules.Close()
+
+	if _, err := stmtRules.Exec(id); err != nil {
+		return err
+	}
+
 	stmtDatabase, err := s.db.Prepare(queryDatabase)
 	if err != nil {
 		return err
@@ -147,6 +396,21 @@ func (s *storage) DeleteServer(id int) error {
 	return nil
 }
 
+func (s *storage) DeleteWebhook(id int) error {
+	query := `DELETE FROM webhooks WHERE webhook_id = $1`
+	stmt, err := s.db.Prepare(query)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	if _, err := stmt.Exec(id); err != nil {
+		return err
+	}
+
+	return nil
+}
+
 func (s *storage) GetDatabase(id int) (*api.Database, error) {
 	query := `
 		SELECT
@@ -154,8 +418,13 @@ func (s *storage) GetDatabase(id int) (*api.Database, error) {
 			server_id,
 			name,
 			backup,
-			only_tables,
-			exclude_tables,
+			schedule,
+			tags,
+			timeout,
+			max_age,
+			dump_mode,
+			table_mode,
+			pending,
 			added,
 			removed
 		FROM databases
@@ -173,8 +442,13 @@ func (s *storage) GetDatabase(id int) (*api.Database, error) {
 		&db.ServerId,
 		&db.Name,
 		&db.Backup,
-		&db.OnlyTables,
-		&db.ExcludeTables,
+		&db.Schedule,
+		&db.Tags,
+		&db.Timeout,
+		&db.MaxAge,
+		&db.DumpMode,
+		&db.TableMode,
+		&db.Pending,
 		&db.Added,
 		&db.Removed,
 	)
@@ -185,9 +459,144 @@ func (s *storage) GetDatabase(id int) (*api.Database, error) {
 		return nil, err
 	}
 
+	rules, err := s.tableRules(`WHERE database_id = $1`, id)
+	if err != nil {
+		return nil, err
+	}
+	db.Tables = rules[db.Id]
+
 	return db, nil
 }
 
+func (s *storage) GetLastLog(databaseId int) (*api.Log, error) {
+	query := `
+		SELECT
+			log_id,
+			database_id,
+			backup_start,
+			backup_end,
+			size_previous,
+			size_current,
+			status,
+			error,
+			warning,
+			added
+		FROM logs
+		WHERE database_id = $1
+		ORDER BY log_id DESC
+		LIMIT 1
+	`
+	stmt, err := s.db.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	log := new(api.Log)
+	err = stmt.QueryRow(databaseId).Scan(
+		&log.Id,
+		&log.DatabaseId,
+		&log.BackupStart,
+		&log.BackupEnd,
+		&log.SizePrevious,
+		&log.SizeCurrent,
+		&log.Status,
+		&log.Error,
+		&log.Warning,
+		&log.Added,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return log, nil
+}
+
+func (s *storage) GetLastSuccessfulLog(databaseId int) (*api.Log, error) {
+	query := `
+		SELECT
+			log_id,
+			database_id,
+			backup_start,
+			backup_end,
+			size_previous,
+			size_current,
+			status,
+			error,
+			warning,
+			added
+		FROM logs
+		WHERE
+			database_id = $1
+			AND status = 'success'
+		ORDER BY log_id DESC
+		LIMIT 1
+	`
+	stmt, err := s.db.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	log := new(api.Log)
+	err = stmt.QueryRow(databaseId).Scan(
+		&log.Id,
+		&log.DatabaseId,
+		&log.BackupStart,
+		&log.BackupEnd,
+		&log.SizePrevious,
+		&log.SizeCurrent,
+		&log.Status,
+		&log.Error,
+		&log.Warning,
+		&log.Added,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return log, nil
+}
+
+func (s *storage) GetLock(name string) (*api.Lock, error) {
+	query := `
+		SELECT
+			name,
+			pid,
+			hostname,
+			acquired
+		FROM locks
+		WHERE name = $1
+	`
+	stmt, err := s.db.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	lock := new(api.Lock)
+	err = stmt.QueryRow(name).Scan(
+		&lock.Name,
+		&lock.Pid,
+		&lock.Hostname,
+		&lock.Acquired,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return lock, nil
+}
+
 func (s *storage) GetServer(id int) (*api.Server, error) {
 	query := `
 		SELECT
@@ -199,7 +608,14 @@ func (s *storage) GetServer(id int) (*api.Server, error) {
 			password,
 			proxy_host,
 			proxy_username,
-			proxy_identity
+			proxy_identity,
+			schedule,
+			tags,
+			binlog,
+			database_include,
+			database_exclude,
+			new_database_policy,
+			report_recipients
 		FROM servers
 		WHERE server_id = $1
 		ORDER BY name ASC
@@ -221,6 +637,13 @@ func (s *storage) GetServer(id int) (*api.Server, error) {
 		&server.ProxyHost,
 		&server.ProxyUsername,
 		&server.ProxyIdentity,
+		&server.Schedule,
+		&server.Tags,
+		&server.Binlog,
+		&server.DatabaseInclude,
+		&server.DatabaseExclude,
+		&server.NewDatabasePolicy,
+		&server.ReportRecipients,
 	)
 	if err == sql.ErrNoRows {
 		return nil, nil
@@ -232,20 +655,13 @@ func (s *storage) GetServer(id int) (*api.Server, error) {
 	return server, nil
 }
 
-func (s *storage) ListDatabases(serverId int) ([]api.Database, error) {
+func (s *storage) GetSetting(key string) (*api.Setting, error) {
 	query := `
 		SELECT
-			database_id,
-			server_id,
-			name,
-			backup,
-			only_tables,
-			exclude_tables,
-			added,
-			removed
-		FROM databases
-		WHERE server_id = $1
-		ORDER BY name ASC
+			key,
+			value
+		FROM settings
+		WHERE key = $1
 	`
 	stmt, err := s.db.Prepare(query)
 	if err != nil {
@@ -253,61 +669,378 @@ func (s *storage) ListDatabases(serverId int) ([]api.Database, error) {
 	}
 	defer stmt.Close()
 
-	rows, err := stmt.Query(serverId)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	dbs := make([]api.Database, 0, 1000)
-	for rows.Next() {
-		var db api.Database
-		err := rows.Scan(
-			&db.Id,
-			&db.ServerId,
-			&db.Name,
-			&db.Backup,
-			&db.OnlyTables,
-			&db.ExcludeTables,
-			&db.Added,
-			&db.Removed,
-		)
-		if err != nil {
-			return nil, err
-		}
-		dbs = append(dbs, db)
+	setting := new(api.Setting)
+	err = stmt.QueryRow(key).Scan(
+		&setting.Key,
+		&setting.Value,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
 	}
-	if err := rows.Err(); err != nil {
+	if err != nil {
 		return nil, err
 	}
 
-	return dbs, nil
+	return setting, nil
 }
 
-func (s *storage) ListServers() ([]api.Server, error) {
+func (s *storage) GetWebhook(id int) (*api.Webhook, error) {
 	query := `
 		SELECT
-			server_id,
+			webhook_id,
 			name,
-			host,
-			port,
-			username,
-			password,
-			proxy_host,
-			proxy_username,
-			proxy_identity
-		FROM servers
-		ORDER BY name ASC
+			url,
+			format,
+			events,
+			servers,
+			added
+		FROM webhooks
+		WHERE webhook_id = $1
 	`
-	rows, err := s.db.Query(query)
+	stmt, err := s.db.Prepare(query)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
+	defer stmt.Close()
 
-	servers := make([]api.Server, 0, 100)
-	for rows.Next() {
-		var v api.Server
+	webhook := new(api.Webhook)
+	err = stmt.QueryRow(id).Scan(
+		&webhook.Id,
+		&webhook.Name,
+		&webhook.URL,
+		&webhook.Format,
+		&webhook.Events,
+		&webhook.Servers,
+		&webhook.Added,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return webhook, nil
+}
+
+func (s *storage) ListAttempts(logId int) ([]api.Attempt, error) {
+	query := `
+		SELECT
+			attempt_id,
+			log_id,
+			server_id,
+			step,
+			number,
+			started,
+			ended,
+			error
+		FROM attempts
+		WHERE log_id = $1
+		ORDER BY attempt_id ASC
+	`
+	stmt, err := s.db.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.Query(logId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	attempts := make([]api.Attempt, 0, 10)
+	for rows.Next() {
+		var attempt api.Attempt
+		err := rows.Scan(
+			&attempt.Id,
+			&attempt.LogId,
+			&attempt.ServerId,
+			&attempt.Step,
+			&attempt.Number,
+			&attempt.Started,
+			&attempt.Ended,
+			&attempt.Error,
+		)
+		if err != nil {
+			return nil, err
+		}
+		attempts = append(attempts, attempt)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return attempts, nil
+}
+
+// ListBackupStats sums up the logs of every database being backed up,
+// ordered by server and database name
+func (s *storage) ListBackupStats() ([]api.BackupStats, error) {
+	query := `
+		SELECT
+			servers.name,
+			databases.name,
+			logs.backup_start,
+			logs.backup_end,
+			logs.size_current,
+			(
+				SELECT COUNT(*)
+				FROM logs AS failures
+				WHERE
+					failures.database_id = databases.database_id
+					AND failures.status IN ('failed', 'timeout')
+			),
+			databases.max_age,
+			databases.added
+		FROM databases
+		JOIN servers ON servers.server_id = databases.server_id
+		LEFT JOIN logs ON logs.log_id = (
+			SELECT MAX(log_id)
+			FROM logs
+			WHERE
+				database_id = databases.database_id
+				AND status = 'success'
+		)
+		WHERE
+			databases.backup = TRUE
+			AND databases.removed IS NULL
+		ORDER BY servers.name ASC, databases.name ASC
+	`
+	rows, err := s.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stats := make([]api.BackupStats, 0, 100)
+	for rows.Next() {
+		var v api.BackupStats
+		var start, end *time.Time
+		var size sql.NullInt64
+		err := rows.Scan(
+			&v.Server,
+			&v.Database,
+			&start,
+			&end,
+			&size,
+			&v.Failures,
+			&v.MaxAge,
+			&v.Added,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if start != nil && end != nil {
+			v.LastSuccess = end
+			v.LastDuration = end.Sub(*start)
+		}
+		v.LastSize = size.Int64
+		stats = append(stats, v)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return stats, nil
+}
+
+func (s *storage) ListBinlogs(serverId int) ([]api.Binlog, error) {
+	query := `
+		SELECT
+			binlog_id,
+			server_id,
+			name,
+			key,
+			size,
+			sha256,
+			started,
+			added
+		FROM binlogs
+		WHERE server_id = $1
+		ORDER BY name ASC
+	`
+	stmt, err := s.db.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.Query(serverId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	binlogs := make([]api.Binlog, 0, 100)
+	for rows.Next() {
+		var binlog api.Binlog
+		err := rows.Scan(
+			&binlog.Id,
+			&binlog.ServerId,
+			&binlog.Name,
+			&binlog.Key,
+			&binlog.Size,
+			&binlog.SHA256,
+			&binlog.Started,
+			&binlog.Added,
+		)
+		if err != nil {
+			return nil, err
+		}
+		binlogs = append(binlogs, binlog)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return binlogs, nil
+}
+
+func (s *storage) ListDatabases(serverId int) ([]api.Database, error) {
+	return s.databases(`WHERE server_id = $1`, serverId)
+}
+
+func (s *storage) ListLogs(databaseId int) ([]api.Log, error) {
+	query := `
+		SELECT
+			log_id,
+			database_id,
+			backup_start,
+			backup_end,
+			size_previous,
+			size_current,
+			status,
+			error,
+			warning,
+			added
+		FROM logs
+		WHERE database_id = $1
+		ORDER BY log_id DESC
+	`
+	stmt, err := s.db.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.Query(databaseId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	logs := make([]api.Log, 0, 100)
+	for rows.Next() {
+		var log api.Log
+		err := rows.Scan(
+			&log.Id,
+			&log.DatabaseId,
+			&log.BackupStart,
+			&log.BackupEnd,
+			&log.SizePrevious,
+			&log.SizeCurrent,
+			&log.Status,
+			&log.Error,
+			&log.Warning,
+			&log.Added,
+		)
+		if err != nil {
+			return nil, err
+		}
+		logs = append(logs, log)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return logs, nil
+}
+
+// ListMigrations lists every migration with when it was applied, followed by
+// any recorded by a newer version. Without schema_migrations, nothing is
+// applied yet and Baseline marks what the next run will record, not run.
+func (s *storage) ListMigrations() ([]api.Migration, error) {
+	recorded, err := s.dialect.tableExists("schema_migrations")(s.db)
+	if err != nil {
+		return nil, err
+	}
+
+	applied := make(map[int]api.Migration)
+	if recorded {
+		if applied, err = s.appliedMigrations(); err != nil {
+			return nil, err
+		}
+	}
+
+	list := make([]api.Migration, 0, len(s.dialect.migrations))
+	for _, migration := range s.dialect.migrations {
+		if entry, ok := applied[migration.version]; ok {
+			list = append(list, entry)
+			delete(applied, migration.version)
+			continue
+		}
+
+		entry := api.Migration{
+			Version:     migration.version,
+			Description: migration.description,
+		}
+		if !recorded && migration.present != nil {
+			if entry.Baseline, err = migration.present(s.db); err != nil {
+				return nil, err
+			}
+		}
+		list = append(list, entry)
+	}
+
+	unknown := make([]api.Migration, 0, len(applied))
+	for _, entry := range applied {
+		unknown = append(unknown, entry)
+	}
+	sort.Slice(unknown, func(i, j int) bool {
+		return unknown[i].Version < unknown[j].Version
+	})
+	return append(list, unknown...), nil
+}
+
+// ListPendingDatabases returns the databases waiting for review on every
+// server
+func (s *storage) ListPendingDatabases() ([]api.Database, error) {
+	return s.databases(`WHERE pending = TRUE AND removed IS NULL`)
+}
+
+func (s *storage) ListServers() ([]api.Server, error) {
+	query := `
+		SELECT
+			server_id,
+			name,
+			host,
+			port,
+			username,
+			password,
+			proxy_host,
+			proxy_username,
+			proxy_identity,
+			schedule,
+			tags,
+			binlog,
+			database_include,
+			database_exclude,
+			new_database_policy,
+			report_recipients
+		FROM servers
+		ORDER BY name ASC
+	`
+	rows, err := s.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	servers := make([]api.Server, 0, 100)
+	for rows.Next() {
+		var v api.Server
 		rows.Scan(
 			&v.Id,
 			&v.Name,
@@ -318,23 +1051,110 @@ func (s *storage) ListServers() ([]api.Server, error) {
 			&v.ProxyHost,
 			&v.ProxyUsername,
 			&v.ProxyIdentity,
+			&v.Schedule,
+			&v.Tags,
+			&v.Binlog,
+			&v.DatabaseInclude,
+			&v.DatabaseExclude,
+			&v.NewDatabasePolicy,
+			&v.ReportRecipients,
 		)
 		servers = append(servers, v)
 	}
 	return servers, nil
 }
 
-func (s *storage) RunMigrations() error {
-	for _, migration := range migrations {
-		run, err := migration.check(s.db)
+func (s *storage) ListSettings() ([]api.Setting, error) {
+	query := `
+		SELECT
+			key,
+			value
+		FROM settings
+		ORDER BY key ASC
+	`
+	rows, err := s.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	settings := make([]api.Setting, 0, 100)
+	for rows.Next() {
+		var setting api.Setting
+		if err := rows.Scan(&setting.Key, &setting.Value); err != nil {
+			return nil, err
+		}
+		settings = append(settings, setting)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return settings, nil
+}
+
+func (s *storage) ListWebhooks() ([]api.Webhook, error) {
+	query := `
+		SELECT
+			webhook_id,
+			name,
+			url,
+			format,
+			events,
+			servers,
+			added
+		FROM webhooks
+		ORDER BY name ASC
+	`
+	rows, err := s.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	webhooks := make([]api.Webhook, 0, 10)
+	for rows.Next() {
+		var webhook api.Webhook
+		err := rows.Scan(
+			&webhook.Id,
+			&webhook.Name,
+			&webhook.URL,
+			&webhook.Format,
+			&webhook.Events,
+			&webhook.Servers,
+			&webhook.Added,
+		)
 		if err != nil {
-			return err
+			return nil, err
 		}
-		if !run {
+		webhooks = append(webhooks, webhook)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return webhooks, nil
+}
+
+// RunMigrations brings the schema up to date, running each pending migration
+// in its own transaction. Installs from before schema_migrations are first
+// baselined: the migrations their schema already has are recorded, not run.
+func (s *storage) RunMigrations() error {
+	if err := s.baselineMigrations(); err != nil {
+		return fmt.Errorf("baselining migrations: %w", err)
+	}
+
+	applied, err := s.appliedMigrations()
+	if err != nil {
+		return err
+	}
+
+	for _, migration := range s.dialect.migrations {
+		if _, ok := applied[migration.version]; ok {
 			continue
 		}
-		if _, err := s.db.Exec(migration.sql); err != nil {
-			return err
+		if err := s.runMigration(migration); err != nil {
+			return fmt.Errorf("migration %d (%s): %w", migration.version, migration.description, err)
 		}
 	}
 	return nil
@@ -353,22 +1173,61 @@ func (s *storage) ServerTree() ([]api.Tree, error) {
 		if err != nil {
 			return nil, err
 		}
+		for _, db := range trees[i].Databases {
+			if db.Pending {
+				trees[i].Pending++
+			}
+		}
 	}
 
 	return trees, nil
 }
 
+func (s *storage) SetSetting(setting api.Setting) error {
+	update := `UPDATE settings SET value = $1 WHERE key = $2`
+	insert := `INSERT INTO settings (key, value) VALUES ($1, $2)`
+
+	result, err := s.db.Exec(update, setting.Value, setting.Key)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected > 0 {
+		return nil
+	}
+
+	_, err = s.db.Exec(insert, setting.Key, setting.Value)
+	return err
+}
+
+// UpdateDatabase replaces the database's table rules along with the rest of
+// its settings. Saving a database pending review counts as reviewing it.
 func (s *storage) UpdateDatabase(id int, db api.UpdateDatabaseRequest) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
 	query := `
 		UPDATE databases SET
-			server_id      = $1,
-			name           = $2,
-			backup         = $3,
-			only_tables    = $4,
-			exclude_tables = $5
-		WHERE database_id = $6
+			server_id  = $1,
+			name       = $2,
+			backup     = $3,
+			schedule   = $4,
+			tags       = $5,
+			timeout    = $6,
+			max_age    = $7,
+			dump_mode  = $8,
+			table_mode = $9,
+			pending    = FALSE
+		WHERE database_id = $10
 	`
-	stmt, err := s.db.Prepare(query)
+	stmt, err := tx.Prepare(query)
 	if err != nil {
 		return err
 	}
@@ -378,8 +1237,72 @@ func (s *storage) UpdateDatabase(id int, db api.UpdateDatabaseRequest) error {
 		db.ServerId,
 		db.Name,
 		db.Backup,
-		db.OnlyTables,
-		db.ExcludeTables,
+		db.Schedule,
+		db.Tags,
+		db.Timeout,
+		db.MaxAge,
+		db.DumpMode,
+		db.TableMode,
+		id,
+	)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(`DELETE FROM table_rules WHERE database_id = $1`, id); err != nil {
+		return err
+	}
+
+	query = `
+		INSERT INTO table_rules (
+			database_id,
+			table_name,
+			mode,
+			predicate,
+			position
+		) VALUES ($1, $2, $3, $4, $5)
+	`
+	ruleStmt, err := tx.Prepare(query)
+	if err != nil {
+		return err
+	}
+	defer ruleStmt.Close()
+
+	for i, rule := range db.Tables {
+		if _, err := ruleStmt.Exec(id, rule.Table, rule.Mode, rule.Where, i); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (s *storage) UpdateLog(id int, log api.Log) error {
+	query := `
+		UPDATE logs SET
+			backup_start  = $1,
+			backup_end    = $2,
+			size_previous = $3,
+			size_current  = $4,
+			status        = $5,
+			error         = $6,
+			warning       = $7
+		WHERE log_id = $8
+	`
+	stmt, err := s.db.Prepare(query)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	_, err = stmt.Exec(
+		log.BackupStart,
+		log.BackupEnd,
+		log.SizePrevious,
+		log.SizeCurrent,
+		log.Status,
+		log.Error,
+		log.Warning,
 		id,
 	)
 	if err != nil {
@@ -392,15 +1315,22 @@ func (s *storage) UpdateDatabase(id int, db api.UpdateDatabaseRequest) error {
 func (s *storage) UpdateServer(id int, server api.NewServerRequest) error {
 	query := `
 		UPDATE servers SET
-			name           = $1,
-			host           = $2,
-			port           = $3,
-			username       = $4,
-			password       = $5,
-			proxy_host     = $6,
-			proxy_username = $7,
-			proxy_identity = $8
-		WHERE server_id = $9
+			name                = $1,
+			host                = $2,
+			port                = $3,
+			username            = $4,
+			password            = $5,
+			proxy_host          = $6,
+			proxy_username      = $7,
+			proxy_identity      = $8,
+			schedule            = $9,
+			tags                = $10,
+			binlog              = $11,
+			database_include    = $12,
+			database_exclude    = $13,
+			new_database_policy = $14,
+			report_recipients   = $15
+		WHERE server_id = $16
 	`
 	stmt, err := s.db.Prepare(query)
 	if err != nil {
@@ -417,6 +1347,13 @@ func (s *storage) UpdateServer(id int, server api.NewServerRequest) error {
 		server.ProxyHost,
 		server.ProxyUsername,
 		server.ProxyIdentity,
+		server.Schedule,
+		server.Tags,
+		server.Binlog,
+		server.DatabaseInclude,
+		server.DatabaseExclude,
+		server.NewDatabasePolicy,
+		server.ReportRecipients,
 		id,
 	)
 	if err != nil {
@@ -426,10 +1363,15 @@ func (s *storage) UpdateServer(id int, server api.NewServerRequest) error {
 	return nil
 }
 
-func (s *storage) UpdateServerDatabases(id int, names []string) error {
+func (s *storage) UpdateServerDatabases(id int, databases []api.NewDatabaseRequest) (added []string, removed []string, err error) {
 	existing, err := s.ListDatabases(id)
 	if err != nil {
-		return err
+		return
+	}
+
+	names := make([]string, len(databases))
+	for i, db := range databases {
+		names[i] = db.Name
 	}
 
 	existingNames := make([]string, len(existing))
@@ -440,23 +1382,21 @@ func (s *storage) UpdateServerDatabases(id int, names []string) error {
 	// Database list is already sorted by name, but verify for the sake of the
 	// following steps
 	if !sort.StringsAreSorted(existingNames) {
-		return errors.New("database names are not sorted")
+		return nil, nil, errors.New("database names are not sorted")
 	}
 
 	// Add new databases
-	for _, name := range names {
+	for _, newDb := range databases {
 		// Skip names that already exist
-		if x := sort.SearchStrings(existingNames, name); x < len(existingNames) && existingNames[x] == name {
+		if x := sort.SearchStrings(existingNames, newDb.Name); x < len(existingNames) && existingNames[x] == newDb.Name {
 			continue
 		}
 
-		newDb := api.NewDatabaseRequest{
-			Name:     name,
-			ServerId: id,
-		}
-		if err := s.CreateDatabase(newDb); err != nil {
-			return err
+		newDb.ServerId = id
+		if err = s.CreateDatabase(newDb); err != nil {
+			return
 		}
+		added = append(added, newDb.Name)
 	}
 
 	// Mark removed databases
@@ -471,9 +1411,265 @@ func (s *storage) UpdateServerDatabases(id int, names []string) error {
 			continue
 		}
 
-		if err := s.DeleteDatabase(db.Id); err != nil {
-			return err
+		if err = s.DeleteDatabase(db.Id); err != nil {
+			return
 		}
+		removed = append(removed, db.Name)
 	}
+	return
+}
+
+func (s *storage) UpdateWebhook(id int, webhook api.NewWebhookRequest) error {
+	query := `
+		UPDATE webhooks SET
+			name    = $1,
+			url     = $2,
+			format  = $3,
+			events  = $4,
+			servers = $5
+		WHERE webhook_id = $6
+	`
+	stmt, err := s.db.Prepare(query)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	_, err = stmt.Exec(
+		webhook.Name,
+		webhook.URL,
+		webhook.Format,
+		webhook.Events,
+		webhook.Servers,
+		id,
+	)
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
+
+// appliedMigrations returns the migrations recorded in schema_migrations by
+// version
+func (s *storage) appliedMigrations() (map[int]api.Migration, error) {
+	query := `
+		SELECT
+			version,
+			description,
+			applied,
+			baseline
+		FROM schema_migrations
+		ORDER BY version
+	`
+	rows, err := s.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int]api.Migration)
+	for rows.Next() {
+		var migration api.Migration
+		var at time.Time
+		err := rows.Scan(
+			&migration.Version,
+			&migration.Description,
+			&at,
+			&migration.Baseline,
+		)
+		if err != nil {
+			return nil, err
+		}
+		migration.Applied = &at
+		applied[migration.Version] = migration
+	}
+	return applied, rows.Err()
+}
+
+// baselineMigrations creates schema_migrations if it is missing, recording
+// the migrations an existing schema already has. A new database has none of
+// them, so nothing is recorded.
+func (s *storage) baselineMigrations() error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	recorded, err := s.dialect.tableExists("schema_migrations")(tx)
+	if err != nil || recorded {
+		return err
+	}
+
+	if _, err := tx.Exec(s.dialect.schemaMigrations); err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO schema_migrations (
+			version,
+			description,
+			applied,
+			baseline
+		) VALUES ($1, $2, $3, $4)
+	`
+	now := time.Now()
+	for _, migration := range s.dialect.migrations {
+		if migration.present == nil {
+			continue
+		}
+		present, err := migration.present(tx)
+		if err != nil {
+			return err
+		}
+		if !present {
+			continue
+		}
+		if _, err := tx.Exec(query, migration.version, migration.description, now, true); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// databases returns the databases matching where, with their table rules,
+// ordered by server and name
+func (s *storage) databases(where string, args ...interface{}) ([]api.Database, error) {
+	query := `
+		SELECT
+			database_id,
+			server_id,
+			name,
+			backup,
+			schedule,
+			tags,
+			timeout,
+			max_age,
+			dump_mode,
+			table_mode,
+			pending,
+			added,
+			removed
+		FROM databases
+		` + where + `
+		ORDER BY server_id ASC, name ASC
+	`
+	stmt, err := s.db.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.Query(args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	dbs := make([]api.Database, 0, 1000)
+	for rows.Next() {
+		var db api.Database
+		err := rows.Scan(
+			&db.Id,
+			&db.ServerId,
+			&db.Name,
+			&db.Backup,
+			&db.Schedule,
+			&db.Tags,
+			&db.Timeout,
+			&db.MaxAge,
+			&db.DumpMode,
+			&db.TableMode,
+			&db.Pending,
+			&db.Added,
+			&db.Removed,
+		)
+		if err != nil {
+			return nil, err
+		}
+		dbs = append(dbs, db)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rules, err := s.tableRules(`WHERE database_id IN (SELECT database_id FROM databases `+where+`)`, args...)
+	if err != nil {
+		return nil, err
+	}
+	for i := range dbs {
+		dbs[i].Tables = rules[dbs[i].Id]
+	}
+
+	return dbs, nil
+}
+
+// runMigration makes a migration and records it in one transaction, so a
+// failure leaves neither behind
+func (s *storage) runMigration(migration migration) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(migration.sql); err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO schema_migrations (
+			version,
+			description,
+			applied
+		) VALUES ($1, $2, $3)
+	`
+	if _, err := tx.Exec(query, migration.version, migration.description, time.Now()); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// tableRules returns the table rules matching where, keyed by database and in
+// the order they are applied
+func (s *storage) tableRules(where string, args ...interface{}) (map[int][]api.TableRule, error) {
+	query := `
+		SELECT
+			database_id,
+			table_name,
+			mode,
+			predicate
+		FROM table_rules
+		` + where + `
+		ORDER BY position ASC
+	`
+	stmt, err := s.db.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.Query(args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rules := make(map[int][]api.TableRule)
+	for rows.Next() {
+		var databaseId int
+		var rule api.TableRule
+		if err := rows.Scan(&databaseId, &rule.Table, &rule.Mode, &rule.Where); err != nil {
+			return nil, err
+		}
+		rules[databaseId] = append(rules[databaseId], rule)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return rules, nil
+}
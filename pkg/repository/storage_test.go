@@ -12,22 +12,46 @@ import (
 	_ "github.com/mattn/go-sqlite3"
 )
 
-func TestDatabaseDateTime(t *testing.T) {
+// openPostgres returns empty PostgreSQL storage for a test. It is only set
+// when DATABASE_BACKUP_TEST_POSTGRES names a server or initdb and pg_ctl can
+// be found to start one.
+var openPostgres func(t *testing.T) repository.Storage
+
+// openSQLite returns storage on an empty in-memory SQLite database
+func openSQLite(t *testing.T) repository.Storage {
 	db, err := sql.Open("sqlite3", ":memory:")
 	assert.Nil(t, err)
+	// Every connection would get its own in-memory database
+	db.SetMaxOpenConns(1)
+	return repository.NewStorage(db)
+}
 
-	storage := repository.NewStorage(db)
-	assert.Nil(t, storage.RunMigrations())
-
-	newDb := api.NewDatabaseRequest{
-		Name: "test",
+// backends runs test against SQLite and, when available, PostgreSQL
+func backends(t *testing.T, test func(t *testing.T, storage repository.Storage)) {
+	t.Run("sqlite", func(t *testing.T) {
+		test(t, openSQLite(t))
+	})
+	if openPostgres != nil {
+		t.Run("postgres", func(t *testing.T) {
+			test(t, openPostgres(t))
+		})
 	}
-	assert.Nil(t, storage.CreateDatabase(newDb))
+}
 
-	getDb, err := storage.GetDatabase(1)
-	assert.Nil(t, err)
-	assert.That(t, getDb.Name == "test")
-	assert.False(t, getDb.Added.IsZero())
+func TestDatabaseDateTime(t *testing.T) {
+	backends(t, func(t *testing.T, storage repository.Storage) {
+		assert.Nil(t, storage.RunMigrations())
+
+		newDb := api.NewDatabaseRequest{
+			Name: "test",
+		}
+		assert.Nil(t, storage.CreateDatabase(newDb))
+
+		getDb, err := storage.GetDatabase(1)
+		assert.Nil(t, err)
+		assert.That(t, getDb.Name == "test")
+		assert.False(t, getDb.Added.IsZero())
+	})
 }
 
 func TestDateTime(t *testing.T) {
@@ -55,3 +79,86 @@ func TestDateTime(t *testing.T) {
 	assert.Nil(t, err)
 	assert.False(t, row.Date.IsZero())
 }
+
+func TestUpdateServerDatabases(t *testing.T) {
+	backends(t, func(t *testing.T, storage repository.Storage) {
+		assert.Nil(t, storage.RunMigrations())
+
+		id, err := storage.CreateServer(api.NewServerRequest{Name: "db1"})
+		assert.Nil(t, err)
+
+		added, removed, err := storage.UpdateServerDatabases(id, []api.NewDatabaseRequest{
+			{Name: "mysql", Backup: false},
+			{Name: "scratch", Pending: true},
+			{Name: "shop", Backup: true},
+		})
+		assert.Nil(t, err)
+		assert.DeepEqual(t, added, []string{"mysql", "scratch", "shop"})
+		assert.Equal(t, len(removed), 0)
+
+		databases, err := storage.ListDatabases(id)
+		assert.Nil(t, err)
+		assert.Equal(t, len(databases), 3)
+		assert.Equal(t, databases[0].Name, "mysql")
+		assert.False(t, databases[0].Backup)
+		assert.Nil(t, databases[0].Removed)
+		assert.Equal(t, databases[2].Name, "shop")
+		assert.That(t, databases[2].Backup)
+
+		pending, err := storage.ListPendingDatabases()
+		assert.Nil(t, err)
+		assert.Equal(t, len(pending), 1)
+		assert.Equal(t, pending[0].Name, "scratch")
+
+		added, removed, err = storage.UpdateServerDatabases(id, []api.NewDatabaseRequest{
+			{Name: "mysql"},
+			{Name: "shop"},
+		})
+		assert.Nil(t, err)
+		assert.Equal(t, len(added), 0)
+		assert.DeepEqual(t, removed, []string{"scratch"})
+
+		pending, err = storage.ListPendingDatabases()
+		assert.Nil(t, err)
+		assert.Equal(t, len(pending), 0)
+	})
+}
+
+func TestListBackupStats(t *testing.T) {
+	backends(t, func(t *testing.T, storage repository.Storage) {
+		assert.Nil(t, storage.RunMigrations())
+
+		id, err := storage.CreateServer(api.NewServerRequest{Name: "db1"})
+		assert.Nil(t, err)
+		_, _, err = storage.UpdateServerDatabases(id, []api.NewDatabaseRequest{
+			{Name: "crm", Backup: true},
+			{Name: "shop", Backup: true},
+		})
+		assert.Nil(t, err)
+
+		start := time.Date(2022, 3, 1, 2, 0, 0, 0, time.UTC)
+		end := start.Add(90 * time.Second)
+		logs := []api.Log{
+			{DatabaseId: 2, BackupStart: &start, BackupEnd: &end, SizeCurrent: 100, Status: api.LogStatusSuccess},
+			{DatabaseId: 2, BackupStart: &start, BackupEnd: &end, Status: api.LogStatusFailed},
+			{DatabaseId: 2, BackupStart: &start, BackupEnd: &end, Status: api.LogStatusCancelled},
+			{DatabaseId: 1, BackupStart: &start, BackupEnd: &end, Status: api.LogStatusTimeout},
+		}
+		for _, log := range logs {
+			_, err := storage.CreateLog(log)
+			assert.Nil(t, err)
+		}
+
+		stats, err := storage.ListBackupStats()
+		assert.Nil(t, err)
+		assert.Equal(t, len(stats), 2)
+		assert.Equal(t, stats[0].Database, "crm")
+		assert.Nil(t, stats[0].LastSuccess)
+		assert.Equal(t, stats[0].Failures, 1)
+		assert.Equal(t, stats[1].Database, "shop")
+		assert.That(t, stats[1].LastSuccess.Equal(end))
+		assert.Equal(t, stats[1].LastDuration, 90*time.Second)
+		assert.Equal(t, stats[1].LastSize, int64(100))
+		assert.Equal(t, stats[1].Failures, 1)
+	})
+}
@@ -0,0 +1,42 @@
+-- The schema of an early install, from before schedules, with table lists
+-- still kept on the databases
+CREATE TABLE servers (
+	server_id      INTEGER PRIMARY KEY,
+	name           TEXT NOT NULL,
+	host           TEXT NOT NULL,
+	port           TEXT NOT NULL,
+	username       TEXT NOT NULL,
+	password       TEXT NOT NULL DEFAULT '',
+	proxy_host     TEXT NOT NULL DEFAULT '',
+	proxy_username TEXT NOT NULL DEFAULT '',
+	proxy_identity TEXT NOT NULL DEFAULT ''
+);
+
+CREATE TABLE databases (
+	database_id    INTEGER PRIMARY KEY,
+	server_id      INTEGER NOT NULL,
+	name           TEXT NOT NULL,
+	only_tables    TEXT NOT NULL DEFAULT '', -- Space-separated list
+	exclude_tables TEXT NOT NULL DEFAULT '', -- Space-separated list
+	backup         INTEGER NOT NULL DEFAULT 1,
+	added          DATETIME NOT NULL,
+	removed        DATETIME,
+	UNIQUE (server_id, name),
+	FOREIGN KEY (server_id) REFERENCES servers (server_id)
+);
+
+CREATE TABLE logs (
+	log_id        INTEGER PRIMARY KEY,
+	database_id   INTEGER NOT NULL,
+	backup_start  DATETIME NULL,
+	backup_end    DATETIME NULL,
+	size_previous INTEGER NOT NULL DEFAULT 0,
+	size_current  INTEGER NOT NULL DEFAULT 0,
+	added         DATETIME NOT NULL,
+	FOREIGN KEY (database_id) REFERENCES servers (database_id)
+);
+
+INSERT INTO servers (server_id, name, host, port, username) VALUES (1, 'db1', 'db1.example.com', '3306', 'backup');
+INSERT INTO databases (database_id, server_id, name, only_tables, exclude_tables, added) VALUES (1, 1, 'shop', 'orders customers', '', '2022-03-01 02:00:00');
+INSERT INTO databases (database_id, server_id, name, only_tables, exclude_tables, added) VALUES (2, 1, 'crm', '', 'sessions', '2022-03-01 02:00:00');
+INSERT INTO logs (database_id, backup_start, backup_end, size_current, added) VALUES (1, '2022-03-01 02:00:00', '2022-03-01 02:01:30', 100, '2022-03-01 02:00:00');
@@ -0,0 +1,99 @@
+-- The schema of an install from before schema_migrations, as left by the last
+-- of the table and column checks, with a little data
+CREATE TABLE servers (
+	server_id      INTEGER PRIMARY KEY,
+	name           TEXT NOT NULL,
+	host           TEXT NOT NULL,
+	port           TEXT NOT NULL,
+	username       TEXT NOT NULL,
+	password       TEXT NOT NULL DEFAULT '',
+	proxy_host     TEXT NOT NULL DEFAULT '',
+	proxy_username TEXT NOT NULL DEFAULT '',
+	proxy_identity TEXT NOT NULL DEFAULT '',
+	schedule TEXT NOT NULL DEFAULT '', tags TEXT NOT NULL DEFAULT '', binlog TEXT NOT NULL DEFAULT '', database_include TEXT NOT NULL DEFAULT '', database_exclude TEXT NOT NULL DEFAULT '', new_database_policy TEXT NOT NULL DEFAULT 'enable', report_recipients TEXT NOT NULL DEFAULT ''
+);
+
+CREATE TABLE databases (
+	database_id    INTEGER PRIMARY KEY,
+	server_id      INTEGER NOT NULL,
+	name           TEXT NOT NULL,
+	backup         INTEGER NOT NULL DEFAULT 1,
+	added          DATETIME NOT NULL,
+	removed        DATETIME, schedule TEXT NOT NULL DEFAULT '', tags TEXT NOT NULL DEFAULT '', timeout TEXT NOT NULL DEFAULT '', dump_mode TEXT NOT NULL DEFAULT 'single', table_mode TEXT NOT NULL DEFAULT 'full', pending INTEGER NOT NULL DEFAULT 0, max_age TEXT NOT NULL DEFAULT '',
+	UNIQUE (server_id, name),
+	FOREIGN KEY (server_id) REFERENCES servers (server_id)
+);
+
+CREATE TABLE logs (
+	log_id        INTEGER PRIMARY KEY,
+	database_id   INTEGER NOT NULL,
+	backup_start  DATETIME NULL,
+	backup_end    DATETIME NULL,
+	size_previous INTEGER NOT NULL DEFAULT 0,
+	size_current  INTEGER NOT NULL DEFAULT 0,
+	added         DATETIME NOT NULL, status TEXT NOT NULL DEFAULT '', error TEXT NOT NULL DEFAULT '', warning TEXT NOT NULL DEFAULT '',
+	FOREIGN KEY (database_id) REFERENCES servers (database_id)
+);
+
+CREATE TABLE locks (
+	name     TEXT PRIMARY KEY,
+	pid      INTEGER NOT NULL,
+	hostname TEXT NOT NULL,
+	acquired DATETIME NOT NULL
+);
+
+CREATE TABLE settings (
+	key   TEXT PRIMARY KEY,
+	value TEXT NOT NULL
+);
+
+CREATE TABLE attempts (
+	attempt_id INTEGER PRIMARY KEY,
+	log_id     INTEGER NULL,
+	server_id  INTEGER NOT NULL,
+	step       TEXT NOT NULL,
+	number     INTEGER NOT NULL,
+	started    DATETIME NOT NULL,
+	ended      DATETIME NOT NULL,
+	error      TEXT NOT NULL DEFAULT '',
+	FOREIGN KEY (log_id) REFERENCES logs (log_id),
+	FOREIGN KEY (server_id) REFERENCES servers (server_id)
+);
+
+CREATE TABLE binlogs (
+	binlog_id INTEGER PRIMARY KEY,
+	server_id INTEGER NOT NULL,
+	name      TEXT NOT NULL,
+	key       TEXT NOT NULL,
+	size      INTEGER NOT NULL,
+	sha256    TEXT NOT NULL,
+	started   DATETIME NOT NULL,
+	added     DATETIME NOT NULL,
+	UNIQUE (server_id, name),
+	FOREIGN KEY (server_id) REFERENCES servers (server_id)
+);
+
+CREATE TABLE table_rules (
+	rule_id     INTEGER PRIMARY KEY,
+	database_id INTEGER NOT NULL,
+	table_name  TEXT NOT NULL,
+	mode        TEXT NOT NULL, position INTEGER NOT NULL DEFAULT 0, predicate TEXT NOT NULL DEFAULT '',
+	UNIQUE (database_id, table_name),
+	FOREIGN KEY (database_id) REFERENCES databases (database_id)
+);
+
+CREATE TABLE webhooks (
+	webhook_id INTEGER PRIMARY KEY,
+	name       TEXT NOT NULL,
+	url        TEXT NOT NULL,
+	format     TEXT NOT NULL DEFAULT 'json',
+	events     TEXT NOT NULL DEFAULT '',
+	servers    TEXT NOT NULL DEFAULT '',
+	added      DATETIME NOT NULL
+);
+
+INSERT INTO servers (server_id, name, host, port, username, tags) VALUES (1, 'db1', 'db1.example.com', '3306', 'backup', 'prod');
+INSERT INTO databases (database_id, server_id, name, added, dump_mode, max_age) VALUES (1, 1, 'shop', '2022-03-01 02:00:00', 'per-table', '48h');
+INSERT INTO table_rules (database_id, table_name, mode, position) VALUES (1, 'cache_*', 'skip', 1);
+INSERT INTO logs (database_id, backup_start, backup_end, size_current, added, status) VALUES (1, '2022-03-01 02:00:00', '2022-03-01 02:01:30', 100, '2022-03-01 02:00:00', 'success');
+INSERT INTO settings (key, value) VALUES ('max_age', '24h');
@@ -0,0 +1,240 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Name of the instrumentation scope reported with every span
+const scopeName = "github.com/jbaikge/database-backups"
+
+// OTLP status codes and span kind
+const (
+	otlpStatusOk       = 1
+	otlpStatusError    = 2
+	otlpKindInternal   = 1
+	otlpRequestTimeout = 30 * time.Second
+)
+
+// NewExporter returns the exporter for target: stdout, or the base URL of an
+// OTLP/HTTP collector
+func NewExporter(target string, service string) (Exporter, error) {
+	if target == "stdout" {
+		return NewStdout(os.Stdout), nil
+	}
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("trace target must be stdout or an http(s) URL: %s", target)
+	}
+	return NewOTLP(target, service), nil
+}
+
+// OTLP sends spans to an OpenTelemetry collector over OTLP/HTTP using the
+// JSON encoding
+type OTLP struct {
+	endpoint string
+	service  string
+	client   *http.Client
+}
+
+// NewOTLP exports to endpoint, the collector's base URL such as
+// http://localhost:4318, with spans reported under the service name
+func NewOTLP(endpoint string, service string) *OTLP {
+	return &OTLP{
+		endpoint: strings.TrimSuffix(endpoint, "/") + "/v1/traces",
+		service:  service,
+		client:   &http.Client{Timeout: otlpRequestTimeout},
+	}
+}
+
+func (e *OTLP) Export(ctx context.Context, spans []SpanData) error {
+	body, err := json.Marshal(otlpRequest(e.service, spans))
+	if err != nil {
+		return err
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, e.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+
+	response, err := e.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode/100 != 2 {
+		message, _ := ioutil.ReadAll(io.LimitReader(response.Body, 512))
+		return fmt.Errorf("exporting spans to %s: %s: %s", e.endpoint, response.Status, strings.TrimSpace(string(message)))
+	}
+	return nil
+}
+
+// Stdout writes each span as a line of JSON, for trying tracing out without
+// a collector
+type Stdout struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func NewStdout(w io.Writer) *Stdout {
+	return &Stdout{w: w}
+}
+
+type stdoutSpan struct {
+	TraceID    string                 `json:"trace_id"`
+	SpanID     string                 `json:"span_id"`
+	ParentID   string                 `json:"parent_id,omitempty"`
+	Name       string                 `json:"name"`
+	Start      time.Time              `json:"start"`
+	End        time.Time              `json:"end"`
+	Duration   string                 `json:"duration"`
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+	Error      string                 `json:"error,omitempty"`
+}
+
+func (e *Stdout) Export(ctx context.Context, spans []SpanData) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	encoder := json.NewEncoder(e.w)
+	for _, span := range spans {
+		line := stdoutSpan{
+			TraceID:  span.TraceID.String(),
+			SpanID:   span.SpanID.String(),
+			Name:     span.Name,
+			Start:    span.Start,
+			End:      span.End,
+			Duration: span.End.Sub(span.Start).String(),
+			Error:    span.Error,
+		}
+		if span.ParentID.IsValid() {
+			line.ParentID = span.ParentID.String()
+		}
+		if len(span.Attributes) > 0 {
+			line.Attributes = make(map[string]interface{}, len(span.Attributes))
+			for _, attribute := range span.Attributes {
+				line.Attributes[attribute.Key] = attribute.Value
+			}
+		}
+		if err := encoder.Encode(line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// The subset of the OTLP trace request used here. 64-bit integers are
+// strings in OTLP's JSON encoding, and IDs are hex.
+type otlpTraces struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            otlpStatus      `json:"status"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpAttribute struct {
+	Key   string                 `json:"key"`
+	Value map[string]interface{} `json:"value"`
+}
+
+func otlpRequest(service string, spans []SpanData) otlpTraces {
+	converted := make([]otlpSpan, len(spans))
+	for i, span := range spans {
+		converted[i] = otlpSpan{
+			TraceID:           span.TraceID.String(),
+			SpanID:            span.SpanID.String(),
+			Name:              span.Name,
+			Kind:              otlpKindInternal,
+			StartTimeUnixNano: strconv.FormatInt(span.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(span.End.UnixNano(), 10),
+			Attributes:        otlpAttributes(span.Attributes),
+			Status:            otlpStatus{Code: otlpStatusOk},
+		}
+		if span.ParentID.IsValid() {
+			converted[i].ParentSpanID = span.ParentID.String()
+		}
+		if span.Error != "" {
+			converted[i].Status = otlpStatus{Code: otlpStatusError, Message: span.Error}
+		}
+	}
+
+	return otlpTraces{
+		ResourceSpans: []otlpResourceSpans{{
+			Resource: otlpResource{
+				Attributes: otlpAttributes([]Attribute{String("service.name", service)}),
+			},
+			ScopeSpans: []otlpScopeSpans{{
+				Scope: otlpScope{Name: scopeName},
+				Spans: converted,
+			}},
+		}},
+	}
+}
+
+// otlpAttributes encodes values as OTLP AnyValues
+func otlpAttributes(attributes []Attribute) []otlpAttribute {
+	converted := make([]otlpAttribute, len(attributes))
+	for i, attribute := range attributes {
+		var value map[string]interface{}
+		switch v := attribute.Value.(type) {
+		case bool:
+			value = map[string]interface{}{"boolValue": v}
+		case float64:
+			value = map[string]interface{}{"doubleValue": v}
+		case int64:
+			value = map[string]interface{}{"intValue": strconv.FormatInt(v, 10)}
+		default:
+			value = map[string]interface{}{"stringValue": fmt.Sprint(v)}
+		}
+		converted[i] = otlpAttribute{Key: attribute.Key, Value: value}
+	}
+	return converted
+}
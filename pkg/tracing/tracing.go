@@ -0,0 +1,256 @@
+// Package tracing records spans for the stages of a backup and exports them
+// in batches, as OTLP/HTTP JSON to a collector or as JSON lines for testing.
+// Spans travel in contexts; until a Provider is set every span is a no-op.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// Spans are exported once this many have ended, or every exportInterval
+const (
+	batchSize      = 256
+	exportInterval = 5 * time.Second
+)
+
+type Attribute struct {
+	Key   string
+	Value interface{}
+}
+
+func Bool(key string, value bool) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+func Float64(key string, value float64) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+func Int(key string, value int) Attribute {
+	return Attribute{Key: key, Value: int64(value)}
+}
+
+func Int64(key string, value int64) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+func String(key string, value string) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+type TraceID [16]byte
+
+func (id TraceID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+type SpanID [8]byte
+
+func (id SpanID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+func (id SpanID) IsValid() bool {
+	return id != SpanID{}
+}
+
+// SpanData is a finished span as handed to an Exporter
+type SpanData struct {
+	TraceID    TraceID
+	SpanID     SpanID
+	ParentID   SpanID
+	Name       string
+	Start      time.Time
+	End        time.Time
+	Attributes []Attribute
+	// Empty unless the span failed
+	Error string
+}
+
+type Exporter interface {
+	Export(context.Context, []SpanData) error
+}
+
+// Span is one timed stage. A nil *Span, as returned while tracing is off, is
+// safe to use and records nothing.
+type Span struct {
+	mu       sync.Mutex
+	provider *Provider
+	data     SpanData
+	ended    bool
+}
+
+func (s *Span) SetAttributes(attributes ...Attribute) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Attributes = append(s.data.Attributes, attributes...)
+}
+
+// RecordError marks the span failed. A nil error is ignored.
+func (s *Span) RecordError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Error = err.Error()
+}
+
+// End finishes the span and queues it for export. Only the first call counts.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = time.Now()
+	data := s.data
+	s.mu.Unlock()
+
+	s.provider.add(data)
+}
+
+// Provider batches finished spans and hands them to its exporter
+type Provider struct {
+	exporter Exporter
+	errors   func(error)
+
+	mu      sync.Mutex
+	pending []SpanData
+	flush   chan struct{}
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+// NewProvider starts exporting spans in the background. Export failures are
+// passed to errors, as there is no caller left to return them to.
+func NewProvider(exporter Exporter, errors func(error)) *Provider {
+	p := &Provider{
+		exporter: exporter,
+		errors:   errors,
+		pending:  make([]SpanData, 0, batchSize),
+		flush:    make(chan struct{}, 1),
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	go p.run()
+	return p
+}
+
+// Start begins a span as a child of the one in ctx, or as the root of a new
+// trace
+func (p *Provider) Start(ctx context.Context, name string, attributes ...Attribute) (context.Context, *Span) {
+	span := &Span{
+		provider: p,
+		data: SpanData{
+			Name:       name,
+			Start:      time.Now(),
+			Attributes: attributes,
+		},
+	}
+	if parent := SpanFromContext(ctx); parent != nil {
+		span.data.TraceID = parent.data.TraceID
+		span.data.ParentID = parent.data.SpanID
+	} else {
+		rand.Read(span.data.TraceID[:])
+	}
+	rand.Read(span.data.SpanID[:])
+	return ContextWithSpan(ctx, span), span
+}
+
+// Shutdown stops the background export and exports whatever is left
+func (p *Provider) Shutdown(ctx context.Context) error {
+	close(p.done)
+	<-p.stopped
+	return p.export(ctx)
+}
+
+func (p *Provider) add(data SpanData) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.pending = append(p.pending, data)
+	if len(p.pending) >= batchSize {
+		select {
+		case p.flush <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (p *Provider) run() {
+	defer close(p.stopped)
+	ticker := time.NewTicker(exportInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+		case <-p.flush:
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), exportInterval)
+		if err := p.export(ctx); err != nil && p.errors != nil {
+			p.errors(err)
+		}
+		cancel()
+	}
+}
+
+func (p *Provider) export(ctx context.Context) error {
+	p.mu.Lock()
+	spans := p.pending
+	p.pending = make([]SpanData, 0, batchSize)
+	p.mu.Unlock()
+
+	if len(spans) == 0 {
+		return nil
+	}
+	return p.exporter.Export(ctx, spans)
+}
+
+var (
+	providerMu     sync.RWMutex
+	globalProvider *Provider
+)
+
+// SetProvider turns tracing on for Start. Passing nil turns it off again.
+func SetProvider(p *Provider) {
+	providerMu.Lock()
+	defer providerMu.Unlock()
+	globalProvider = p
+}
+
+// Start begins a span with the provider set by SetProvider. While there is
+// none it returns ctx unchanged and a nil span.
+func Start(ctx context.Context, name string, attributes ...Attribute) (context.Context, *Span) {
+	providerMu.RLock()
+	p := globalProvider
+	providerMu.RUnlock()
+	if p == nil {
+		return ctx, nil
+	}
+	return p.Start(ctx, name, attributes...)
+}
+
+type contextKey struct{}
+
+func ContextWithSpan(ctx context.Context, span *Span) context.Context {
+	return context.WithValue(ctx, contextKey{}, span)
+}
+
+// SpanFromContext returns the current span, or nil when there is none
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(contextKey{}).(*Span)
+	return span
+}
@@ -0,0 +1,96 @@
+package tracing_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jbaikge/database-backups/pkg/tracing"
+	"github.com/zeebo/assert"
+)
+
+func TestOTLP(t *testing.T) {
+	var request map[string]interface{}
+	var path string
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		body, _ := ioutil.ReadAll(r.Body)
+		assert.NoError(t, json.Unmarshal(body, &request))
+	}))
+	defer collector.Close()
+
+	provider := tracing.NewProvider(tracing.NewOTLP(collector.URL+"/", "database-backup"), nil)
+	ctx, backup := provider.Start(context.Background(), "backup", tracing.String("database", "shop"))
+	_, upload := provider.Start(ctx, "upload", tracing.Int64("size_bytes", 1024), tracing.Bool("retried", false))
+	upload.RecordError(errors.New("slow down"))
+	upload.End()
+	backup.End()
+	assert.NoError(t, provider.Shutdown(context.Background()))
+
+	assert.Equal(t, path, "/v1/traces")
+	resource := request["resourceSpans"].([]interface{})[0].(map[string]interface{})
+	scope := resource["scopeSpans"].([]interface{})[0].(map[string]interface{})
+	spans := scope["spans"].([]interface{})
+	assert.Equal(t, len(spans), 2)
+
+	child := spans[0].(map[string]interface{})
+	parent := spans[1].(map[string]interface{})
+	assert.Equal(t, child["name"], "upload")
+	assert.Equal(t, child["traceId"], parent["traceId"])
+	assert.Equal(t, child["parentSpanId"], parent["spanId"])
+	assert.Nil(t, parent["parentSpanId"])
+	assert.Equal(t, len(child["traceId"].(string)), 32)
+	assert.Equal(t, child["status"], map[string]interface{}{"code": 2.0, "message": "slow down"})
+
+	attributes := child["attributes"].([]interface{})
+	assert.Equal(t, attributes[0], map[string]interface{}{"key": "size_bytes", "value": map[string]interface{}{"intValue": "1024"}})
+	assert.Equal(t, attributes[1], map[string]interface{}{"key": "retried", "value": map[string]interface{}{"boolValue": false}})
+}
+
+func TestOTLPError(t *testing.T) {
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "bad spans", http.StatusBadRequest)
+	}))
+	defer collector.Close()
+
+	provider := tracing.NewProvider(tracing.NewOTLP(collector.URL, "database-backup"), nil)
+	_, span := provider.Start(context.Background(), "backup")
+	span.End()
+	err := provider.Shutdown(context.Background())
+	assert.Error(t, err)
+	assert.That(t, strings.Contains(err.Error(), "bad spans"))
+}
+
+func TestStdout(t *testing.T) {
+	var b bytes.Buffer
+	provider := tracing.NewProvider(tracing.NewStdout(&b), nil)
+	ctx, run := provider.Start(context.Background(), "run")
+	_, dump := provider.Start(ctx, "dump", tracing.String("file", "/tmp/shop.sql"))
+	dump.End()
+	dump.End()
+	run.End()
+	assert.NoError(t, provider.Shutdown(context.Background()))
+
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	assert.Equal(t, len(lines), 2)
+	var span map[string]interface{}
+	assert.NoError(t, json.Unmarshal([]byte(lines[0]), &span))
+	assert.Equal(t, span["name"], "dump")
+	assert.Equal(t, span["attributes"], map[string]interface{}{"file": "/tmp/shop.sql"})
+	assert.NotNil(t, span["parent_id"])
+}
+
+func TestDisabled(t *testing.T) {
+	ctx, span := tracing.Start(context.Background(), "backup")
+	assert.Nil(t, span)
+	assert.Nil(t, tracing.SpanFromContext(ctx))
+	span.SetAttributes(tracing.Int("tables", 3))
+	span.RecordError(errors.New("ignored"))
+	span.End()
+}